@@ -0,0 +1,30 @@
+package main
+
+import (
+	"time"
+)
+
+// adHocChecks returns checks of urls given on the command line, run
+// instead of the config's for debugging and shell one-liners. HTTP checks
+// are healthy with the status code expect. A zero timeout means the
+// default.
+func adHocChecks(urls []string, expect int, timeout time.Duration) ([]HealthCheck, error) {
+	t, err := transportConfig{}.transport()
+	if err != nil {
+		return nil, err
+	}
+	var hs []HealthCheck
+	for _, u := range urls {
+		u, err := normalizeURL(u)
+		if err != nil {
+			return nil, err
+		}
+		h := HealthCheck{URL: u, ResponseTimeout: duration(timeout), transport: t}
+		switch h.checkType() {
+		case "http", "https":
+			h.HealthyStatusCode = expect
+		}
+		hs = append(hs, h)
+	}
+	return hs, nil
+}
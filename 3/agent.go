@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// agentClient fetches the checks of agents from the coordinator, the
+// aggregate server.
+var agentClient = &http.Client{Timeout: 30 * time.Second}
+
+// fetchChecks gets the config the coordinator at base serves to agents
+// into file, which keeps it for when the coordinator can't be reached. It
+// returns whether the file changed.
+func fetchChecks(base, file string) (bool, error) {
+	resp, err := agentClient.Get(strings.TrimSuffix(base, "/") + "/api/config")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("GET %s: %s", resp.Request.URL, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return false, err
+	}
+	if old, err := os.ReadFile(file); err == nil && bytes.Equal(old, b) {
+		return false, nil
+	}
+	// Replace the file at once, so a reload never reads half of it.
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), file)
+}
+
+// pollCoordinator fetches the agent's checks from the coordinator every
+// d.watch, for watchConfig to reload them when they changed.
+func (d *daemon) pollCoordinator() {
+	for range time.Tick(d.watch) {
+		changed, err := fetchChecks(d.coordinator, d.configFile)
+		d.report("coordinator", err)
+		if err != nil {
+			slog.Error("can't fetch checks from coordinator", "coordinator", d.coordinator, "err", err)
+		} else if changed {
+			slog.Info("checks changed at coordinator", "coordinator", d.coordinator)
+		}
+	}
+}
+
+// checkSites is the state of a check across the sites running it.
+type checkSites struct {
+	Name      string `json:",omitempty"`
+	URL       string
+	State     string   // up, down or partial, down if Quorum sites see it fail
+	Quorum    int      // see HealthCheck.Quorum
+	Healthy   []string `json:",omitempty"` // sites
+	Unhealthy []string `json:",omitempty"`
+}
+
+// bySite returns the state of each check across the sites that checked it
+// since stale, so a check that's down from one region but up from another
+// shows as partial. quorum returns the Quorum of a check by ID.
+func bySite(status []siteStatus, stale time.Time, quorum func(id string) int) []checkSites {
+	var out []checkSites
+	index := make(map[string]int)
+	for _, s := range status { // sorted by site
+		if s.LastCheck.Before(stale) {
+			continue // e.g. an agent that's gone
+		}
+		id := s.Name + "\x00" + s.URL
+		i, ok := index[id]
+		if !ok {
+			i = len(out)
+			index[id] = i
+			out = append(out, checkSites{Name: s.Name, URL: s.URL})
+		}
+		if s.Healthy {
+			out[i].Healthy = append(out[i].Healthy, s.Site)
+		} else {
+			out[i].Unhealthy = append(out[i].Unhealthy, s.Site)
+		}
+	}
+	for i, c := range out {
+		sites := len(c.Healthy) + len(c.Unhealthy)
+		q := quorum(cmp.Or(c.Name, c.URL))
+		if q <= 0 || q > sites {
+			q = sites // else a check run by fewer sites would never be down
+		}
+		out[i].Quorum = q
+		switch {
+		case len(c.Unhealthy) == 0:
+			out[i].State = "up"
+		case len(c.Unhealthy) >= q:
+			out[i].State = "down"
+		default:
+			out[i].State = "partial"
+		}
+	}
+	slices.SortFunc(out, func(x, y checkSites) int {
+		return strings.Compare(x.Name+"\x00"+x.URL, y.Name+"\x00"+y.URL)
+	})
+	return out
+}
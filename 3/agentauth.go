@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// agentAuth authenticates the sites that write results to the aggregate
+// server and fetch their checks from it, by client certificate, token or
+// both, so a rogue host can't pass itself off as a site and report it all
+// healthy. The site of the certificate is its common name, else its first
+// DNS name.
+type agentAuth struct {
+	certs  bool              // client certificates are verified and required
+	tokens map[string]string // sites by token, nil means tokens aren't required
+}
+
+func (a agentAuth) enabled() bool {
+	return a.certs || a.tokens != nil
+}
+
+// site returns the site r is authenticated as, "" if authentication is
+// disabled.
+func (a agentAuth) site(r *http.Request) (string, error) {
+	var certSite, tokenSite string
+	if a.certs {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			return "", errors.New("no client certificate")
+		}
+		leaf := r.TLS.VerifiedChains[0][0]
+		certSite = leaf.Subject.CommonName
+		if certSite == "" && len(leaf.DNSNames) > 0 {
+			certSite = leaf.DNSNames[0]
+		}
+		if certSite == "" {
+			return "", errors.New("client certificate names no site")
+		}
+	}
+	if a.tokens != nil {
+		token, ok := bearerToken(r)
+		if !ok {
+			return "", errors.New("no bearer token")
+		}
+		for t, site := range a.tokens {
+			if sameToken(t, token) {
+				tokenSite = site
+			}
+		}
+		if tokenSite == "" {
+			return "", errors.New("unknown token")
+		}
+	}
+	if certSite != "" && tokenSite != "" && certSite != tokenSite {
+		return "", fmt.Errorf("client certificate of site %s but token of site %s", certSite, tokenSite)
+	}
+	if certSite != "" {
+		return certSite, nil
+	}
+	return tokenSite, nil
+}
+
+// bearerToken returns the token of r's bearer Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	return strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// sameToken compares tokens in constant time, so their bytes can't be
+// guessed by how long it takes.
+func sameToken(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// readAgentTokens reads lines of a site and its token, separated by
+// spaces, from file. Empty lines and lines starting with # are skipped.
+func readAgentTokens(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tokens := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		site, token, ok := strings.Cut(line, " ")
+		token = strings.TrimSpace(token)
+		if !ok || token == "" {
+			return nil, fmt.Errorf("%s:%d: want a site and its token", file, n)
+		}
+		if other, dup := tokens[token]; dup {
+			return nil, fmt.Errorf("%s:%d: site %s has the token of %s", file, n, site, other)
+		}
+		tokens[token] = site
+	}
+	return tokens, sc.Err()
+}
+
+// certPool returns the certificates of the PEM file.
+func certPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", file)
+	}
+	return pool, nil
+}
+
+// agentTransport returns the transport with which agents talk to the
+// aggregate server: presenting the client certificate of certFile and
+// keyFile, trusting the CAs of caFile and sending token, if set.
+func agentTransport(certFile, keyFile, caFile, token string) (http.RoundTripper, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %v", err)
+		}
+		t.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pool, err := certPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	if token == "" {
+		return t, nil
+	}
+	return bearerTransport{token: token, base: t}, nil
+}
+
+// bearerTransport sends a bearer token with every request.
+type bearerTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// aggregate runs a server that merges the results remote-written by many
+// checkers (see the daemon's -remote-write) and serves them combined.
+func aggregate(args []string) error {
+	fs := flag.NewFlagSet("aggregate", flag.ExitOnError)
+	listen := fs.String("listen", ":9100", "serve the API and dashboard on `address`")
+	historyFile := fs.String("history", "", "append the merged results to `file` or a postgres:// URL, and read them back on start")
+	retention := fs.Duration("retention", 24*time.Hour, "keep results in memory for `duration`, the longest report window")
+	configFile := fs.String("config", "", "serve the config `file` to agents (see the daemon's -agent), which run the checks meant for them, and read their Quorum from it")
+	stale := fs.Duration("stale", 5*time.Minute, "don't count sites whose last result of a check is older than `duration` towards its Quorum")
+	pagerDutyKey := fs.String("pagerduty-key", os.Getenv("PAGERDUTY_ROUTING_KEY"), "trigger and resolve PagerDuty incidents of checks down by their Quorum with this routing `key`, instead of letting every site alert")
+	pagerDutyURL := fs.String("pagerduty-url", pagerDutyURL, "`URL` of the PagerDuty Events API v2")
+	opsgenieKey := fs.String("opsgenie-key", os.Getenv("OPSGENIE_API_KEY"), "create and close Opsgenie alerts of checks down by their Quorum with this API `key`")
+	opsgenieURL := fs.String("opsgenie-url", opsgenieURL, "`URL` of the Opsgenie API")
+	tlsCert := fs.String("tls-cert", "", "serve HTTPS with the certificate in `file`, see -tls-key")
+	tlsKey := fs.String("tls-key", "", "private key `file` of -tls-cert")
+	clientCA := fs.String("client-ca", "", "require agents to present a client certificate issued by a CA in `file`, whose common name is their site (needs -tls-cert)")
+	agentTokens := fs.String("agent-tokens", "", "require agents to send a bearer token listed in `file` as lines of a site and its token")
+	setupLog := addLogFlags(fs)
+	fs.Parse(args)
+	if err := setupLog(); err != nil {
+		return err
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return errors.New("-tls-cert and -tls-key must be used together")
+	}
+	if *clientCA != "" && *tlsCert == "" {
+		return errors.New("-client-ca needs -tls-cert")
+	}
+
+	a := &aggregator{retention: *retention, configFile: *configFile, seen: make(map[string]bool)}
+	if *agentTokens != "" {
+		tokens, err := readAgentTokens(*agentTokens)
+		if err != nil {
+			return err
+		}
+		a.auth.tokens = tokens
+	}
+	a.alerts.stale = *stale
+	a.alerts.notifiers = make(map[string]notifier)
+	if *pagerDutyKey != "" {
+		a.alerts.notifiers["pagerduty"] = pagerDuty{url: *pagerDutyURL, key: *pagerDutyKey, client: notifyClient}
+	}
+	if *opsgenieKey != "" {
+		a.alerts.notifiers["opsgenie"] = opsgenie{url: *opsgenieURL, key: *opsgenieKey, client: notifyClient}
+	}
+	if *historyFile != "" {
+		old, err := openStore(*historyFile, false)
+		if err != nil {
+			return err
+		}
+		rs, err := old.since(time.Now().Add(-*retention))
+		old.Close()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		a.merge(rs)
+		if a.hist, err = openStore(*historyFile, true); err != nil {
+			return err
+		}
+		defer a.hist.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Addr: *listen, Handler: a.handler()}
+	if *clientCA != "" {
+		pool, err := certPool(*clientCA)
+		if err != nil {
+			return err
+		}
+		// Only agents need certificates, not the users of the dashboard.
+		srv.TLSConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+		a.auth.certs = true
+	}
+	go func() {
+		<-ctx.Done()
+		slog.Info("shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	slog.Info("aggregating results", "listen", *listen, "tls", *tlsCert != "", "agent_auth", a.auth.enabled())
+	var err error
+	if *tlsCert != "" {
+		err = srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// aggregator holds the recent results of all sites.
+type aggregator struct {
+	hist       store // merged results are added here if not nil
+	retention  time.Duration
+	configFile string // served to agents, none if empty
+	auth       agentAuth
+	alerts     quorumAlerts
+
+	mu      sync.Mutex
+	records []record        // not older than retention
+	seen    map[string]bool // ResultIDs of records
+}
+
+// merge adds the results that aren't known yet and returns how many.
+func (a *aggregator) merge(rs []record) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire()
+	added := 0
+	for _, r := range rs {
+		if r.ResultID != "" {
+			if a.seen[r.ResultID] {
+				continue
+			}
+			a.seen[r.ResultID] = true
+		}
+		a.records = append(a.records, r)
+		added++
+		if a.hist != nil {
+			if err := a.hist.add(r); err != nil {
+				slog.Error("can't write history", "err", err)
+			}
+		}
+	}
+	return added
+}
+
+// expire forgets results older than the retention. It must be called with
+// a.mu held.
+func (a *aggregator) expire() {
+	cutoff := time.Now().Add(-a.retention)
+	a.records = slices.DeleteFunc(a.records, func(r record) bool {
+		if r.Time.Before(cutoff) {
+			delete(a.seen, r.ResultID)
+			return true
+		}
+		return false
+	})
+}
+
+// siteStatus is the latest result of a check at a site.
+type siteStatus struct {
+	Site      string
+	Name      string `json:",omitempty"`
+	URL       string
+	Healthy   bool
+	LastCheck time.Time
+	Error     string `json:",omitempty"`
+}
+
+func (a *aggregator) status() []siteStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	latest := make(map[[2]string]record)
+	for _, r := range a.records {
+		k := [2]string{r.Site, r.id()}
+		if l, ok := latest[k]; !ok || r.Time.After(l.Time) {
+			latest[k] = r
+		}
+	}
+	var out []siteStatus
+	for _, r := range latest {
+		out = append(out, siteStatus{Site: r.Site, Name: r.Name, URL: r.URL, Healthy: r.Healthy, LastCheck: r.Time, Error: r.Error})
+	}
+	slices.SortFunc(out, func(x, y siteStatus) int {
+		return strings.Compare(x.Site+"\x00"+x.Name+x.URL, y.Site+"\x00"+y.Name+y.URL)
+	})
+	return out
+}
+
+func (a *aggregator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", a.handleDashboard)
+	mux.HandleFunc("POST /api/write", a.handleWrite)
+	mux.HandleFunc("GET /api/write", a.handleResumePoint)
+	mux.HandleFunc("GET /api/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, a.status())
+	})
+	mux.HandleFunc("GET /api/checks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, a.byQuorum())
+	})
+	mux.HandleFunc("GET /api/report", a.handleReport)
+	mux.HandleFunc("GET /api/config", a.handleConfig)
+	return mux
+}
+
+// writerSite returns the site given by the site query parameter or, with
+// agent authentication, the site the agent authenticated as. If there is
+// none, it replies with an error and returns false.
+func (a *aggregator) writerSite(w http.ResponseWriter, r *http.Request) (string, bool) {
+	site := r.URL.Query().Get("site")
+	if a.auth.enabled() {
+		authSite, err := a.auth.site(r)
+		if err != nil {
+			slog.Warn("rejected results", "remote", r.RemoteAddr, "site", site, "err", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return "", false
+		}
+		if site != "" && site != authSite {
+			slog.Warn("rejected results", "remote", r.RemoteAddr, "site", site, "err", "authenticated as "+authSite)
+			http.Error(w, "authenticated as site "+authSite, http.StatusForbidden)
+			return "", false
+		}
+		site = authSite
+	}
+	if site == "" {
+		http.Error(w, "missing site", http.StatusBadRequest)
+		return "", false
+	}
+	return site, true
+}
+
+// handleWrite accepts results as JSON lines, the format of the history
+// file, from the site, see writerSite.
+func (a *aggregator) handleWrite(w http.ResponseWriter, r *http.Request) {
+	site, ok := a.writerSite(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Accept-Encoding", "gzip")
+	body, ok := requestBody(w, r, 32<<20)
+	if !ok {
+		return
+	}
+	var rs []record
+	dec := json.NewDecoder(body)
+	for {
+		var rec record
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rec.Site = site
+		rs = append(rs, rec)
+	}
+	added := a.merge(rs)
+	go a.alert() // don't keep the site waiting for notifications
+	writeJSON(w, http.StatusOK, map[string]int{"Accepted": added, "Duplicates": len(rs) - added})
+}
+
+// resumePoint is the latest result of a site that the aggregate server
+// has, which acknowledges the site's results up to it. A site's remote
+// writer resumes after it, see remoteWriter.resume.
+type resumePoint struct {
+	ResultID string    `json:",omitempty"`
+	Time     time.Time `json:",omitzero"`
+}
+
+func (a *aggregator) resumePoint(site string) resumePoint {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var p resumePoint
+	for _, r := range a.records {
+		if r.Site == site && !r.Time.Before(p.Time) {
+			p = resumePoint{ResultID: r.ResultID, Time: r.Time}
+		}
+	}
+	return p
+}
+
+// handleResumePoint returns the resumePoint of the site, see writerSite.
+func (a *aggregator) handleResumePoint(w http.ResponseWriter, r *http.Request) {
+	site, ok := a.writerSite(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, a.resumePoint(site))
+}
+
+// handleReport returns the stats of each site's checks over the window
+// query parameter, 24h by default.
+func (a *aggregator) handleReport(w http.ResponseWriter, r *http.Request) {
+	window := cmp.Or(r.URL.Query().Get("window"), "24h")
+	d, err := parseWindow(window)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if d > a.retention {
+		http.Error(w, fmt.Sprintf("window %s is longer than the retention %v", window, a.retention), http.StatusBadRequest)
+		return
+	}
+	since := time.Now().Add(-d)
+	a.mu.Lock()
+	var rs []record
+	for _, r := range a.records {
+		if !r.Time.Before(since) {
+			rs = append(rs, r)
+		}
+	}
+	a.mu.Unlock()
+	writeJSON(w, http.StatusOK, summarize(rs))
+}
+
+// handleConfig serves the config of agents. It's read for every request,
+// so agents pick up changes with their next fetch.
+func (a *aggregator) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if a.configFile == "" {
+		http.Error(w, "no config for agents, see -config", http.StatusNotFound)
+		return
+	}
+	if a.auth.enabled() {
+		if _, err := a.auth.site(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	b, err := os.ReadFile(a.configFile)
+	if err != nil {
+		slog.Error("can't read config for agents", "err", err)
+		http.Error(w, "can't read config", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Vary", "Accept-Encoding")
+	if acceptsGzip(r.Header) {
+		w.Header().Set("Content-Encoding", "gzip")
+		b = gzipBytes(b)
+	}
+	w.Write(b)
+}
+
+func (a *aggregator) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	dashboardPage.Execute(w, struct {
+		Checks []checkSites
+		Sites  []siteStatus
+	}{a.byQuorum(), a.status()})
+}
+
+var dashboardPage = template.Must(template.New("dashboard").Funcs(pageFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>Health checks of all sites</title>
+<style>
+body { font-family: sans-serif; }
+td, th { padding: 4px 12px; text-align: left; }
+.healthy { color: green; }
+.unhealthy { color: red; }
+</style>
+</head>
+<body>
+<h1>Health checks of all sites</h1>
+<table>
+<tr><th>Name</th><th>URL</th><th>State</th><th>Quorum</th><th>Down from</th><th>Up from</th></tr>
+{{range .Checks}}<tr>
+<td>{{.Name}}</td>
+<td>{{.URL}}</td>
+{{if eq .State "up"}}<td class="healthy">up</td>{{else}}<td class="unhealthy">{{.State}}</td>{{end}}
+<td>{{.Quorum}}</td>
+<td>{{join .Unhealthy ", "}}</td>
+<td>{{join .Healthy ", "}}</td>
+</tr>
+{{end}}</table>
+<h2>By site</h2>
+<table>
+<tr><th>Site</th><th>Name</th><th>URL</th><th>State</th><th>Last check</th><th>Error</th></tr>
+{{range .Sites}}<tr>
+<td>{{.Site}}</td>
+<td>{{.Name}}</td>
+<td>{{.URL}}</td>
+{{if .Healthy}}<td class="healthy">healthy</td>{{else}}<td class="unhealthy">unhealthy</td>{{end}}
+<td>{{ago .LastCheck}}</td>
+<td>{{.Error}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// The checks API identifies a health check by its ID, passed as the id
+// query parameter to DELETE and to the pause and resume endpoints.
+
+// handleListChecks lists the running health checks, with their secrets
+// redacted since reading them needs no token.
+func (d *daemon) handleListChecks(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	checks := d.currentChecks()
+	d.mu.Unlock()
+	for i := range checks {
+		checks[i] = redacted(checks[i])
+	}
+	writeJSON(w, http.StatusOK, checks)
+}
+
+// redactedSecret replaces secrets, like url.URL.Redacted does passwords.
+const redactedSecret = "xxxxx"
+
+// redacted returns a copy of h without its secrets, which are often
+// ${VAR}s expanded from the environment: the passwords of its URLs, e.g.
+// of DSNs, the Auth.ClientSecret, the Notify keys, the Authorization and
+// Cookie headers of its Steps, the values of its Exec.Env and those of its
+// Env overlays.
+func redacted(h HealthCheck) HealthCheck {
+	h.URL = redactURL(h.URL)
+	h.FailoverURL = redactURL(h.FailoverURL)
+	h.Proxy = redactURL(h.Proxy)
+	if h.Auth != nil && h.Auth.ClientSecret != "" {
+		auth := *h.Auth
+		auth.ClientSecret = redactedSecret
+		h.Auth = &auth
+	}
+	if h.Notify != nil {
+		notify := *h.Notify
+		if notify.PagerDutyKey != "" {
+			notify.PagerDutyKey = redactedSecret
+		}
+		if notify.OpsgenieKey != "" {
+			notify.OpsgenieKey = redactedSecret
+		}
+		h.Notify = &notify
+	}
+	if h.Exec != nil && len(h.Exec.Env) > 0 {
+		exec := *h.Exec
+		exec.Env = make([]string, len(h.Exec.Env))
+		for i, kv := range h.Exec.Env {
+			key, _, _ := strings.Cut(kv, "=")
+			exec.Env[i] = key + "=" + redactedSecret
+		}
+		h.Exec = &exec
+	}
+	h.Steps = slices.Clone(h.Steps)
+	for i, s := range h.Steps {
+		s.URL = redactURL(s.URL)
+		if len(s.Header) > 0 {
+			s.Header = maps.Clone(s.Header)
+			for name := range s.Header {
+				switch http.CanonicalHeaderKey(name) {
+				case "Authorization", "Proxy-Authorization", "Cookie":
+					s.Header[name] = redactedSecret
+				}
+			}
+		}
+		h.Steps[i] = s
+	}
+	if len(h.Env) > 0 {
+		env := make(map[string]json.RawMessage, len(h.Env))
+		for name, overlay := range h.Env {
+			env[name] = redactedOverlay(overlay)
+		}
+		h.Env = env
+	}
+	return h
+}
+
+// redactedOverlay returns the Env overlay with the fields that redacted
+// changes redacted, or just redactedSecret if it isn't a JSON object of
+// check fields.
+func redactedOverlay(overlay json.RawMessage) json.RawMessage {
+	secret, _ := json.Marshal(redactedSecret)
+	var fields map[string]json.RawMessage
+	var o HealthCheck
+	if json.Unmarshal(overlay, &fields) != nil || json.Unmarshal(overlay, &o) != nil {
+		return secret
+	}
+	o.Env = nil
+	data, err := json.Marshal(redacted(o))
+	var redactedFields map[string]json.RawMessage
+	if err != nil || json.Unmarshal(data, &redactedFields) != nil {
+		return secret
+	}
+	for name := range fields {
+		// Field names match case-insensitively, like when unmarshaling.
+		for field, v := range redactedFields {
+			if strings.EqualFold(name, field) {
+				fields[name] = v
+			}
+		}
+	}
+	data, err = json.Marshal(fields)
+	if err != nil {
+		return secret
+	}
+	return data
+}
+
+// redactURL hides the password of u, if it's a URL with one. It's also
+// used for the status page and API, which need no token either.
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.User == nil {
+		return u
+	}
+	return parsed.Redacted()
+}
+
+// handleAddCheck adds the health check in the request body or replaces the
+// one with the same ID.
+func (d *daemon) handleAddCheck(w http.ResponseWriter, r *http.Request) {
+	var posted json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&posted); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h, code, err := d.loadCheck(posted)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if h.URL == "" {
+		http.Error(w, "missing URL", http.StatusBadRequest)
+		return
+	}
+	if runsCommands(h) && !d.apiExec {
+		http.Error(w, "checks that run commands can't be added via the API without -api-exec", http.StatusForbidden)
+		return
+	}
+	d.change(w, r, func(checks []HealthCheck) ([]HealthCheck, error) {
+		if i := indexCheck(checks, h.ID()); i >= 0 {
+			checks[i] = h
+			return checks, nil
+		}
+		return append(checks, h), nil
+	})
+}
+
+// loadCheck loads a health check posted to the API like those of the
+// config file, so it gets the config's DefaultTimeout, Transport or
+// Profile, Maintenance and notification route, the -env overlay is
+// applied and it's validated. ${VAR}s in the posted check aren't expanded.
+// With several config files, the check gets no config's settings.
+func (d *daemon) loadCheck(posted json.RawMessage) (HealthCheck, int, error) {
+	name, data := "request", []byte("[]")
+	files, err := configFiles(d.configFile)
+	if err != nil {
+		return HealthCheck{}, http.StatusInternalServerError, err
+	}
+	if len(files) == 1 {
+		name = files[0]
+		if data, err = readConfigData(name); err != nil {
+			return HealthCheck{}, http.StatusInternalServerError, err
+		}
+	}
+	checks := append(append([]byte("["), posted...), ']')
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var cfg map[string]json.RawMessage
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return HealthCheck{}, http.StatusInternalServerError, fmt.Errorf("%s: %v", name, err)
+		}
+		cfg["Checks"] = checks
+		if checks, err = json.Marshal(cfg); err != nil {
+			return HealthCheck{}, http.StatusInternalServerError, err
+		}
+	}
+	hs, _, problems, err := parseConfig(name, checks)
+	if err != nil {
+		return HealthCheck{}, http.StatusInternalServerError, err
+	}
+	if len(hs) != 1 {
+		return HealthCheck{}, http.StatusBadRequest, errors.New("want a single health check")
+	}
+	problems = append(problems, validateChecks(hs)...)
+	if len(problems) > 0 {
+		msgs := make([]string, len(problems))
+		for i, p := range problems {
+			msgs[i] = p.Msg
+		}
+		return HealthCheck{}, http.StatusBadRequest, errors.New(strings.Join(msgs, "; "))
+	}
+	return hs[0], http.StatusOK, nil
+}
+
+func (d *daemon) handleDeleteCheck(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	d.change(w, r, func(checks []HealthCheck) ([]HealthCheck, error) {
+		i := indexCheck(checks, id)
+		if i < 0 {
+			return nil, fmt.Errorf("no check %q", id)
+		}
+		return slices.Delete(checks, i, i+1), nil
+	})
+}
+
+func (d *daemon) handlePauseCheck(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		d.change(w, r, func(checks []HealthCheck) ([]HealthCheck, error) {
+			i := indexCheck(checks, id)
+			if i < 0 {
+				return nil, fmt.Errorf("no check %q", id)
+			}
+			checks[i].Paused = paused
+			return checks, nil
+		})
+	}
+}
+
+// change applies edit to the running health checks, persists them if
+// requested and responds with what changed.
+func (d *daemon) change(w http.ResponseWriter, r *http.Request, edit func([]HealthCheck) ([]HealthCheck, error)) {
+	diff, code, err := d.edit(r, edit)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	writeJSON(w, http.StatusOK, diff)
+}
+
+// edit is change without the response. It returns the HTTP status code
+// of errors.
+func (d *daemon) edit(r *http.Request, edit func([]HealthCheck) ([]HealthCheck, error)) (configDiff, int, error) {
+	var checks []HealthCheck
+	diff, code, err := func() (configDiff, int, error) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var err error
+		checks, err = edit(d.currentChecks())
+		if err != nil {
+			return configDiff{}, http.StatusNotFound, err
+		}
+		if d.persist {
+			// Discovered checks come back from their discoverer.
+			configured := slices.DeleteFunc(slices.Clone(checks), func(h HealthCheck) bool { return h.discovered != "" })
+			if err := writeConfig(d.configFile, configured); err != nil {
+				return configDiff{}, http.StatusInternalServerError, err
+			}
+		}
+		diff := d.apply(checks)
+		d.lastDiff = &diff
+		return diff, http.StatusOK, nil
+	}()
+	if err != nil {
+		return diff, code, err
+	}
+	slog.Info("config changed via API", diffAttrs(diff)...)
+	actor, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if d.audit != nil {
+		d.audit.log(auditEvent{Time: diff.Time, Action: "api-change", Actor: actor, Message: diff.String()})
+	}
+	d.recordChange("api", actor, diff, checks)
+	return diff, http.StatusOK, nil
+}
+
+func indexCheck(checks []HealthCheck, id string) int {
+	return slices.IndexFunc(checks, func(h HealthCheck) bool { return h.ID() == id })
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// runsCommands reports whether h is an exec or docker check, or becomes
+// one with its Env overrides.
+func runsCommands(h HealthCheck) bool {
+	if h.Exec != nil || h.checkType() == "exec" || h.checkType() == "docker" {
+		return true
+	}
+	for _, overlay := range h.Env {
+		var o struct{ Exec, Type json.RawMessage }
+		if json.Unmarshal(overlay, &o) != nil || o.Exec != nil || o.Type != nil {
+			return true
+		}
+	}
+	return false
+}
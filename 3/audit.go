@@ -0,0 +1,195 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditEvent is a state change of a check or a change of the config, as
+// exported for a SIEM by an auditor.
+type auditEvent struct {
+	Time    time.Time
+	Action  string // unhealthy, healthy, api-change, reload, drill, snooze, disable or reminder
+	Check   string // ID of the check whose state changed
+	Actor   string // remote address of API requests
+	Message string
+}
+
+// auditor writes audit events as CEF lines or OCSF JSON to a file or
+// syslog.
+type auditor struct {
+	format string // cef or ocsf
+	syslog bool   // w is a syslog connection, frame each event
+	site   string
+
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+// openAuditor opens dest, a file name or a syslog address like
+// udp://siem:514, tcp://siem:514 or unix:///dev/log.
+func openAuditor(dest, format, site string) (*auditor, error) {
+	if format != "cef" && format != "ocsf" {
+		return nil, fmt.Errorf("audit format must be cef or ocsf, not %q", format)
+	}
+	a := &auditor{format: format, site: site}
+	if u, err := url.Parse(dest); err == nil && (u.Scheme == "udp" || u.Scheme == "tcp" || u.Scheme == "unix") {
+		addr := u.Host
+		network := u.Scheme
+		if network == "unix" {
+			addr, network = u.Path, "unixgram"
+		}
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		a.w, a.syslog = conn, true
+		return a, nil
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	a.w = f
+	return a, nil
+}
+
+// log writes e. Failures are logged, auditing never stops the checks.
+func (a *auditor) log(e auditEvent) {
+	var line string
+	if a.format == "cef" {
+		line = a.cef(e)
+	} else {
+		line = a.ocsf(e)
+	}
+	if a.syslog {
+		// RFC 5424 with facility security/authorization (10), severity
+		// notice (5); TCP receivers split on newlines.
+		line = fmt.Sprintf("<85>1 %s %s healthcheck %d - - %s", e.Time.UTC().Format(time.RFC3339Nano), cmp.Or(a.site, "-"), os.Getpid(), line)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := io.WriteString(a.w, line+"\n"); err != nil {
+		slog.Error("can't write audit event", "err", err)
+	}
+}
+
+// severity returns the severity of e on CEF's scale of 0 to 10.
+func (e auditEvent) severity() int {
+	switch e.Action {
+	case "unhealthy", "reminder":
+		return 7
+	case "api-change", "reload", "discovery", "drill", "snooze", "disable":
+		return 3
+	}
+	return 1
+}
+
+// cef formats e in ArcSight's Common Event Format.
+func (a *auditor) cef(e auditEvent) string {
+	header := strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	ext := strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+	fields := []string{"rt=" + fmt.Sprint(e.Time.UnixMilli())}
+	add := func(key, value string) {
+		if value != "" {
+			fields = append(fields, key+"="+ext.Replace(value))
+		}
+	}
+	add("dvchost", a.site)
+	if e.Check != "" {
+		add("cs1Label", "check")
+		add("cs1", e.Check)
+	}
+	add("src", e.Actor)
+	add("msg", e.Message)
+	return fmt.Sprintf("CEF:0|go-monk|healthcheck|1.0|%s|%s|%d|%s",
+		header.Replace(e.Action), header.Replace(auditTitle(e)), e.severity(), strings.Join(fields, " "))
+}
+
+func auditTitle(e auditEvent) string {
+	switch e.Action {
+	case "unhealthy":
+		return "Check became unhealthy"
+	case "healthy":
+		return "Check became healthy"
+	case "api-change":
+		return "Config changed via API"
+	case "reload":
+		return "Config reloaded"
+	case "discovery":
+		return "Checks discovered"
+	case "drill":
+		return "Alert drill started"
+	case "snooze":
+		return "Check snoozed"
+	case "disable":
+		return "Check disabled"
+	case "reminder":
+		return "Check still unhealthy after snooze"
+	}
+	return e.Action
+}
+
+// ocsf formats e as an OCSF 1.1 event: state changes are Incident
+// Findings, config changes API Activity or, for reloads, Application
+// Lifecycle events.
+func (a *auditor) ocsf(e auditEvent) string {
+	var class, activity, category int
+	switch e.Action {
+	case "unhealthy", "healthy", "reminder":
+		category, class = 2, 2005 // Findings, Incident Finding
+		activity = 1              // Create
+		switch e.Action {
+		case "healthy":
+			activity = 3 // Close
+		case "reminder":
+			activity = 2 // Update
+		}
+	case "api-change", "drill", "snooze", "disable":
+		category, class, activity = 6, 6003, 3 // Application Activity, API Activity, Update
+	default:
+		category, class, activity = 6, 6002, 3 // Application Activity, Application Lifecycle, Restart
+	}
+	severity := 1 // Informational
+	if e.severity() >= 7 {
+		severity = 4 // High
+	} else if e.severity() >= 3 {
+		severity = 2 // Low
+	}
+	v := map[string]any{
+		"category_uid": category,
+		"class_uid":    class,
+		"activity_id":  activity,
+		"type_uid":     class*100 + activity,
+		"severity_id":  severity,
+		"time":         e.Time.UnixMilli(),
+		"message":      auditTitle(e) + ": " + e.Message,
+		"metadata": map[string]any{
+			"version": "1.1.0",
+			"product": map[string]any{"name": "healthcheck", "vendor_name": "go-monk"},
+		},
+		"device": map[string]any{"hostname": a.site},
+	}
+	if e.Check != "" {
+		v["finding_info"] = map[string]any{"uid": e.Check, "title": auditTitle(e) + ": " + e.Check}
+	}
+	if e.Actor != "" {
+		v["src_endpoint"] = map[string]any{"ip": e.Actor}
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// Close closes the file or syslog connection.
+func (a *auditor) Close() error {
+	return a.w.Close()
+}
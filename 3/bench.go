@@ -0,0 +1,142 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// bench runs a burst of checks against one target and prints percentiles
+// of their latency, e.g. to put a number on "it feels slow" during an
+// incident.
+func bench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	configFile := addConfigFlags(fs)
+	n := fs.Int("n", 50, "run the check `n` times")
+	concurrency := fs.Int("c", 1, "run `n` checks at a time")
+	format := fs.String("format", "table", "output `format`: table or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: bench [flags] <name or url>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing health check name or URL")
+	}
+	if *n < 1 || *concurrency < 1 {
+		return errors.New("-n and -c must be at least 1")
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	h, err := findCheck(*configFile, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if _, err := h.checker(); err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	var total, ttfb []time.Duration
+	errs := make(map[string]int)
+	jobs := make(chan struct{})
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range min(*concurrency, *n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				t := time.Now()
+				r := h.Do()
+				latency := time.Since(t)
+				mu.Lock()
+				total = append(total, latency)
+				if r.Timing != nil && r.Timing.TTFB > 0 {
+					ttfb = append(ttfb, r.Timing.TTFB)
+				}
+				if !r.Healthy {
+					errs[fmt.Sprint(r.Err)]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for range *n {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	elapsed := time.Since(start)
+	slices.Sort(total)
+	slices.Sort(ttfb)
+
+	failed := 0
+	for _, c := range errs {
+		failed += c
+	}
+	if *format == "json" {
+		type latencies struct {
+			MinMs, P50Ms, P90Ms, P95Ms, P99Ms, MaxMs float64
+		}
+		summarize := func(ds []time.Duration) *latencies {
+			if len(ds) == 0 {
+				return nil
+			}
+			return &latencies{ms(ds[0]), ms(percentile(ds, 50)), ms(percentile(ds, 90)), ms(percentile(ds, 95)), ms(percentile(ds, 99)), ms(ds[len(ds)-1])}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Check     string
+			Runs      int
+			Failures  int
+			ElapsedMs float64
+			TTFB      *latencies `json:",omitempty"` // of HTTP checks
+			Total     *latencies
+			Errors    map[string]int // runs by error
+		}{h.ID(), *n, failed, ms(elapsed), summarize(ttfb), summarize(total), errs})
+	}
+
+	fmt.Print(tr("%s: %d runs, %d failed, in %v\n\n", h.ID(), *n, failed, elapsed.Round(time.Millisecond)))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "\tMIN\tP50\tP90\tP95\tP99\tMAX\t")
+	row := func(name string, ds []time.Duration) {
+		if len(ds) == 0 {
+			return
+		}
+		fmt.Fprintf(tw, "%s\t", name)
+		for _, d := range []time.Duration{ds[0], percentile(ds, 50), percentile(ds, 90), percentile(ds, 95), percentile(ds, 99), ds[len(ds)-1]} {
+			fmt.Fprintf(tw, "%v\t", d.Round(10*time.Microsecond))
+		}
+		fmt.Fprintln(tw)
+	}
+	row("TTFB", ttfb)
+	row(tr("TOTAL"), total)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		fmt.Print(tr("\nErrors:\n"))
+		// Most frequent first.
+		msgs := slices.SortedFunc(maps.Keys(errs), func(a, b string) int {
+			return cmp.Or(errs[b]-errs[a], strings.Compare(a, b))
+		})
+		for _, msg := range msgs {
+			fmt.Printf("%6d  %s\n", errs[msg], msg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Bulk operations act on all checks selected by name and tags at once,
+// e.g. "disable -tags team-x -duration 2h" during an incident:
+//
+//   - disable pauses the checks, for a while with -duration or else in the
+//     config, and enable resumes them.
+//   - silence snoozes them like the snooze subcommand.
+//   - tag and untag add or remove a tag in the config.
+var bulkOps = []string{"enable", "disable", "silence", "tag", "untag"}
+
+// bulkCommand returns the subcommand of the bulk operation op.
+func bulkCommand(op string) func(args []string) error {
+	return func(args []string) error {
+		fs := flag.NewFlagSet(op, flag.ExitOnError)
+		addr := fs.String("addr", "http://127.0.0.1:9090", "`URL` of the daemon's -listen address, whose -api-token is taken from $HEALTHCHECK_API_TOKEN")
+		name := fs.String("name", "", "select checks whose name (or URL) matches the glob `pattern`")
+		tags := fs.String("tags", "", "select checks that have all comma separated `tags`")
+		var duration *time.Duration
+		switch op {
+		case "disable":
+			duration = fs.Duration("duration", 0, "disable for `duration` rather than in the config until enabled")
+		case "silence":
+			duration = fs.Duration("duration", time.Hour, "silence for `duration`, 0 ends the silence")
+		}
+		usage := op + " [flags]"
+		if op == "tag" || op == "untag" {
+			usage += " <tag>"
+		}
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "usage: %s\n", usage)
+			fs.PrintDefaults()
+		}
+		fs.Parse(args)
+		if (op == "tag" || op == "untag") != (fs.NArg() == 1) || fs.NArg() > 1 {
+			fs.Usage()
+			return errors.New("wrong number of arguments")
+		}
+
+		q := url.Values{"op": {op}, "name": {*name}, "tags": {*tags}}
+		if duration != nil {
+			q.Set("for", duration.String())
+		}
+		if op == "tag" || op == "untag" {
+			q.Set("tag", fs.Arg(0))
+		}
+		var res bulkResult
+		if err := postAPI(*addr, "/api/checks/bulk", q, &res); err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s", op, strings.Join(res.Checks, ", "))
+		if !res.Until.IsZero() {
+			fmt.Printf(" until %s", res.Until.Local().Format(time.DateTime))
+		}
+		fmt.Println()
+		return nil
+	}
+}
+
+type bulkResult struct {
+	Op     string
+	Checks []string  // IDs of the selected checks
+	Until  time.Time `json:",omitzero"`
+}
+
+// handleBulk applies the bulk operation of the op query parameter to the
+// checks selected by the name and tags parameters.
+func (d *daemon) handleBulk(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	op := q.Get("op")
+	f := newCheckFilter(q.Get("name"), q.Get("tags"))
+	var duration time.Duration
+	var err error
+	if s := q.Get("for"); s != "" {
+		if duration, err = time.ParseDuration(s); err != nil || duration < 0 {
+			err = errors.New("for must be a duration like 2h")
+		}
+	}
+	switch {
+	case !slices.Contains(bulkOps, op):
+		err = fmt.Errorf("op must be one of %s", strings.Join(bulkOps, ", "))
+	case f.name == "" && len(f.tags) == 0:
+		err = errors.New("select checks with name or tags")
+	case op == "silence" && !q.Has("for"):
+		err = errors.New("silence needs for")
+	case (op == "tag" || op == "untag") && q.Get("tag") == "":
+		err = errors.New("missing tag")
+	}
+	if _, perr := path.Match(f.name, ""); perr != nil {
+		err = fmt.Errorf("name: %v", perr)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res := bulkResult{Op: op}
+	if duration > 0 {
+		res.Until = time.Now().Add(duration).Round(time.Second)
+	}
+	switch {
+	case op == "silence" || op == "disable" && duration > 0:
+		field := timedActions["snooze"].field
+		if op == "disable" {
+			field = func(e *entry) *time.Time { return &e.pausedUntil }
+		}
+		res.Checks = d.setUntil(r, f, op, field, res.Until)
+	default:
+		if op == "enable" {
+			d.setUntil(r, f, op, func(e *entry) *time.Time { return &e.pausedUntil }, time.Time{})
+		}
+		tag := q.Get("tag")
+		_, code, err := d.edit(r, func(checks []HealthCheck) ([]HealthCheck, error) {
+			for i, h := range checks {
+				if !f.match(h) {
+					continue
+				}
+				res.Checks = append(res.Checks, h.ID())
+				switch op {
+				case "enable", "disable":
+					checks[i].Paused = op == "disable"
+				case "tag":
+					if !slices.Contains(h.Tags, tag) {
+						checks[i].Tags = append(slices.Clip(h.Tags), tag)
+					}
+				case "untag":
+					checks[i].Tags = slices.DeleteFunc(slices.Clone(h.Tags), func(t string) bool { return t == tag })
+				}
+			}
+			if len(res.Checks) == 0 {
+				return nil, errors.New("no checks match")
+			}
+			return checks, nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+	}
+	if len(res.Checks) == 0 {
+		http.Error(w, "no checks match", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// setUntil sets the time of field of the checks f selects to until and
+// returns their IDs. The bulk operation op is logged and audited per check.
+func (d *daemon) setUntil(r *http.Request, f checkFilter, op string, field func(*entry) *time.Time, until time.Time) []string {
+	d.mu.Lock()
+	var changed []HealthCheck
+	for _, e := range d.entries {
+		if f.match(e.check) {
+			*field(e) = until
+			changed = append(changed, e.check)
+		}
+	}
+	d.mu.Unlock()
+
+	var ids []string
+	actor, _, _ := net.SplitHostPort(r.RemoteAddr)
+	for _, h := range changed {
+		ids = append(ids, h.ID())
+		if op == "enable" {
+			continue // audited as the change of the config
+		}
+		msg := "ended"
+		if until.IsZero() {
+			slog.Info(op+" ended", checkAttrs(h)...)
+		} else {
+			slog.Warn(op+" started", append(checkAttrs(h), "until", until)...)
+			msg = "until " + until.Format(time.RFC3339)
+		}
+		if d.audit != nil {
+			action := op
+			if op == "silence" {
+				action = "snooze"
+			}
+			d.audit.log(auditEvent{Time: time.Now(), Action: action, Check: h.ID(), Actor: actor, Message: msg})
+		}
+	}
+	return ids
+}
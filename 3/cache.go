@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheConfig asserts how CDNs and caches treat the responses of HTTP
+// checks, e.g. that they are cache hits rather than all going to the
+// origin.
+type cacheConfig struct {
+	Hit       bool     `json:",omitempty"` // the response must be a cache hit, see cacheHit
+	Cacheable bool     `json:",omitempty"` // Cache-Control must not be private, no-store or no-cache
+	MinMaxAge duration `json:",omitempty"` // Cache-Control s-maxage or max-age must be at least this
+	MaxAge    duration `json:",omitempty"` // the Age of cached responses must be at most this, zero means any
+}
+
+func (c *cacheConfig) validate() error {
+	if c.MinMaxAge < 0 || c.MaxAge < 0 {
+		return errors.New("Cache.MinMaxAge and Cache.MaxAge must not be negative")
+	}
+	return nil
+}
+
+// cacheStatusHeaders tell whether a response is a cache hit, e.g.
+// X-Cache: Hit from cloudfront or CF-Cache-Status: HIT.
+var cacheStatusHeaders = []string{"CF-Cache-Status", "X-Cache-Status", "X-Cache"}
+
+// cacheHit reports whether resp was served from a cache and how that's
+// known. Layered caches list their statuses in X-Cache, e.g. MISS, HIT,
+// the last one is of the cache closest to the checker. Without any
+// status header a positive Age tells a hit.
+func cacheHit(resp *http.Response) (bool, string) {
+	for _, name := range cacheStatusHeaders {
+		if v := resp.Header.Get(name); v != "" {
+			last := strings.TrimSpace(v[strings.LastIndexByte(v, ',')+1:])
+			return strings.Contains(strings.ToUpper(last), "HIT"), name + ": " + v
+		}
+	}
+	if age, err := strconv.Atoi(resp.Header.Get("Age")); err == nil {
+		return age > 0, "Age: " + strconv.Itoa(age)
+	}
+	return false, "no cache status header or Age"
+}
+
+// cacheControl returns the directives of the Cache-Control header, in
+// lower case, with their values.
+func cacheControl(resp *http.Response) map[string]string {
+	directives := make(map[string]string)
+	for _, v := range resp.Header.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+func (c *cacheConfig) assertions() []assertion {
+	var as []assertion
+	if c.Hit {
+		as = append(as, assertion{
+			desc: "response is a cache hit",
+			check: func(resp *http.Response, body []byte) error {
+				if hit, why := cacheHit(resp); !hit {
+					return fmt.Errorf("not a cache hit (%s)", why)
+				}
+				return nil
+			},
+		})
+	}
+	if c.Cacheable {
+		as = append(as, assertion{
+			desc: "response is cacheable",
+			check: func(resp *http.Response, body []byte) error {
+				cc := cacheControl(resp)
+				for _, d := range []string{"private", "no-store", "no-cache"} {
+					if _, ok := cc[d]; ok {
+						return fmt.Errorf("not cacheable, Cache-Control: %s", strings.Join(resp.Header.Values("Cache-Control"), ", "))
+					}
+				}
+				return nil
+			},
+		})
+	}
+	if c.MinMaxAge > 0 {
+		min := time.Duration(c.MinMaxAge)
+		as = append(as, assertion{
+			desc: fmt.Sprintf("max-age is at least %v", min),
+			check: func(resp *http.Response, body []byte) error {
+				cc := cacheControl(resp)
+				v, ok := cc["s-maxage"] // of shared caches like CDNs
+				if !ok {
+					v, ok = cc["max-age"]
+				}
+				if !ok {
+					return errors.New("no max-age in Cache-Control")
+				}
+				secs, err := strconv.Atoi(v)
+				if err != nil {
+					return fmt.Errorf("invalid max-age %q", v)
+				}
+				if got := time.Duration(secs) * time.Second; got < min {
+					return fmt.Errorf("got max-age %v, want at least %v", got, min)
+				}
+				return nil
+			},
+		})
+	}
+	if c.MaxAge > 0 {
+		max := time.Duration(c.MaxAge)
+		as = append(as, assertion{
+			desc: fmt.Sprintf("Age is at most %v", max),
+			check: func(resp *http.Response, body []byte) error {
+				age, err := strconv.Atoi(resp.Header.Get("Age"))
+				if err != nil {
+					return nil // not from a cache, or it doesn't tell
+				}
+				if got := time.Duration(age) * time.Second; got > max {
+					return fmt.Errorf("got a response cached %v ago, want at most %v", got, max)
+				}
+				return nil
+			},
+		})
+	}
+	return as
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+)
+
+// chaosChecker is the builtin check of chaos verification, chaos:fail
+// always fails and chaos:pass always passes. They're usable in configs
+// too, e.g. to try out a route.
+type chaosChecker struct {
+	fail bool
+}
+
+func init() {
+	registerChecker("chaos", newChaosChecker)
+}
+
+func newChaosChecker(h HealthCheck) (Checker, error) {
+	switch h.URL {
+	case "chaos:fail":
+		return chaosChecker{fail: true}, nil
+	case "chaos:pass":
+		return chaosChecker{}, nil
+	}
+	return nil, fmt.Errorf("invalid chaos check URL %q, want chaos:fail or chaos:pass", h.URL)
+}
+
+func (c chaosChecker) Check(ctx context.Context) Result {
+	if c.fail {
+		return Result{Err: errors.New("CHAOS: known failure verifying that alerts are delivered, not a real outage")}
+	}
+	return Result{Healthy: true}
+}
+
+// chaosRuns is how many intervals chaos verification fails its check for
+// at most, waiting for all notifiers to be alerted.
+const chaosRuns = 5
+
+// runChaos verifies every d.chaos that alerts get delivered, until ctx is
+// done. The outcome is reported as the chaos self check.
+func (d *daemon) runChaos(ctx context.Context, interval time.Duration) {
+	s := &state{} // of both phases, like a reload hands it over
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.chaos):
+		}
+		err := d.verifyAlerts(ctx, s, interval)
+		if ctx.Err() != nil {
+			return // shutting down
+		}
+		d.report("chaos", err)
+		if err != nil {
+			slog.Error("chaos verification failed", "err", err)
+		} else {
+			slog.Info("chaos verification passed, alerts were delivered and resolved")
+		}
+	}
+}
+
+// verifyAlerts runs the failing chaos check through the whole pipeline,
+// history, audit and notifiers, every interval until all notifiers were
+// alerted, then the passing one so the alerts are resolved again.
+func (d *daemon) verifyAlerts(ctx context.Context, s *state, interval time.Duration) error {
+	failing := &entry{check: HealthCheck{Name: "chaos", URL: "chaos:fail", Severity: "info"}, state: s}
+	missing := func() []string {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var out []string
+		for _, name := range slices.Sorted(maps.Keys(d.notifiers)) {
+			if _, ok := s.alerted[name]; !ok {
+				out = append(out, name)
+			}
+		}
+		return out
+	}
+	var err error
+	for run := 0; ; run++ {
+		d.check(context.Background(), failing, time.Now())
+		m := missing()
+		if len(m) == 0 {
+			break
+		}
+		if run == chaosRuns-1 {
+			err = fmt.Errorf("not alerted by %v after %d runs", m, chaosRuns)
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-time.After(interval):
+			continue
+		}
+		break
+	}
+
+	// Resolve whatever was alerted even if not all notifiers were.
+	passing := &entry{check: HealthCheck{Name: "chaos", URL: "chaos:pass", Severity: "info"}, state: s}
+	d.check(context.Background(), passing, time.Now())
+	d.mu.Lock()
+	unresolved := slices.Sorted(maps.Keys(s.alerted))
+	d.mu.Unlock()
+	if len(unresolved) > 0 {
+		err = errors.Join(err, fmt.Errorf("not resolved by %v", unresolved))
+	}
+	return err
+}
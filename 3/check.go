@@ -0,0 +1,446 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type HealthCheck struct {
+	Name              string   `json:",omitempty"` // must be unique if set
+	Tags              []string `json:",omitempty"` // e.g. prod, db
+	Type              string   `json:",omitempty"` // see checkerTypes, defaults to the URL's scheme
+	URL               string
+	ResponseTimeout   duration `json:",omitempty"` // zero means the default, -1 or "none" no timeout
+	HealthyStatusCode int      `json:",omitempty"` // for HTTP checks
+	FollowRedirects   *bool    `json:",omitempty"` // for HTTP checks, nil means true
+	MaxRedirects      int      `json:",omitempty"` // zero means 10
+	Protocol          string   `json:",omitempty"` // HTTP/1.1 or h2 the response must use, empty means any; h3 needs QUIC and isn't supported
+	WebSocketPing     bool     `json:",omitempty"` // for WebSocket checks, also wait for the pong to a ping
+	StartTLS          bool     `json:",omitempty"` // for smtp, imap and pop3 checks, also upgrade to TLS
+	SSHKey            string   `json:",omitempty"` // private key file with which SSH checks log in as the URL's user
+	MaxOffset         duration `json:",omitempty"` // clock offset NTP checks tolerate, zero means 1s
+	Proxy             string   `json:",omitempty"` // overrides the config's Transport.Proxy
+	Profile           string   `json:",omitempty"` // name of the config's Profiles entry whose transport to use
+	Path              string   `json:",omitempty"` // requested from unix:// URLs, defaults to /
+	MinHealthy        int      `json:",omitempty"` // addresses of dns-failover checks or load balancer backends, zero means 1
+	Backend           string   `json:",omitempty"` // HAProxy backend or NGINX upstream of load balancer checks, empty means all
+	ProbeBackends     bool     `json:",omitempty"` // also probe the backends of load balancer checks over TCP
+	ResolveOverride   string   `json:",omitempty"` // connect to this IP[:port] instead, like curl --resolve
+	DNSServer         string   `json:",omitempty"` // resolve names with this server[:port] instead of the system's
+	Force             string   `json:",omitempty"` // ipv4 or ipv6 to use only that address family, empty means either
+
+	// ExpectedHeaders are response headers HTTP checks require, e.g.
+	// {"X-Env": "prod", "Content-Type": "/^application/json/"}. Values
+	// must match exactly unless written as /regexp/.
+	ExpectedHeaders map[string]string `json:",omitempty"`
+
+	// Limits of the size of HTTP response bodies, to detect truncated or
+	// bloated responses. Zero means no limit. Bodies aren't read beyond
+	// MaxBodyBytes.
+	MinBodyBytes int64 `json:",omitempty"`
+	MaxBodyBytes int64 `json:",omitempty"`
+
+	// ExpectedSHA256 is the hex SHA-256 the body of HTTP responses must
+	// have, e.g. of a static asset that must not change.
+	ExpectedSHA256 string `json:",omitempty"`
+
+	// FailoverURL is the documented failover of the URL of HTTP checks,
+	// e.g. a DR site's. It's checked too when the URL fails, and the
+	// check is healthy if it works. With ProbeFailover it's checked every
+	// time, so a broken DR path is noticed before it's needed.
+	FailoverURL   string `json:",omitempty"`
+	ProbeFailover bool   `json:",omitempty"`
+
+	// Cache asserts how caches treat the responses of HTTP checks, e.g.
+	// {"Hit": true, "MinMaxAge": "60s"} for a CDN that must not send all
+	// traffic to the origin.
+	Cache *cacheConfig `json:",omitempty"`
+
+	Exec *execConfig `json:",omitempty"` // for exec checks
+	Auth *authConfig `json:",omitempty"` // for HTTP and transaction checks
+
+	// Steps make a transaction check of HTTP requests, see step.
+	Steps []step `json:",omitempty"`
+
+	Severity string `json:",omitempty"` // e.g. critical, warning
+	Owner    string `json:",omitempty"` // team or person responsible for the service
+	Runbook  string `json:",omitempty"` // URL of the runbook to follow when unhealthy
+	Paused   bool   `json:",omitempty"` // paused checks are not run
+
+	// DNSAnswer configures dns-answer checks, which alert on changed
+	// DNS answers.
+	DNSAnswer *dnsAnswerConfig `json:",omitempty"`
+
+	// CT configures ct checks, which alert on certificates logged for a
+	// domain that weren't issued as expected.
+	CT *ctConfig `json:",omitempty"`
+
+	// Notify routes the PagerDuty and Opsgenie alerts of the check.
+	Notify *notifyConfig `json:",omitempty"`
+
+	// Schedule is a cron expression, e.g. "*/5 9-17 * * MON-FRI", for
+	// checks the daemon should run only at these times rather than every
+	// -interval, which is the resolution of the schedule.
+	Schedule string `json:",omitempty"`
+	Timezone string `json:",omitempty"` // of the Schedule, e.g. Europe/Prague, defaults to local time
+
+	// DependsOn lists the IDs of checks this one needs. While one of them
+	// is unhealthy this check is skipped instead of failing too.
+	DependsOn []string `json:",omitempty"`
+
+	// RunOn pins the check to agents with these labels (see -labels),
+	// e.g. {"region": ["eu-west", "eu-central"]} for endpoints that may only
+	// be probed from the EU. An agent must have every key with one of its
+	// values, agents without the label don't run the check.
+	RunOn map[string][]string `json:",omitempty"`
+
+	// Quorum is how many of the sites running the check must see it fail
+	// for the aggregate server to consider it down and alert, e.g. 2 so
+	// one site's network trouble doesn't page anyone. Zero means all.
+	Quorum int `json:",omitempty"`
+
+	// Maintenance windows of this check, in addition to the config's.
+	Maintenance []window `json:",omitempty"`
+
+	// Options are the settings of checks of a type that another package
+	// registered, see checker.RegisterChecker.
+	Options json.RawMessage `json:",omitempty"`
+
+	// Env overrides fields per environment selected with -env, e.g.
+	// {"prod": {"URL": "https://example.com/healthz", "Tags": ["prod"]}}.
+	Env map[string]json.RawMessage `json:",omitempty"`
+
+	transport         http.RoundTripper // shared by the checks of a config, nil means http.DefaultTransport
+	globalMaintenance []window          // the config's maintenance windows
+	resolver          *net.Resolver     // see DNSServer, nil means net.DefaultResolver
+	route             *notifyRoute      // of its alerts, nil if no route matches, see notifications
+	discovered        string            // name of the discoverer that generated it, empty if from the config
+	namespace         string            // of its config when running several, see readConfigs
+	dup               int               // number of earlier checks with the same Name or URL, see disambiguate
+	dial              func(ctx context.Context, network, addr string) (net.Conn, error)
+	configErr         string // problems of the check in the config, see keepGoing
+}
+
+// timeout returns the effective response timeout, zero meaning none.
+func (h HealthCheck) timeout() time.Duration {
+	switch {
+	case h.ResponseTimeout == 0:
+		return defaultResponseTimeout
+	case h.ResponseTimeout < 0:
+		return 0
+	}
+	return time.Duration(h.ResponseTimeout)
+}
+
+// ID identifies the health check. It's the Name or, if not set, the URL,
+// followed by #2, #3 and so on for later checks with the same one.
+func (h HealthCheck) ID() string {
+	id := h.URL
+	if h.Name != "" {
+		id = h.Name
+	}
+	if h.dup > 0 {
+		id += "#" + strconv.Itoa(h.dup+1)
+	}
+	if h.namespace != "" {
+		return h.namespace + "/" + id
+	}
+	return id
+}
+
+func (h HealthCheck) Do() Result {
+	return h.do(context.Background())
+}
+
+// do is Do with a context that may carry a span, see traceSpans.
+func (h HealthCheck) do(ctx context.Context) Result {
+	c, err := h.checker()
+	if err != nil {
+		return Result{Err: err, ConfigErr: true}
+	}
+	outbound.wait(h)
+	ctx, src := withSource(ctx)
+	ctx, tm := withTiming(ctx)
+	if t := h.timeout(); t > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t)
+		defer cancel()
+	}
+	r := c.Check(ctx)
+	r.Err = fipsHint(r.Err)
+	src.set(&r)
+	r.Timing = tm.result()
+	return r
+}
+
+// httpChecker is healthy if the response to a GET request of the URL
+// meets all assertions.
+type httpChecker struct {
+	h HealthCheck
+}
+
+func newHTTPChecker(h HealthCheck) (Checker, error) {
+	var errs []error
+	if _, err := parseURL(h, "http", "https"); err != nil {
+		errs = append(errs, err)
+	}
+	if h.MaxRedirects < 0 {
+		errs = append(errs, errors.New("MaxRedirects must not be negative"))
+	}
+	if h.HealthyStatusCode < 100 || h.HealthyStatusCode > 599 {
+		errs = append(errs, fmt.Errorf("HealthyStatusCode %d is not a valid HTTP status code", h.HealthyStatusCode))
+	}
+	switch h.Protocol {
+	case "", "HTTP/1.1":
+	case "h2":
+		if strings.HasPrefix(h.URL, "http:") {
+			// Without TLS there's no negotiation, speak HTTP/2 right away.
+			h = h.cleartextHTTP2()
+		}
+	case "h3":
+		errs = append(errs, errors.New("unsupported Protocol h3: HTTP/3 runs over QUIC, which Go's standard library lacks"))
+	default:
+		errs = append(errs, fmt.Errorf("Protocol must be HTTP/1.1 or h2, not %q", h.Protocol))
+	}
+	if h.MinBodyBytes < 0 || h.MaxBodyBytes < 0 {
+		errs = append(errs, errors.New("MinBodyBytes and MaxBodyBytes must not be negative"))
+	}
+	if h.MaxBodyBytes > 0 && h.MinBodyBytes > h.MaxBodyBytes {
+		errs = append(errs, fmt.Errorf("MinBodyBytes %d is more than MaxBodyBytes %d", h.MinBodyBytes, h.MaxBodyBytes))
+	}
+	if b, err := hex.DecodeString(h.ExpectedSHA256); err != nil || (h.ExpectedSHA256 != "" && len(b) != sha256.Size) {
+		errs = append(errs, fmt.Errorf("ExpectedSHA256 %q isn't a hex SHA-256", h.ExpectedSHA256))
+	}
+	for name, want := range h.ExpectedHeaders {
+		if _, err := headerMatcher(want); err != nil {
+			errs = append(errs, fmt.Errorf("ExpectedHeaders %s: %v", name, err))
+		}
+	}
+	if h.Auth != nil {
+		if err := h.Auth.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if h.Cache != nil {
+		if err := h.Cache.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if h.FailoverURL != "" {
+		f := h
+		f.URL, f.FailoverURL = h.FailoverURL, ""
+		if _, err := parseURL(f, "http", "https"); err != nil {
+			errs = append(errs, fmt.Errorf("FailoverURL: %v", err))
+		}
+		if len(errs) == 0 {
+			return failoverURLChecker{primary: httpChecker{h: h}, failover: httpChecker{h: f}, always: h.ProbeFailover}, nil
+		}
+	} else if h.ProbeFailover {
+		errs = append(errs, errors.New("ProbeFailover needs a FailoverURL"))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return httpChecker{h: h}, nil
+}
+
+// cleartextHTTP2 returns h with a transport that uses HTTP/2 without TLS
+// (h2c with prior knowledge).
+func (h HealthCheck) cleartextHTTP2() HealthCheck {
+	var t *http.Transport
+	if base, ok := h.transport.(*http.Transport); ok {
+		t = base.Clone()
+	} else {
+		t = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	t.Protocols = new(http.Protocols)
+	t.Protocols.SetUnencryptedHTTP2(true)
+	h.transport = t
+	return h
+}
+
+// protocol returns the protocol of resp as named in the Protocol field.
+func protocol(resp *http.Response) string {
+	if resp.ProtoMajor == 2 {
+		return "h2"
+	}
+	return resp.Proto
+}
+
+func (c httpChecker) Check(ctx context.Context) Result {
+	resp, body, err := c.h.fetch(ctx)
+	if err != nil {
+		return Result{Err: err}
+	}
+	redirects, proto := redirectChain(resp), protocol(resp)
+	for _, a := range c.h.assertions() {
+		if err := a.check(resp, body); err != nil {
+			return Result{Err: err, Redirects: redirects, Protocol: proto}
+		}
+	}
+	return Result{Healthy: true, Redirects: redirects, Protocol: proto}
+}
+
+// redirectChain returns the URLs that redirected to the final response.
+func redirectChain(resp *http.Response) []string {
+	var chain []string
+	for r := resp.Request.Response; r != nil; r = r.Request.Response {
+		chain = append(chain, r.Request.URL.String())
+	}
+	slices.Reverse(chain)
+	return chain
+}
+
+// fetch gets the health check's URL and reads the whole response body.
+func (h HealthCheck) fetch(ctx context.Context) (*http.Response, []byte, error) {
+	client := http.Client{Transport: h.transport, Timeout: h.timeout()}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if h.FollowRedirects != nil && !*h.FollowRedirects {
+			return http.ErrUseLastResponse // check the redirect itself
+		}
+		if max := cmp.Or(h.MaxRedirects, 10); len(via) > max {
+			return fmt.Errorf("stopped after %d redirects", max)
+		}
+		return nil
+	}
+	req, err := http.NewRequestWithContext(traceSpans(traceSource(traceTiming(ctx))), http.MethodGet, h.URL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.Auth != nil {
+		if err := h.Auth.authorize(req, h.transport); err != nil {
+			return nil, nil, err
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if h.Auth != nil && resp.StatusCode == http.StatusUnauthorized {
+		h.Auth.forget() // maybe revoked, get a new one next time
+	}
+	var r io.Reader = resp.Body
+	if h.MaxBodyBytes > 0 {
+		r = io.LimitReader(r, h.MaxBodyBytes+1) // one more tells it's too long
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	timingOf(ctx).done()
+	return resp, body, nil
+}
+
+// assertion is a condition a response must meet for the check to be healthy.
+type assertion struct {
+	desc  string
+	check func(resp *http.Response, body []byte) error
+}
+
+func (h HealthCheck) assertions() []assertion {
+	as := []assertion{
+		{
+			desc: fmt.Sprintf("status is %d", h.HealthyStatusCode),
+			check: func(resp *http.Response, body []byte) error {
+				if resp.StatusCode != h.HealthyStatusCode {
+					return fmt.Errorf("got status %d, want %d", resp.StatusCode, h.HealthyStatusCode)
+				}
+				return nil
+			},
+		},
+	}
+	if h.Protocol != "" {
+		as = append(as, assertion{
+			desc: fmt.Sprintf("protocol is %s", h.Protocol),
+			check: func(resp *http.Response, body []byte) error {
+				if p := protocol(resp); p != h.Protocol {
+					return fmt.Errorf("got protocol %s, want %s", p, h.Protocol)
+				}
+				return nil
+			},
+		})
+	}
+	if h.MinBodyBytes > 0 {
+		as = append(as, assertion{
+			desc: fmt.Sprintf("body has at least %d bytes", h.MinBodyBytes),
+			check: func(resp *http.Response, body []byte) error {
+				if int64(len(body)) < h.MinBodyBytes {
+					return fmt.Errorf("got a body of %d bytes, want at least %d", len(body), h.MinBodyBytes)
+				}
+				return nil
+			},
+		})
+	}
+	if h.MaxBodyBytes > 0 {
+		as = append(as, assertion{
+			desc: fmt.Sprintf("body has at most %d bytes", h.MaxBodyBytes),
+			check: func(resp *http.Response, body []byte) error {
+				if int64(len(body)) > h.MaxBodyBytes {
+					return fmt.Errorf("got a body of more than %d bytes", h.MaxBodyBytes)
+				}
+				return nil
+			},
+		})
+	}
+	if h.ExpectedSHA256 != "" {
+		as = append(as, assertion{
+			desc: fmt.Sprintf("body has SHA-256 %s", h.ExpectedSHA256),
+			check: func(resp *http.Response, body []byte) error {
+				sum := sha256.Sum256(body)
+				if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, h.ExpectedSHA256) {
+					return fmt.Errorf("got a body with SHA-256 %s, want %s", got, h.ExpectedSHA256)
+				}
+				return nil
+			},
+		})
+	}
+	for _, name := range slices.Sorted(maps.Keys(h.ExpectedHeaders)) {
+		want := h.ExpectedHeaders[name]
+		match, _ := headerMatcher(want)
+		as = append(as, assertion{
+			desc: fmt.Sprintf("header %s is %s", name, want),
+			check: func(resp *http.Response, body []byte) error {
+				values := resp.Header.Values(name)
+				if len(values) == 0 {
+					return fmt.Errorf("header %s is missing", name)
+				}
+				if got := strings.Join(values, ", "); !match(got) {
+					return fmt.Errorf("got header %s: %s, want %s", name, got, want)
+				}
+				return nil
+			},
+		})
+	}
+	if h.Cache != nil {
+		as = append(as, h.Cache.assertions()...)
+	}
+	return as
+}
+
+// headerMatcher returns a function that reports whether a header value is
+// want or, if want is written as /regexp/, matches it.
+func headerMatcher(want string) (func(string) bool, error) {
+	if len(want) >= 2 && strings.HasPrefix(want, "/") && strings.HasSuffix(want, "/") {
+		re, err := regexp.Compile(want[1 : len(want)-1])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+	return func(got string) bool { return got == want }, nil
+}
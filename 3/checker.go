@@ -0,0 +1,279 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"x/checker"
+)
+
+// Result is the outcome of running a Checker once.
+type Result struct {
+	Healthy bool
+	Err     error  // why the check is unhealthy
+	Output  string // e.g. what a command printed, may be empty
+
+	// ConfigErr tells that Err is about the check's config rather than
+	// the health of what it checks, set by Do.
+	ConfigErr bool
+
+	Redirects []string // URLs an HTTP check was redirected from, in order
+	Protocol  string   // of the HTTP response, HTTP/1.1 or h2
+
+	// The local end of the check's last connection, set by Do.
+	Source    string // IP address
+	Interface string // network interface of Source, if known
+	Family    string // ipv4 or ipv6
+
+	Timing *httpTiming // of HTTP requests, set by Do
+}
+
+// Checker checks the health of something once. Check must return when ctx
+// is done.
+type Checker interface {
+	Check(ctx context.Context) Result
+}
+
+// checkerTypes maps the type of a health check to a function creating its
+// Checker. The function returns an error if the check is misconfigured.
+var checkerTypes = make(map[string]func(HealthCheck) (Checker, error))
+
+// registerChecker makes a check type available. It must be called before
+// any config is read, typically from an init function. Other packages
+// register theirs with checker.RegisterChecker, see registeredChecker.
+func registerChecker(typ string, newChecker func(HealthCheck) (Checker, error)) {
+	_, registered := checker.Lookup(typ)
+	if _, ok := checkerTypes[typ]; ok || registered {
+		panic("checker type registered twice: " + typ)
+	}
+	checkerTypes[typ] = newChecker
+}
+
+// registeredChecker runs a Checker of a type registered by another package
+// with checker.RegisterChecker.
+type registeredChecker struct {
+	checker.Checker
+}
+
+func newRegisteredChecker(h HealthCheck, newChecker func(checker.Config) (checker.Checker, error)) (Checker, error) {
+	c, err := newChecker(checker.Config{Name: h.Name, URL: h.URL, Timeout: h.timeout(), Options: h.Options})
+	if err != nil {
+		return nil, err
+	}
+	return registeredChecker{c}, nil
+}
+
+func (c registeredChecker) Check(ctx context.Context) Result {
+	r := c.Checker.Check(ctx)
+	return Result{Healthy: r.Healthy, Err: r.Err, Output: r.Output}
+}
+
+func init() {
+	registerChecker("http", newHTTPChecker)
+	registerChecker("https", newHTTPChecker)
+	registerChecker("tcp", newTCPChecker)
+	registerChecker("dns", newDNSChecker)
+	registerChecker("exec", newExecChecker)
+	registerChecker("unix", newUnixChecker)
+	registerChecker("dns-failover", newFailoverChecker)
+}
+
+// checkType returns the Type of the health check, defaulting to the scheme
+// of its URL.
+func (h HealthCheck) checkType() string {
+	if h.Type != "" {
+		return h.Type
+	}
+	if h.Exec != nil && !strings.HasPrefix(h.URL, "docker:") {
+		return "exec"
+	}
+	if len(h.Steps) > 0 {
+		return "transaction"
+	}
+	if u, err := url.Parse(h.URL); err == nil && u.Scheme != "" {
+		return u.Scheme
+	}
+	return "http"
+}
+
+func (h HealthCheck) checker() (Checker, error) {
+	if h.configErr != "" {
+		return nil, fmt.Errorf("config error: %s", h.configErr)
+	}
+	newChecker, ok := checkerTypes[h.checkType()]
+	if !ok {
+		registered, ok := checker.Lookup(h.checkType())
+		if !ok {
+			return nil, fmt.Errorf("unknown check type %q", h.checkType())
+		}
+		return newRegisteredChecker(h, registered)
+	}
+	h, err := h.resolving()
+	if err != nil {
+		return nil, err
+	}
+	return newChecker(h)
+}
+
+// parseURL parses the URL of h and makes sure it has one of the schemes
+// and a host.
+func parseURL(h HealthCheck, schemes ...string) (*url.URL, error) {
+	if h.URL == "" {
+		return nil, errors.New("missing URL")
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %v", err)
+	}
+	for _, s := range schemes {
+		if u.Scheme == s {
+			if u.Host == "" {
+				return nil, errors.New("URL has no host")
+			}
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("URL scheme must be %v, not %q", schemes, u.Scheme)
+}
+
+// tcpChecker is healthy if a TCP connection can be established, e.g. to
+// tcp://db.example.com:5432.
+type tcpChecker struct {
+	addr string
+	dial func(ctx context.Context, network, addr string) (net.Conn, error) // nil means net.Dialer's
+}
+
+func newTCPChecker(h HealthCheck) (Checker, error) {
+	u, err := parseURL(h, "tcp")
+	if err != nil {
+		return nil, err
+	}
+	if u.Port() == "" {
+		return nil, errors.New("URL has no port")
+	}
+	return tcpChecker{addr: u.Host, dial: h.dial}, nil
+}
+
+func (c tcpChecker) Check(ctx context.Context) Result {
+	dial := c.dial
+	if dial == nil {
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialHost(ctx, &net.Dialer{}, "", network, addr)
+		}
+	}
+	conn, err := dial(ctx, "tcp", c.addr)
+	if err != nil {
+		return Result{Err: err}
+	}
+	recordSource(ctx, conn)
+	conn.Close()
+	return Result{Healthy: true}
+}
+
+// dnsChecker is healthy if a name resolves to at least one address, e.g.
+// dns://www.example.com.
+type dnsChecker struct {
+	host     string
+	network  string // ip, ip4 or ip6
+	resolver *net.Resolver
+}
+
+func newDNSChecker(h HealthCheck) (Checker, error) {
+	u, err := parseURL(h, "dns")
+	if err != nil {
+		return nil, err
+	}
+	network, err := h.ipNetwork()
+	if err != nil {
+		return nil, err
+	}
+	return dnsChecker{host: u.Hostname(), network: network, resolver: h.lookup()}, nil
+}
+
+func (c dnsChecker) Check(ctx context.Context) Result {
+	addrs, err := c.resolver.LookupNetIP(ctx, c.network, c.host)
+	if err != nil {
+		return Result{Err: err}
+	}
+	if len(addrs) == 0 {
+		return Result{Err: fmt.Errorf("%s has no addresses", c.host)}
+	}
+	return Result{Healthy: true}
+}
+
+// newUnixChecker returns an HTTP checker that connects to the Unix socket
+// of a URL like unix:///var/run/app.sock and requests the check's Path.
+func newUnixChecker(h HealthCheck) (Checker, error) {
+	u, err := url.Parse(h.URL)
+	if err != nil || u.Scheme != "unix" || u.Path == "" {
+		return nil, fmt.Errorf("URL must look like unix:///path/to/socket, not %q", h.URL)
+	}
+	path := cmp.Or(h.Path, "/")
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("Path %q must start with /", path)
+	}
+	h = h.dialing("unix", u.Path)
+	h.URL = "http://localhost" + path
+	return newHTTPChecker(h)
+}
+
+// dialing returns h with a transport that connects to addr instead of the
+// host of the URL, keeping the other settings of its transport.
+func (h HealthCheck) dialing(network, addr string) HealthCheck {
+	return h.withDial(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	})
+}
+
+// dialContext connects to addr over TCP like the transport of h does.
+func (h HealthCheck) dialContext(ctx context.Context, addr string) (net.Conn, error) {
+	dial := h.dial
+	if dial == nil {
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialHost(ctx, &net.Dialer{}, "", network, addr)
+		}
+	}
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	recordSource(ctx, conn)
+	return conn, nil
+}
+
+// tlsConfig returns the TLS settings of the transport of h, e.g. its CAs,
+// for connecting to serverName without HTTP.
+func (h HealthCheck) tlsConfig(serverName string) *tls.Config {
+	cfg := &tls.Config{}
+	if t, ok := h.transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+		cfg = t.TLSClientConfig.Clone()
+	}
+	cfg.ServerName = serverName
+	return cfg
+}
+
+// withDial returns h with a transport that makes connections with dial.
+func (h HealthCheck) withDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) HealthCheck {
+	var t *http.Transport
+	if base, ok := h.transport.(*http.Transport); ok {
+		t = base.Clone()
+	} else {
+		t = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	t.Proxy = nil
+	t.DialContext = dial
+	reuseHosts(t)
+	h.dial = dial
+	// The transport is made for each run, don't leave idle connections behind.
+	t.DisableKeepAlives = true
+	h.transport = t
+	return h
+}
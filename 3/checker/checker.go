@@ -0,0 +1,64 @@
+// Package checker lets other packages add check types to the health
+// checker. A package registers its types with RegisterChecker from an init
+// function, and importing it for its side effects in the checker's main
+// package makes them available as the Type of health checks:
+//
+//	import _ "example.com/healthcheck-smtp-relay"
+//
+// Checks of such a type get their settings beyond Name, URL and
+// ResponseTimeout from their Options, as JSON.
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Config is the config of a health check of a registered type.
+type Config struct {
+	Name    string
+	URL     string
+	Timeout time.Duration   // of a run, zero means none
+	Options json.RawMessage // of the check, nil if it has none
+}
+
+// Result is the outcome of running a Checker once.
+type Result struct {
+	Healthy bool
+	Err     error  // why the check is unhealthy
+	Output  string // e.g. what a command printed, may be empty
+}
+
+// Checker checks the health of something once. Check must return when ctx
+// is done.
+type Checker interface {
+	Check(ctx context.Context) Result
+}
+
+var (
+	mu    sync.Mutex
+	types = make(map[string]func(Config) (Checker, error))
+)
+
+// RegisterChecker makes a check type available. newChecker returns an
+// error if a check is misconfigured. It panics if typ is already
+// registered, including by the checker itself.
+func RegisterChecker(typ string, newChecker func(Config) (Checker, error)) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := types[typ]; ok {
+		panic(fmt.Sprintf("checker type registered twice: %s", typ))
+	}
+	types[typ] = newChecker
+}
+
+// Lookup returns the function creating Checkers of a registered type.
+func Lookup(typ string) (newChecker func(Config) (Checker, error), ok bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	newChecker, ok = types[typ]
+	return newChecker, ok
+}
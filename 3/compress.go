@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Results and configs sent between agents and the aggregate server are
+// compressed with gzip for sites on slow links. The aggregate server tells
+// that it accepts gzip request bodies with Accept-Encoding in its
+// responses (RFC 7694), so agents compress once they know and keep
+// working with older servers.
+//
+// gzip is the only coding. zstd would compress better, but the standard
+// library has no implementation of it and the module has no dependencies,
+// so it's declined explicitly: a zstd or other body is rejected with 415
+// Unsupported Media Type and Accept-Encoding: gzip, and an agent that gets
+// that stops compressing until the server lists gzip again. Results to
+// InfluxDB are gzipped too, and to Graphite with -graphite-gzip.
+
+// acceptsGzip reports whether the Accept-Encoding header of h lists gzip.
+func acceptsGzip(h http.Header) bool {
+	for _, v := range h.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gzipBytes returns b compressed with gzip.
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b) // writes to a bytes.Buffer don't fail
+	zw.Close()
+	return buf.Bytes()
+}
+
+// requestBody returns the body of r decoded by its Content-Encoding, at
+// most limit bytes of it after decoding. It writes the error response if
+// the encoding isn't supported or invalid.
+func requestBody(w http.ResponseWriter, r *http.Request, limit int64) (io.ReadCloser, bool) {
+	switch strings.ToLower(r.Header.Get("Content-Encoding")) {
+	case "", "identity":
+		return http.MaxBytesReader(w, r.Body, limit), true
+	case "gzip":
+		zr, err := gzip.NewReader(http.MaxBytesReader(w, r.Body, limit))
+		if err != nil {
+			http.Error(w, "invalid gzip body: "+err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		return http.MaxBytesReader(w, zr, limit), true
+	}
+	w.Header().Set("Accept-Encoding", "gzip")
+	http.Error(w, "unsupported Content-Encoding "+r.Header.Get("Content-Encoding")+", want gzip", http.StatusUnsupportedMediaType)
+	return nil, false
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Settings of all commands that read the config, see addConfigFlags.
+var (
+	// secretsFile holds KEY=VALUE lines used to expand ${KEY} in the config
+	// before the environment is consulted. Empty means no secrets file.
+	secretsFile string
+
+	// environment selects the Env overlay applied to each check, e.g. prod.
+	environment string
+
+	// templated configs are run through text/template before parsing.
+	templated bool
+
+	// keepGoing makes readConfig return checks with problems too, marked
+	// with their configErr, instead of failing, see -keep-going.
+	keepGoing bool
+)
+
+// addConfigFlags registers the flags of all commands that read the config.
+func addConfigFlags(fs *flag.FlagSet) *string {
+	fs.StringVar(&secretsFile, "secrets", "", "expand ${VAR} in the config from KEY=VALUE lines in `file` before the environment")
+	fs.StringVar(&environment, "env", "", "apply the checks' overrides for the `environment`, e.g. prod")
+	fs.BoolVar(&templated, "template", false, "run the config through Go's text/template first (line numbers refer to its output)")
+	addLangFlag(fs)
+	return fs.String("config", "healthchecks.json", "read health checks from `file` (running checks, a comma separated list of files and directories of *.json files, each a namespace of its own)")
+}
+
+// defaultResponseTimeout is used when neither a check nor the config set a
+// timeout, so a missing field can never hang a check forever.
+const defaultResponseTimeout = 10 * time.Second
+
+// noTimeout disables the response timeout. It's written as "none" in JSON.
+const noTimeout = -1
+
+// duration is a time.Duration that is a number of nanoseconds or a string
+// like "2s" or "none" in JSON.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*d = duration(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a number of nanoseconds or a string like \"2s\", not %s", data)
+	}
+	if s == "none" {
+		*d = noTimeout
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	if d == noTimeout {
+		return json.Marshal("none")
+	}
+	return json.Marshal(time.Duration(d).String())
+}
+
+// config is the object form of the config file. The config file can also
+// be just the array of health checks.
+type config struct {
+	DefaultTimeout duration // for checks without ResponseTimeout
+	Transport      transportConfig
+	Maintenance    []window // of all checks
+	Checks         []HealthCheck
+
+	// Profiles are transports of their own that checks select by name with
+	// their Profile, e.g. per team, so one team's proxy or TLS settings
+	// can't affect the checks of others or exhaust their connections.
+	Profiles map[string]transportConfig `json:",omitempty"`
+
+	Notifications *notifications `json:",omitempty"`
+}
+
+// transportConfig configures the HTTP transport shared by all checks of a
+// config, so connections are kept alive and reused between runs.
+type transportConfig struct {
+	MaxIdleConns        int      // zero means Go's default
+	MaxIdleConnsPerHost int      // zero means Go's default
+	MaxConnsPerHost     int      // zero means no limit
+	IdleConnTimeout     duration // zero means Go's default
+	CAFile              string   // PEM file with CAs to trust instead of the system ones
+	InsecureSkipVerify  bool     // don't verify server certificates
+
+	// Proxy is the URL of an HTTP or SOCKS5 proxy, e.g.
+	// socks5://localhost:1080, or "none" to connect directly. By default
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY are honored.
+	Proxy string `json:",omitempty"`
+}
+
+func (c transportConfig) transport() (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if c.MaxIdleConns > 0 {
+		t.MaxIdleConns = c.MaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+	t.MaxConnsPerHost = c.MaxConnsPerHost
+	if c.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = time.Duration(c.IdleConnTimeout)
+	}
+	switch c.Proxy {
+	case "":
+		// Keep http.ProxyFromEnvironment.
+	case "none":
+		t.Proxy = nil
+	default:
+		u, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Proxy: %v", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+			return nil, fmt.Errorf("Proxy: unsupported scheme %q, want http, https or socks5", u.Scheme)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	t.TLSClientConfig = &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		pool, err := certPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second} // http.DefaultTransport's
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialHost(ctx, d, "", network, addr)
+	}
+	reuseHosts(t)
+	return t, nil
+}
+
+func readConfig(filepath string) ([]HealthCheck, error) {
+	hs, lines, problems, err := readConfigLines(filepath)
+	if err != nil {
+		return nil, err
+	}
+	if keepGoing {
+		for _, p := range problems {
+			msg := fmt.Sprintf("%s:%d: %s", filepath, lines[p.Check], p.Msg)
+			if hs[p.Check].configErr != "" {
+				msg = hs[p.Check].configErr + "; " + p.Msg
+			}
+			hs[p.Check].configErr = msg
+		}
+		return hs, nil
+	}
+	if len(problems) > 0 {
+		p := problems[0]
+		return nil, fmt.Errorf("%s:%d: check %d: %s", filepath, lines[p.Check], p.Check+1, p.Msg)
+	}
+	return hs, nil
+}
+
+// readConfigLines is like readConfig but also returns the line on which
+// each health check starts, so problems can be reported with context.
+// Values of the wrong type are returned as problems rather than an error,
+// so all of them can be reported at once. The overrides for the selected
+// environment are applied and checks without a ResponseTimeout get the
+// config's DefaultTimeout.
+func readConfigLines(filepath string) ([]HealthCheck, []int, []problem, error) {
+	data, err := readConfigData(filepath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return parseConfig(filepath, data)
+}
+
+// readConfigData returns the config file with its template executed and
+// its ${VAR}s expanded.
+func readConfigData(filepath string) ([]byte, error) {
+	data, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	if templated {
+		if data, err = executeTemplate(filepath, data); err != nil {
+			return nil, err
+		}
+	}
+	return expandVars(filepath, data)
+}
+
+// parseConfig is readConfigLines of the config data read from filepath.
+func parseConfig(filepath string, data []byte) ([]HealthCheck, []int, []problem, error) {
+	lineAt := func(offset int64) int {
+		return 1 + bytes.Count(data[:offset], []byte("\n"))
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	syntaxErr := func(err error) error {
+		return fmt.Errorf("%s:%d: %v", filepath, lineAt(dec.InputOffset()), err)
+	}
+	var cfg config
+	var lines []int
+	var problems []problem
+	decodeChecks := func() error {
+		if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+			return syntaxErr(errors.New("want a JSON array of health checks"))
+		}
+		for dec.More() {
+			// Skip the whitespace and comma between the previous element and this one.
+			start := dec.InputOffset()
+			for start < int64(len(data)) && bytes.IndexByte([]byte(" \t\r\n,"), data[start]) >= 0 {
+				start++
+			}
+			var h HealthCheck
+			if err := dec.Decode(&h); err != nil {
+				if !isValueError(err) {
+					return syntaxErr(err)
+				}
+				// The decoder skipped the bad value, keep going to report all of them.
+				problems = append(problems, problem{Check: len(cfg.Checks), Msg: err.Error()})
+			}
+			cfg.Checks = append(cfg.Checks, h)
+			lines = append(lines, lineAt(start))
+		}
+		if _, err := dec.Token(); err != nil {
+			return syntaxErr(err)
+		}
+		return nil
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := decodeChecks(); err != nil {
+			return nil, nil, nil, err
+		}
+	} else {
+		if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+			return nil, nil, nil, syntaxErr(errors.New("config must be a JSON object or an array of health checks"))
+		}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, nil, nil, syntaxErr(err)
+			}
+			switch key {
+			case "Checks":
+				err = decodeChecks()
+			case "DefaultTimeout":
+				if err = dec.Decode(&cfg.DefaultTimeout); err != nil {
+					err = syntaxErr(fmt.Errorf("DefaultTimeout: %v", err))
+				}
+			case "$schema":
+				// Lets editors find the schema, see the schema subcommand.
+				var ignored json.RawMessage
+				err = dec.Decode(&ignored)
+			case "Maintenance":
+				if err = dec.Decode(&cfg.Maintenance); err != nil {
+					err = syntaxErr(fmt.Errorf("Maintenance: %v", err))
+				}
+			case "Transport":
+				if err = dec.Decode(&cfg.Transport); err != nil {
+					err = syntaxErr(fmt.Errorf("Transport: %v", err))
+				}
+			case "Profiles":
+				if err = dec.Decode(&cfg.Profiles); err != nil {
+					err = syntaxErr(fmt.Errorf("Profiles: %v", err))
+				}
+			case "Notifications":
+				if err = dec.Decode(&cfg.Notifications); err != nil {
+					err = syntaxErr(fmt.Errorf("Notifications: %v", err))
+				}
+			default:
+				err = syntaxErr(fmt.Errorf("unknown field %v", key))
+			}
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, nil, nil, syntaxErr(err)
+		}
+	}
+
+	for _, w := range cfg.Maintenance {
+		if !w.End.After(w.Start) {
+			return nil, nil, nil, fmt.Errorf("%s: Maintenance: End %v must be after Start %v", filepath, w.End, w.Start)
+		}
+	}
+	transport, err := cfg.Transport.transport()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%s: Transport: %v", filepath, err)
+	}
+	profiles := make(map[string]*http.Transport)
+	for name, tc := range cfg.Profiles {
+		if profiles[name], err = tc.transport(); err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: Profiles: %s: %v", filepath, name, err)
+		}
+	}
+	routeOf, err := cfg.Notifications.routes()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%s: Notifications: %v", filepath, err)
+	}
+	// Checks with their own Proxy share a transport per profile and proxy.
+	proxied := make(map[[2]string]*http.Transport)
+	for i := range cfg.Checks {
+		cfg.Checks[i].transport = transport
+		cfg.Checks[i].globalMaintenance = cfg.Maintenance
+		if overlay, ok := cfg.Checks[i].Env[environment]; ok && environment != "" {
+			// Unmarshaling into the check only sets the fields the overlay has.
+			if err := json.Unmarshal(overlay, &cfg.Checks[i]); err != nil {
+				problems = append(problems, problem{Check: i, Msg: fmt.Sprintf("Env %s: %v", environment, err)})
+			}
+		}
+		if u, err := normalizeURL(cfg.Checks[i].URL); err != nil {
+			problems = append(problems, problem{Check: i, Msg: err.Error()})
+		} else {
+			cfg.Checks[i].URL = u
+		}
+		tc := cfg.Transport
+		if name := cfg.Checks[i].Profile; name != "" {
+			if t, ok := profiles[name]; ok {
+				cfg.Checks[i].transport = t
+				tc = cfg.Profiles[name]
+			} else {
+				problems = append(problems, problem{Check: i, Msg: fmt.Sprintf("unknown Profile %q", name)})
+			}
+		}
+		if p := cfg.Checks[i].Proxy; p != "" {
+			key := [2]string{cfg.Checks[i].Profile, p}
+			t, ok := proxied[key]
+			if !ok {
+				tc.Proxy = p
+				t, err = tc.transport()
+				if err != nil {
+					problems = append(problems, problem{Check: i, Msg: err.Error()})
+				}
+				proxied[key] = t
+			}
+			if t != nil {
+				cfg.Checks[i].transport = t
+			}
+		}
+		if _, err := cfg.Checks[i].schedule(); err != nil {
+			problems = append(problems, problem{Check: i, Msg: err.Error()})
+		}
+		for k, values := range cfg.Checks[i].RunOn {
+			if len(values) == 0 {
+				problems = append(problems, problem{Check: i, Msg: fmt.Sprintf("RunOn: %s has no values, so no agent would run the check", k)})
+			}
+		}
+		if cfg.Checks[i].ResponseTimeout == 0 {
+			cfg.Checks[i].ResponseTimeout = cfg.DefaultTimeout
+		}
+		cfg.Checks[i].route = routeOf(cfg.Checks[i])
+	}
+	disambiguate(cfg.Checks)
+	return cfg.Checks, lines, problems, nil
+}
+
+// disambiguate numbers the checks of hs that have the same Name or URL as
+// an earlier one, so each has an ID of its own: two unnamed checks of a URL
+// with different HealthyStatusCodes are reported, scheduled and changed
+// via the API as URL and URL#2.
+func disambiguate(hs []HealthCheck) {
+	seen := make(map[string]int)
+	for i := range hs {
+		hs[i].dup = 0
+		id := hs[i].ID()
+		hs[i].dup = seen[id]
+		seen[id]++
+	}
+}
+
+// isValueError reports whether err is about a single bad value rather than
+// the JSON syntax, so the decoder can go on with the next value.
+func isValueError(err error) bool {
+	var syntaxErr *json.SyntaxError
+	return !errors.As(err, &syntaxErr) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF)
+}
+
+// writeConfig replaces the config file atomically so a concurrent
+// readConfig never sees a partially written file. It refuses to replace a
+// config that uses variables, as that would write out their values. The
+// checks are written with their default timeouts resolved, other settings
+// are kept.
+func writeConfig(filepath string, hs []HealthCheck) error {
+	old, err := os.ReadFile(filepath)
+	if err == nil && varRE.Match(old) {
+		return fmt.Errorf("%s uses ${VAR} variables, not overwriting it with their values", filepath)
+	}
+	if environment != "" {
+		return fmt.Errorf("not overwriting %s with the overrides for environment %s applied", filepath, environment)
+	}
+	if templated {
+		return fmt.Errorf("not overwriting template %s with its output", filepath)
+	}
+	var v any = hs
+	// Keep the other settings of a config in object form.
+	var obj map[string]any
+	if json.Unmarshal(old, &obj) == nil {
+		obj["Checks"] = hs
+		v = obj
+	}
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath)
+}
+
+// problem is something wrong with the health check at index Check.
+type problem struct {
+	Check int
+	Msg   string
+}
+
+func printProblems(filepath string, hs []HealthCheck, lines []int, ps []problem) {
+	for _, p := range ps {
+		fmt.Print(tr("%s:%d: check %d (%s): %s\n", filepath, lines[p.Check], p.Check+1, hs[p.Check].ID(), p.Msg))
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// configChange is a change of the daemon's config, stored next to the
+// history so monitoring changes can be audited. Checks are the new
+// definitions of the added and modified checks, so every version of a
+// check is kept.
+type configChange struct {
+	configDiff
+	Source string        // start, reload, api or discovery
+	Actor  string        `json:",omitempty"` // remote address of API requests
+	Checks []HealthCheck `json:",omitempty"`
+}
+
+// configHistoryPath returns the path of the config changes stored with
+// the history file historyFile.
+func configHistoryPath(historyFile string) string {
+	return historyFile + ".config"
+}
+
+// addChange stores c. The file is only readable by its owner because the
+// definitions may contain secrets expanded from ${VAR}.
+func (h *history) addChange(c configChange) error {
+	if h.f == nil {
+		return errors.New("history opened only for reading")
+	}
+	f, err := os.OpenFile(configHistoryPath(h.path), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(c); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// changes returns the stored config changes not older than t.
+func (h *history) changes(t time.Time) ([]configChange, error) {
+	f, err := os.Open(configHistoryPath(h.path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var cs []configChange
+	dec := json.NewDecoder(f)
+	for {
+		var c configChange
+		err := dec.Decode(&c)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !c.Time.Before(t) {
+			cs = append(cs, c)
+		}
+	}
+	return cs, nil
+}
+
+// recordChange stores the config change diff if the daemon has a history.
+func (d *daemon) recordChange(source, actor string, diff configDiff, checks []HealthCheck) {
+	if d.hist == nil {
+		return
+	}
+	c := configChange{configDiff: diff, Source: source, Actor: actor}
+	for _, h := range checks {
+		id := h.ID()
+		if slices.Contains(diff.Added, id) || slices.ContainsFunc(diff.Modified, func(m checkChange) bool { return m.ID == id }) {
+			c.Checks = append(c.Checks, h)
+		}
+	}
+	err := d.hist.addChange(c)
+	if err != nil {
+		slog.Error("can't store config change", "err", err)
+	}
+	d.report("history", err)
+}
+
+// recordStart stores how the config differs from the last stored one,
+// e.g. because it was edited while the daemon wasn't running.
+func (d *daemon) recordStart() {
+	if d.hist == nil {
+		return
+	}
+	cs, err := d.hist.changes(time.Time{})
+	if err != nil {
+		slog.Error("can't read config changes", "err", err)
+		return
+	}
+	last := make(map[string]HealthCheck)
+	var order []string
+	for _, c := range cs {
+		for _, id := range c.Removed {
+			delete(last, id)
+		}
+		for _, h := range c.Checks {
+			if !slices.Contains(order, h.ID()) {
+				order = append(order, h.ID())
+			}
+			last[h.ID()] = h
+		}
+	}
+	var old []HealthCheck
+	for _, id := range order {
+		if h, ok := last[id]; ok {
+			old = append(old, h)
+		}
+	}
+	d.mu.Lock()
+	checks := d.currentChecks()
+	d.mu.Unlock()
+	if diff := diffChecks(old, checks); !diff.empty() {
+		d.recordChange("start", "", diff, checks)
+	}
+}
+
+// configCommand is the config subcommand. Its only subcommand, history,
+// lists the changes of the config stored by the daemon.
+func configCommand(args []string) error {
+	if len(args) == 0 || args[0] != "history" {
+		return errors.New("usage: config history [flags]")
+	}
+	fs := flag.NewFlagSet("config history", flag.ExitOnError)
+	historyFile := fs.String("history", "history.jsonl", "read config changes stored with the history `file` or in PostgreSQL at a postgres:// URL")
+	window := fs.String("window", "30d", "list the changes of the last `period` (e.g. 24h, 7d, 30d)")
+	name := fs.String("name", "", "list only changes of checks whose name (or URL) matches the glob `pattern`")
+	format := fs.String("format", "table", "output `format`: table, json, which includes the check definitions, or csv")
+	version := addOutputVersionFlag(fs)
+	addLangFlag(fs)
+	fs.Parse(args[1:])
+
+	if _, err := path.Match(*name, ""); err != nil {
+		return fmt.Errorf("-name: %v", err)
+	}
+	d, err := parseWindow(*window)
+	if err != nil {
+		return err
+	}
+	hist, err := openStore(*historyFile, false)
+	if err != nil {
+		return err
+	}
+	defer hist.Close()
+	cs, err := hist.changes(time.Now().Add(-d))
+	if err != nil {
+		return err
+	}
+	if *name != "" {
+		cs = filterChanges(cs, *name)
+	}
+
+	if *format != "table" || *version != 0 {
+		return writeOutput(*format, *version, changeColumns, cs)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, tr("TIME\tSOURCE\tACTOR\tCHANGES"))
+	for _, c := range cs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Time.Local().Format(time.DateTime), c.Source, c.Actor, c.configDiff)
+	}
+	return tw.Flush()
+}
+
+// changeColumns are the columns of config history, see outputVersion.
+var changeColumns = []column[configChange]{
+	{json: "Time", csv: "time", since: 1, value: func(c configChange) any { return c.Time }},
+	{json: "Source", csv: "source", since: 1, value: func(c configChange) any { return c.Source }},
+	{json: "Actor", csv: "actor", since: 1, value: func(c configChange) any { return c.Actor }},
+	{json: "Added", csv: "added", since: 1, value: func(c configChange) any { return c.Added }},
+	{json: "Removed", csv: "removed", since: 1, value: func(c configChange) any { return c.Removed }},
+	{json: "Modified", csv: "modified", since: 1, value: func(c configChange) any { return c.Modified },
+		text: func(c configChange) string {
+			parts := make([]string, len(c.Modified))
+			for i, m := range c.Modified {
+				parts[i] = m.ID + "(" + strings.Join(m.Fields, ",") + ")"
+			}
+			return strings.Join(parts, ";")
+		}},
+	{json: "Checks", since: 1, value: func(c configChange) any { return c.Checks }},
+}
+
+// filterChanges returns the changes of the checks whose ID matches the
+// glob pattern, with the other checks left out.
+func filterChanges(cs []configChange, pattern string) []configChange {
+	match := func(id string) bool {
+		ok, _ := path.Match(pattern, id)
+		return ok
+	}
+	var out []configChange
+	for _, c := range cs {
+		c.Added = slices.DeleteFunc(slices.Clone(c.Added), func(id string) bool { return !match(id) })
+		c.Removed = slices.DeleteFunc(slices.Clone(c.Removed), func(id string) bool { return !match(id) })
+		c.Modified = slices.DeleteFunc(slices.Clone(c.Modified), func(m checkChange) bool { return !match(m.ID) })
+		c.Checks = slices.DeleteFunc(slices.Clone(c.Checks), func(h HealthCheck) bool { return !match(h.ID()) })
+		if !c.empty() {
+			out = append(out, c)
+		}
+	}
+	return out
+}
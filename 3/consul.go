@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// consulAgent registers health checks as TTL checks with the local Consul
+// agent and reports their results, so Consul can take unhealthy service
+// instances out of service discovery.
+type consulAgent struct {
+	addr      string        // e.g. http://127.0.0.1:8500
+	ttl       time.Duration // should be a few times the check interval
+	serviceID string        // service the checks belong to, optional
+	client    *http.Client
+}
+
+func newConsulAgent(addr string, ttl time.Duration, serviceID string) *consulAgent {
+	return &consulAgent{
+		addr:      strings.TrimSuffix(addr, "/"),
+		ttl:       ttl,
+		serviceID: serviceID,
+		client:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func consulCheckID(id string) string {
+	return "healthcheck:" + id
+}
+
+func (c *consulAgent) put(path string, v any) error {
+	var body []byte
+	if v != nil {
+		var err error
+		if body, err = json.Marshal(v); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPut, c.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	return doRequest(c.client, req)
+}
+
+func (c *consulAgent) register(h HealthCheck) error {
+	return c.put("/v1/agent/check/register", map[string]string{
+		"ID":        consulCheckID(h.ID()),
+		"Name":      h.ID(),
+		"Notes":     "Checks " + h.URL,
+		"TTL":       c.ttl.String(),
+		"ServiceID": c.serviceID,
+	})
+}
+
+func (c *consulAgent) update(h HealthCheck, healthy bool, err error) error {
+	status, output := "passing", "healthy"
+	if !healthy {
+		status, output = "critical", err.Error()
+	}
+	return c.put("/v1/agent/check/update/"+url.PathEscape(consulCheckID(h.ID())), map[string]string{
+		"Status": status,
+		"Output": output,
+	})
+}
+
+func (c *consulAgent) deregister(id string) error {
+	return c.put("/v1/agent/check/deregister/"+url.PathEscape(consulCheckID(id)), nil)
+}
+
+// reportToConsul registers h with Consul if that didn't happen yet and
+// pushes the result.
+func (d *daemon) reportToConsul(e *entry, healthy bool, err error) {
+	d.mu.Lock()
+	registered := e.consulRegistered
+	d.mu.Unlock()
+	if !registered {
+		if err := d.consul.register(e.check); err != nil {
+			d.report("consul", err)
+			slog.Error("can't register Consul check", append(checkAttrs(e.check), "err", err)...)
+			return
+		}
+		d.mu.Lock()
+		e.consulRegistered = true
+		d.mu.Unlock()
+	}
+	err = d.consul.update(e.check, healthy, err)
+	d.report("consul", err)
+	if err != nil {
+		slog.Error("can't update Consul check", append(checkAttrs(e.check), "err", err)...)
+	}
+}
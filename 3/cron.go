@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule is a parsed cron expression with the usual five fields:
+// minute, hour, day of month, month and day of week.
+type schedule struct {
+	minute, hour, dom, month, dow uint64 // bit n set means value n matches
+	domAny, dowAny                bool   // the field starts with *
+	loc                           *time.Location
+}
+
+var cronDescriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+var (
+	monthNames = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+	dayNames   = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+)
+
+// parseSchedule parses a cron expression like "*/5 9-17 * * MON-FRI" or a
+// descriptor like "@daily". Times are matched in loc.
+func parseSchedule(expr string, loc *time.Location) (*schedule, error) {
+	if d, ok := cronDescriptors[expr]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q: want 5 fields, got %d", expr, len(fields))
+	}
+	s := &schedule{loc: loc}
+	var err error
+	parse := func(i int, min, max int, names []string, nameBase int) uint64 {
+		if err != nil {
+			return 0
+		}
+		var bits uint64
+		bits, err = parseCronField(fields[i], min, max, names, nameBase)
+		if err != nil {
+			err = fmt.Errorf("cron expression %q: field %d: %v", expr, i+1, err)
+		}
+		return bits
+	}
+	s.minute = parse(0, 0, 59, nil, 0)
+	s.hour = parse(1, 0, 23, nil, 0)
+	s.dom = parse(2, 1, 31, nil, 0)
+	s.month = parse(3, 1, 12, monthNames, 1)
+	s.dow = parse(4, 0, 7, dayNames, 0)
+	if err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 { // 7 is Sunday too
+		s.dow |= 1
+	}
+	// Like Vixie cron, a field starting with * is unrestricted for
+	// dayMatches even with a step, e.g. */2.
+	s.domAny = strings.HasPrefix(fields[2], "*")
+	s.dowAny = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of *, n, a-b, each
+// optionally followed by /step.
+func parseCronField(field string, min, max int, names []string, nameBase int) (uint64, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(s, name) {
+				return i + nameBase, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("%q is not a number from %d to %d", s, min, max)
+		}
+		return n, nil
+	}
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = value(first); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = value(last); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = max // a/n means from a to the end
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// dayMatches implements cron's rule that when both the day of month and
+// the day of week are restricted, either of them matching is enough.
+func (s *schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<t.Weekday()) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after t that matches the schedule, or the
+// zero time if there's none in the next five years (e.g. "0 0 30 2 *").
+func (s *schedule) next(t time.Time) time.Time {
+	t = t.In(s.loc)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, s.loc)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		switch {
+		case s.month&(1<<m) == 0:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, s.loc)
+		case !s.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, s.loc)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, s.loc)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// schedule returns the parsed Schedule of h, or nil if it has none and
+// runs every daemon interval.
+func (h HealthCheck) schedule() (*schedule, error) {
+	if h.Schedule == "" {
+		if h.Timezone != "" {
+			return nil, fmt.Errorf("Timezone without Schedule")
+		}
+		return nil, nil
+	}
+	loc := time.Local
+	if h.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(h.Timezone); err != nil {
+			return nil, fmt.Errorf("Timezone: %v", err)
+		}
+	}
+	return parseSchedule(h.Schedule, loc)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ctConfig configures a ct check.
+type ctConfig struct {
+	Issuers    []string `json:",omitempty"` // expected issuers, substrings of the issuer's DN, e.g. "O=Let's Encrypt"
+	KeySHA256  []string `json:",omitempty"` // hex SHA-256 of the expected public keys (SPKI), empty means any
+	Subdomains bool     `json:",omitempty"` // also look at certificates of subdomains
+	Window     duration `json:",omitempty"` // how far back to look, zero means 48h
+	Ignore     []string `json:",omitempty"` // serial numbers of certificates known to be fine
+	Server     string   `json:",omitempty"` // crt.sh compatible search, defaults to https://crt.sh
+}
+
+// Defaults of ct checks.
+const (
+	defaultCTServer = "https://crt.sh"
+	defaultCTWindow = 48 * time.Hour
+	maxCTKeyFetches = 20 // certificates whose key is looked at per run
+)
+
+// ctChecker searches certificate transparency logs, through crt.sh, for
+// certificates of a domain, e.g. ct://example.com, and is unhealthy while
+// one logged within the Window wasn't issued by one of the Issuers or for
+// one of the keys. Logs are searched rather than watched, so a
+// mis-issued certificate is reported until it's older than the Window or
+// its serial number is in Ignore.
+type ctChecker struct {
+	h      HealthCheck
+	domain string
+	cfg    ctConfig
+}
+
+func init() {
+	registerChecker("ct", newCTChecker)
+}
+
+func newCTChecker(h HealthCheck) (Checker, error) {
+	u, err := parseURL(h, "ct")
+	if err != nil {
+		return nil, err
+	}
+	if h.CT == nil || len(h.CT.Issuers) == 0 && len(h.CT.KeySHA256) == 0 {
+		return nil, errors.New("missing CT.Issuers or CT.KeySHA256")
+	}
+	cfg := *h.CT
+	for _, k := range cfg.KeySHA256 {
+		if b, err := hex.DecodeString(k); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("CT.KeySHA256 %q isn't a hex SHA-256", k)
+		}
+	}
+	if cfg.Window < 0 {
+		return nil, errors.New("CT.Window must not be negative")
+	}
+	if cfg.Window == 0 {
+		cfg.Window = duration(defaultCTWindow)
+	}
+	if cfg.Server == "" {
+		cfg.Server = defaultCTServer
+	}
+	return ctChecker{h: h, domain: strings.ToLower(u.Hostname()), cfg: cfg}, nil
+}
+
+// ctEntry is a certificate found by crt.sh.
+type ctEntry struct {
+	ID             int64  `json:"id"`
+	IssuerName     string `json:"issuer_name"`
+	NameValue      string `json:"name_value"` // names, one per line
+	SerialNumber   string `json:"serial_number"`
+	EntryTimestamp string `json:"entry_timestamp"` // UTC without a zone
+}
+
+func (c ctChecker) Check(ctx context.Context) Result {
+	queries := []string{c.domain}
+	if c.cfg.Subdomains {
+		queries = append(queries, "%."+c.domain)
+	}
+	var entries []ctEntry
+	for _, q := range queries {
+		var found []ctEntry
+		if err := c.get(ctx, "/?output=json&exclude=expired&q="+url.QueryEscape(q), func(r io.Reader) error {
+			return json.NewDecoder(r).Decode(&found)
+		}); err != nil {
+			return Result{Err: err}
+		}
+		entries = append(entries, found...)
+	}
+
+	since := time.Now().Add(-time.Duration(c.cfg.Window))
+	var out strings.Builder
+	var bad []string
+	seen := make(map[string]bool) // precertificates and certificates share serials
+	fetches := 0
+	for _, e := range entries {
+		logged, err := time.Parse("2006-01-02T15:04:05.999999999", e.EntryTimestamp)
+		if err != nil || logged.Before(since) || seen[e.SerialNumber] {
+			continue
+		}
+		seen[e.SerialNumber] = true
+		names := strings.ReplaceAll(e.NameValue, "\n", ",")
+		fmt.Fprintf(&out, "%s %s %s %s\n", logged.Format(time.RFC3339), e.SerialNumber, names, e.IssuerName)
+		if slices.Contains(c.cfg.Ignore, e.SerialNumber) {
+			continue
+		}
+		problem := ""
+		if len(c.cfg.Issuers) > 0 && !slices.ContainsFunc(c.cfg.Issuers, func(i string) bool { return strings.Contains(e.IssuerName, i) }) {
+			problem = "issued by " + e.IssuerName
+		} else if len(c.cfg.KeySHA256) > 0 {
+			if fetches++; fetches > maxCTKeyFetches {
+				continue // next time
+			}
+			key, err := c.keySHA256(ctx, e.ID)
+			if err != nil {
+				return Result{Err: err, Output: out.String()}
+			}
+			if !slices.ContainsFunc(c.cfg.KeySHA256, func(k string) bool { return strings.EqualFold(k, key) }) {
+				problem = "for key " + key
+			}
+		}
+		if problem != "" {
+			bad = append(bad, fmt.Sprintf("serial %s for %s %s (%s/?id=%d)", e.SerialNumber, names, problem, c.cfg.Server, e.ID))
+		}
+	}
+	if len(bad) > 0 {
+		return Result{Err: fmt.Errorf("%d unexpected certificates for %s: %s", len(bad), c.domain, strings.Join(bad, "; ")), Output: out.String()}
+	}
+	return Result{Healthy: true, Output: out.String()}
+}
+
+// keySHA256 returns the hex SHA-256 of the public key of the certificate
+// with crt.sh's id.
+func (c ctChecker) keySHA256(ctx context.Context, id int64) (string, error) {
+	var sum string
+	err := c.get(ctx, "/?d="+strconv.FormatInt(id, 10), func(r io.Reader) error {
+		b, err := io.ReadAll(io.LimitReader(r, 1<<20))
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return fmt.Errorf("certificate %d: no PEM data", id)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("certificate %d: %v", id, err)
+		}
+		s := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		sum = hex.EncodeToString(s[:])
+		return nil
+	})
+	return sum, err
+}
+
+// get requests path of the server and reads the response with read.
+func (c ctChecker) get(ctx context.Context, path string, read func(io.Reader) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.cfg.Server, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Transport: c.h.transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", req.URL, resp.Status)
+	}
+	return read(resp.Body)
+}
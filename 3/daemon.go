@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// recentLatencies is how many latencies the daemon keeps per health check.
+const recentLatencies = 10
+
+// state is what the daemon remembers about a health check between runs.
+type state struct {
+	checked     bool
+	healthy     bool
+	healthyRuns int // consecutive healthy results
+	runs        int // results since the daemon started, for the Uptime of the status
+	upRuns      int // healthy ones of runs
+	lastCheck   time.Time
+	lastErr     error
+	latencies   []time.Duration // most recent last
+	latency     *latencyWindow  // of the last results, for percentiles
+	skippedFor  string          // ID of the dependency that is down
+	nextRun     time.Time       // of checks with a Schedule
+	drillUntil  time.Time       // failures are injected until then, see timedActions
+	snoozeUntil time.Time       // failures aren't alerted on until then
+	pausedUntil time.Time       // not run until then, see bulk
+
+	consulRegistered bool
+	failingSince     time.Time           // start of the current outage
+	alerted          map[string]notifier // channels with an unresolved incident, see notify
+}
+
+// entry is a health check scheduled by the daemon. The check is never
+// modified, a reload replaces the whole entry but hands over the state, so
+// results of checks in flight during a reload aren't lost.
+type entry struct {
+	check       HealthCheck
+	shadowUntil time.Time // failures aren't alerted on before this time
+	*state
+}
+
+type daemon struct {
+	configFile  string
+	persist     bool   // write API changes to configFile
+	readOnly    bool   // reject API changes
+	apiToken    string // API changes must bear this token, none are accepted if ""
+	apiExec     bool   // accept checks that run commands via the API
+	shadow      time.Duration
+	watch       time.Duration // how often to look for config file changes
+	filter      checkFilter   // checks that don't match aren't run
+	logEvery    int
+	jitter      float64       // fraction of the interval to randomize waits by
+	hist        store         // results are added here if not nil
+	historyFile string        // of hist, for its free space self check
+	coordinator string        // in agent mode, the aggregate server configFile is fetched from
+	remote      *remoteWriter // results are sent here if not nil
+	sinks       []*sink       // results are written to these time-series databases
+	kv          kvStore       // state changes are exported here if not nil
+	audit       *auditor      // state and config changes are audited here if not nil
+	tracer      *tracer       // spans of runs and checks are exported here if not nil
+	phases      phaseMetrics  // of HTTP checks, served on /metrics
+	latencies   int           // of each check the latency percentiles are of
+	kvPrefix    string
+	consul      *consulAgent        // results are pushed to Consul TTL checks if not nil
+	notifiers   map[string]notifier // of checks without a route, see notifications
+	chaos       time.Duration       // how often to verify alert delivery, see runChaos
+
+	mu       sync.Mutex
+	entries  []*entry
+	lastDiff *configDiff
+	lag      time.Duration    // how much longer than the interval the last run took
+	stretch  int              // non-critical checks run every stretch intervals
+	selfErrs map[string]error // last write errors of the dependencies, see report
+
+	discoverers []discoverer             // run by run
+	refresh     time.Duration            // of the discoverers
+	discovered  map[string][]HealthCheck // by discoverer name, see withDiscovered
+}
+
+// maxStretch is the most the daemon stretches the interval of non-critical
+// checks when it falls behind.
+const maxStretch = 16
+
+func newDaemon(configFile string, checks []HealthCheck, logEvery int, hist store) *daemon {
+	d := &daemon{
+		configFile: configFile,
+		logEvery:   max(logEvery, 1),
+		latencies:  defaultLatencyWindow,
+		stretch:    1,
+		hist:       hist,
+	}
+	for _, h := range checks {
+		d.entries = append(d.entries, &entry{check: h, state: &state{}})
+	}
+	return d
+}
+
+// run runs the health checks every interval until ctx is canceled. A check
+// that is running when that happens is finished first.
+func (d *daemon) run(ctx context.Context, interval time.Duration) {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	if d.watch > 0 {
+		go d.watchConfig(reload)
+	}
+	if d.coordinator != "" {
+		go d.pollCoordinator()
+	}
+	if d.chaos > 0 {
+		go d.runChaos(ctx, interval)
+	}
+	go func() {
+		for range reload {
+			if err := d.reload(); err != nil {
+				slog.Error("can't reload config", "err", err)
+			}
+		}
+	}()
+	d.recordStart()
+	for _, src := range d.discoverers {
+		go d.discover(ctx, src, d.refresh)
+	}
+
+	// Don't start in step with other daemons started at the same time.
+	if j := time.Duration(d.jitter * float64(interval)); j > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(rand.N(j)):
+		}
+	}
+	for round := 0; ; round++ {
+		scheduled := time.Now()
+		newHostRound()
+		d.mu.Lock()
+		entries := d.entries
+		order := dependencyOrder(d.currentChecks())
+		nss := namespaces(d.currentChecks())
+		stretch := d.stretch
+		d.mu.Unlock()
+		// Not ctx, which would cancel the checks on shutdown.
+		runCtx, runSpan := d.tracer.start(context.Background(), "run")
+		runSpan.set("run_id", runID(scheduled))
+		// The checks of each config run in parallel with the other configs'.
+		var wg sync.WaitGroup
+		for _, ns := range nss {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for _, i := range order {
+					e := entries[i]
+					if ctx.Err() != nil {
+						return
+					}
+					if e.check.namespace != ns || d.paused(e) || !d.filter.match(e.check) || !d.due(e, time.Now()) {
+						continue
+					}
+					// Spread the non-critical checks over the stretched interval.
+					if (round+i)%stretch != 0 && e.check.Severity != "critical" {
+						continue
+					}
+					if dep := d.downDependency(e); dep != "" {
+						d.skip(e, dep)
+						continue
+					}
+					d.check(runCtx, e, scheduled)
+				}
+			}()
+		}
+		wg.Wait()
+		runSpan.finish(nil)
+		if ctx.Err() != nil {
+			return
+		}
+		elapsed := time.Since(scheduled)
+		d.adjustStretch(elapsed, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.jittered(interval) - elapsed):
+		}
+	}
+}
+
+// adjustStretch measures whether the daemon keeps up with the interval. If
+// running the checks took longer, the interval of non-critical checks is
+// doubled, so critical ones still run on time. Once the checks take less
+// than half the interval, which leaves room for twice as many non-critical
+// ones, the stretch is halved again.
+func (d *daemon) adjustStretch(elapsed, interval time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lag = max(elapsed-interval, 0)
+	switch {
+	case d.lag > 0 && d.stretch < maxStretch:
+		d.stretch *= 2
+		slog.Warn("falling behind, running non-critical checks less often", "lag", d.lag, "every", time.Duration(d.stretch)*interval)
+	case elapsed < interval/2 && d.stretch > 1:
+		d.stretch /= 2
+		if d.stretch == 1 {
+			slog.Info("caught up, running all checks every interval")
+		}
+	}
+}
+
+// jittered returns interval changed randomly by up to ±d.jitter of it.
+func (d *daemon) jittered(interval time.Duration) time.Duration {
+	j := time.Duration(d.jitter * float64(interval))
+	if j <= 0 {
+		return interval
+	}
+	return interval - j + rand.N(2*j+1)
+}
+
+// watchConfig asks for a reload whenever the modification time or size of
+// a config file changes, or files are added to or removed from a config
+// directory.
+func (d *daemon) watchConfig(reload chan<- os.Signal) {
+	stat := func() (string, error) {
+		files, err := configFiles(d.configFile)
+		if err != nil {
+			return "", err
+		}
+		var sig strings.Builder
+		for _, f := range files {
+			fi, err := os.Stat(f)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&sig, "%s %v %d\n", f, fi.ModTime(), fi.Size())
+		}
+		return sig.String(), nil
+	}
+	sig, _ := stat()
+	for range time.Tick(d.watch) {
+		nsig, err := stat()
+		if err != nil {
+			continue // e.g. replaced by an editor right now
+		}
+		if nsig != sig {
+			select {
+			case reload <- syscall.SIGHUP:
+			default: // reload already pending
+			}
+		}
+		sig = nsig
+	}
+}
+
+// reload rereads the config file and replaces the health checks.
+func (d *daemon) reload() error {
+	checks, err := readConfigs(d.configFile)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	checks = d.withDiscovered(checks)
+	diff := d.apply(checks)
+	d.lastDiff = &diff
+	d.mu.Unlock()
+	slog.Info("config reloaded", diffAttrs(diff)...)
+	if d.audit != nil {
+		d.audit.log(auditEvent{Time: diff.Time, Action: "reload", Message: diff.String()})
+	}
+	if !diff.empty() {
+		d.recordChange("reload", "", diff, checks)
+	}
+	return nil
+}
+
+// currentChecks returns the health checks the daemon is running. It must
+// be called with d.mu held.
+func (d *daemon) currentChecks() []HealthCheck {
+	checks := make([]HealthCheck, len(d.entries))
+	for i, e := range d.entries {
+		checks[i] = e.check
+	}
+	return checks
+}
+
+// apply replaces the health checks. Checks that keep their ID keep their
+// state. Added and modified checks run in shadow mode for d.shadow. It
+// must be called with d.mu held.
+func (d *daemon) apply(checks []HealthCheck) configDiff {
+	disambiguate(checks)
+	old := d.currentChecks()
+	oldByID := make(map[string]*entry)
+	for _, e := range d.entries {
+		oldByID[e.check.ID()] = e
+	}
+	shadowUntil := time.Time{}
+	if d.shadow > 0 {
+		shadowUntil = time.Now().Add(d.shadow)
+	}
+	entries := make([]*entry, len(checks))
+	for i, h := range checks {
+		e := &entry{check: h, shadowUntil: shadowUntil, state: &state{}}
+		if o, ok := oldByID[h.ID()]; ok {
+			e.state = o.state
+			if len(changedFields(o.check, h)) == 0 {
+				e.shadowUntil = o.shadowUntil
+			} else {
+				e.nextRun = time.Time{} // the Schedule may have changed
+			}
+		}
+		entries[i] = e
+	}
+	d.entries = entries
+	diff := diffChecks(old, checks)
+	if d.consul != nil {
+		go func() {
+			for _, id := range diff.Removed {
+				if err := d.consul.deregister(id); err != nil {
+					slog.Error("can't deregister Consul check", "name", id, "err", err)
+				}
+			}
+		}()
+	}
+	return diff
+}
+
+// paused reports whether e is paused in the config or for a while.
+func (d *daemon) paused(e *entry) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return e.check.Paused || time.Now().Before(e.pausedUntil)
+}
+
+// due reports whether e should run at now. Checks with a Schedule run
+// once its next time has come.
+func (d *daemon) due(e *entry, now time.Time) bool {
+	sched, _ := e.check.schedule() // validated when read
+	if sched == nil {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e.nextRun.IsZero() {
+		e.nextRun = sched.next(now)
+		return false
+	}
+	if now.Before(e.nextRun) {
+		return false
+	}
+	e.nextRun = sched.next(now)
+	return true
+}
+
+// downDependency returns the ID of a dependency of e that was unhealthy
+// when last checked, or "".
+func (d *daemon) downDependency(e *entry) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return downDependency(e.check, func(id string) (bool, bool) {
+		for _, o := range d.entries {
+			if o.check.ID() == id {
+				s := o.state
+				skipped := s.skippedFor != ""
+				return s.healthy && !skipped, (s.checked || skipped) && !o.check.Paused && !time.Now().Before(s.pausedUntil)
+			}
+		}
+		return false, false
+	})
+}
+
+// skip marks e as skipped because its dependency dep is down.
+func (d *daemon) skip(e *entry, dep string) {
+	d.mu.Lock()
+	changed := e.skippedFor != dep
+	e.skippedFor = dep
+	d.mu.Unlock()
+	if changed {
+		slog.Info("skipped, dependency is down", append(checkAttrs(e.check), "dependency", dep)...)
+	}
+}
+
+// check runs e, which was scheduled to run at the given time. Its span is
+// a child of the span of ctx.
+func (d *daemon) check(ctx context.Context, e *entry, scheduled time.Time) {
+	h := e.check
+	id := resultID(h.ID(), scheduled)
+	run := runID(scheduled)
+	start := time.Now()
+	ctx, sp := startSpan(ctx, "check")
+	sp.set("healthcheck.id", h.ID())
+	sp.set("healthcheck.type", h.checkType())
+	sp.set("result_id", id)
+	d.mu.Lock()
+	drillUntil := e.drillUntil
+	d.mu.Unlock()
+	drill := start.Before(drillUntil)
+	var r Result
+	if drill {
+		r.Err = fmt.Errorf("DRILL: injected failure until %s, not a real outage", drillUntil.Format(time.TimeOnly))
+	} else {
+		r = h.do(ctx)
+	}
+	ok, err := r.Healthy, r.Err
+	sp.set("healthcheck.healthy", ok)
+	sp.set("healthcheck.drill", drill)
+	sp.finish(err)
+	latency := time.Since(start)
+	maint, inMaintenance := h.maintenance(start)
+
+	rec := record{ResultID: id, RunID: run, Time: start, Name: h.Name, URL: h.URL, Healthy: ok, Latency: latency, Maintenance: inMaintenance, Drill: drill, Timing: r.Timing}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if d.hist != nil {
+		err := d.hist.add(rec)
+		if err != nil {
+			slog.Error("can't write history", "err", err)
+		}
+		d.report("history", err)
+	}
+	if d.remote != nil {
+		d.remote.add(rec)
+	}
+	for _, s := range d.sinks {
+		s.add(rec)
+	}
+	d.phases.observe(h.ID(), r.Timing)
+
+	d.mu.Lock()
+	snoozed, snoozeExpired := false, false
+	if !e.snoozeUntil.IsZero() {
+		if start.Before(e.snoozeUntil) {
+			snoozed = true
+		} else {
+			e.snoozeUntil = time.Time{}
+			snoozeExpired = true
+		}
+	}
+	shadow, promoted := false, false
+	if !e.shadowUntil.IsZero() {
+		if start.Before(e.shadowUntil) {
+			shadow = true
+		} else {
+			e.shadowUntil = time.Time{}
+			promoted = true
+		}
+	}
+	s := e.state
+	first := !s.checked
+	transition := first || s.healthy != ok
+	s.checked, s.healthy = true, ok
+	s.skippedFor = ""
+	s.lastCheck, s.lastErr = start, err
+	s.latencies = append(s.latencies, latency)
+	if len(s.latencies) > recentLatencies {
+		s.latencies = s.latencies[1:]
+	}
+	if s.latency == nil {
+		s.latency = newLatencyWindow(d.latencies)
+	}
+	s.latency.add(latency)
+	s.runs++
+	if ok {
+		s.upRuns++
+		s.healthyRuns++
+		s.failingSince = time.Time{}
+	} else {
+		s.healthyRuns = 0
+		if s.failingSince.IsZero() {
+			s.failingSince = start
+		}
+	}
+	healthyRuns := s.healthyRuns
+	d.mu.Unlock()
+
+	if transition && d.kv != nil {
+		d.exportState(h, id, ok, start, err)
+	}
+	if transition && d.audit != nil && !(first && ok) {
+		event := auditEvent{Time: start, Action: "healthy", Check: h.ID()}
+		if !ok {
+			event.Action, event.Message = "unhealthy", fmt.Sprint(err)
+		}
+		d.audit.log(event)
+	}
+	if d.consul != nil {
+		d.reportToConsul(e, ok, err)
+	}
+	attrs := append(checkAttrs(h), "run_id", run, "result_id", id, "duration", latency)
+	if drill {
+		attrs = append(attrs, "drill", true)
+	}
+	if promoted {
+		slog.Info("promoted from shadow mode", checkAttrs(h)...)
+	}
+	if snoozeExpired {
+		if ok {
+			slog.Info("snooze expired", checkAttrs(h)...)
+		} else {
+			// Remind even if the state didn't change.
+			slog.Error("still unhealthy after snooze", append(attrs, "err", err)...)
+			if d.audit != nil {
+				d.audit.log(auditEvent{Time: start, Action: "reminder", Check: h.ID(), Message: fmt.Sprint(err)})
+			}
+		}
+	}
+	if !ok && snoozed {
+		slog.Info("unhealthy while snoozed", append(attrs, "err", err)...)
+		return
+	}
+	if !ok && shadow {
+		slog.Info("unhealthy in shadow mode", append(attrs, "err", err)...)
+		return
+	}
+	if !ok && inMaintenance {
+		slog.Info("unhealthy during maintenance", append(attrs, "reason", maint.Reason, "err", err)...)
+		return
+	}
+	d.notify(e, ok, err)
+	if r.Output != "" {
+		attrs = append(attrs, "output", r.Output)
+	}
+	if len(r.Redirects) > 0 {
+		attrs = append(attrs, "redirects", r.Redirects)
+	}
+	if r.Protocol != "" {
+		attrs = append(attrs, "protocol", r.Protocol)
+	}
+	if r.Source != "" {
+		attrs = append(attrs, "source", r.Source)
+	}
+	if r.Interface != "" {
+		attrs = append(attrs, "interface", r.Interface)
+	}
+	if r.Family != "" {
+		attrs = append(attrs, "family", r.Family)
+	}
+	if r.Timing != nil {
+		attrs = append(attrs, "timing", *r.Timing)
+	}
+	if !ok {
+		slog.Error("unhealthy", append(attrs, "err", err)...)
+		return
+	}
+	// Failures and transitions are always logged, steady healthy
+	// results only every logEvery times.
+	if transition || healthyRuns%d.logEvery == 0 {
+		slog.Info("healthy", attrs...)
+	} else {
+		slog.Debug("healthy", attrs...)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// dbChecker connects to a database whose DSN is the URL, e.g.
+// postgres://user:password@db:5432/app?sslmode=require, and runs SELECT 1,
+// or PING for Redis. The Output tells how long connecting and the query
+// took. The password is best put in the config as ${VAR}.
+type dbChecker struct {
+	h    HealthCheck
+	url  *url.URL
+	addr string
+	// ping logs in over conn and runs the query. It returns how long
+	// logging in took.
+	ping func(conn net.Conn) (time.Duration, error)
+}
+
+// dbPorts are the default ports of the database URL schemes.
+var dbPorts = map[string]string{
+	"postgres": "5432", "postgresql": "5432",
+	"mysql": "3306",
+	"redis": "6379", "rediss": "6379",
+}
+
+func init() {
+	for scheme := range dbPorts {
+		registerChecker(scheme, newDBChecker)
+	}
+}
+
+func newDBChecker(h HealthCheck) (Checker, error) {
+	u, err := parseURL(h, "postgres", "postgresql", "mysql", "redis", "rediss")
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = dbPorts[u.Scheme]
+	}
+	c := &dbChecker{h: h, url: u, addr: net.JoinHostPort(u.Hostname(), port)}
+	switch u.Scheme {
+	case "postgres", "postgresql":
+		switch mode := u.Query().Get("sslmode"); mode {
+		case "", "disable", "prefer", "require", "verify-full":
+		default:
+			return nil, fmt.Errorf("sslmode must be disable, prefer, require or verify-full, not %q", mode)
+		}
+		c.ping = c.pingPostgres
+	case "mysql":
+		switch t := u.Query().Get("tls"); t {
+		case "", "false", "true", "skip-verify":
+		default:
+			return nil, fmt.Errorf("tls must be true, false or skip-verify, not %q", t)
+		}
+		c.ping = c.pingMySQL
+	default:
+		c.ping = c.pingRedis
+	}
+	return c, nil
+}
+
+func (c *dbChecker) Check(ctx context.Context) Result {
+	start := time.Now()
+	conn, err := c.h.dialContext(ctx, c.addr)
+	if err != nil {
+		return Result{Err: err}
+	}
+	defer func() { conn.Close() }()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	dialed := time.Since(start)
+
+	if c.url.Scheme == "rediss" {
+		tc := tls.Client(conn, c.h.tlsConfig(c.url.Hostname()))
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return Result{Err: err}
+		}
+		conn = tc
+	}
+	login, err := c.ping(conn)
+	if err != nil {
+		if ctx.Err() != nil && errors.Is(err, net.ErrClosed) {
+			err = fmt.Errorf("no answer: %v", ctx.Err())
+		}
+		return Result{Err: err}
+	}
+	total := time.Since(start)
+	out := fmt.Sprintf("connected in %v, logged in in %v, query took %v", dialed.Round(time.Microsecond), login.Round(time.Microsecond), (total - dialed - login).Round(time.Microsecond))
+	return Result{Healthy: true, Output: out}
+}
+
+// upgradeTLS starts TLS on conn, which the server agreed to, for the TLS
+// modes of the DSNs: verify checks the certificate.
+func (c *dbChecker) upgradeTLS(conn net.Conn, verify bool) (*tls.Conn, error) {
+	cfg := c.h.tlsConfig(c.url.Hostname())
+	if !verify {
+		cfg.InsecureSkipVerify = true
+	}
+	tc := tls.Client(conn, cfg)
+	if err := tc.Handshake(); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
+
+// pingRedis authenticates with the password of the URL, selects the
+// database of its path and sends PING.
+func (c *dbChecker) pingRedis(conn net.Conn) (time.Duration, error) {
+	start := time.Now()
+	br := bufio.NewReader(conn)
+	command := func(args ...string) (string, error) {
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(args))
+		for _, a := range args {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+		}
+		if _, err := conn.Write([]byte(b.String())); err != nil {
+			return "", err
+		}
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "-") {
+			return "", fmt.Errorf("%s: %s", args[0], line[1:])
+		}
+		return line, nil
+	}
+	if password, ok := c.url.User.Password(); ok {
+		args := []string{"AUTH", password}
+		if user := c.url.User.Username(); user != "" {
+			args = []string{"AUTH", user, password}
+		}
+		if _, err := command(args...); err != nil {
+			return 0, err
+		}
+	}
+	if db := strings.Trim(c.url.Path, "/"); db != "" {
+		if _, err := command("SELECT", db); err != nil {
+			return 0, err
+		}
+	}
+	login := time.Since(start)
+	reply, err := command("PING")
+	if err != nil {
+		return login, err
+	}
+	if reply != "+PONG" {
+		return login, fmt.Errorf("PING: got %q, want +PONG", reply)
+	}
+	return login, nil
+}
+
+// dbError is an error reported by a database server.
+type dbError struct {
+	Code, Message string
+}
+
+func (e *dbError) Error() string {
+	return e.Message + " (" + e.Code + ")"
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// downDependency returns the ID of the first dependency of h that is down
+// according to healthy, or "" if there's none. Dependencies whose state
+// isn't known don't count as down, skipped ones do.
+func downDependency(h HealthCheck, healthy func(id string) (ok, known bool)) string {
+	for _, id := range h.DependsOn {
+		if ok, known := healthy(id); known && !ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// runner runs health checks once, each after its dependencies. Checks
+// with a dependency that is down are skipped.
+type runner struct {
+	byID    map[string]HealthCheck
+	results map[string]runResult
+	retries int // of failing checks, a retryDelay apart
+}
+
+// retryDelay is how long a runner waits before running a failing check
+// again.
+const retryDelay = time.Second
+
+type runResult struct {
+	Result
+	skippedFor string        // ID of the dependency that is down
+	latency    time.Duration // of running the check
+}
+
+func newRunner(checks []HealthCheck) *runner {
+	r := &runner{byID: make(map[string]HealthCheck), results: make(map[string]runResult)}
+	for _, h := range checks {
+		r.byID[h.ID()] = h
+	}
+	return r
+}
+
+func (r *runner) run(h HealthCheck) runResult {
+	if res, ok := r.results[h.ID()]; ok {
+		return res
+	}
+	r.results[h.ID()] = runResult{} // a cycle doesn't recurse forever
+	var res runResult
+	res.skippedFor = downDependency(h, func(id string) (bool, bool) {
+		dep, ok := r.byID[id]
+		if !ok || dep.Paused {
+			return false, false
+		}
+		res := r.run(dep)
+		return res.Healthy, true
+	})
+	for try := 0; res.skippedFor == ""; try++ {
+		start := time.Now()
+		res.Result = h.Do()
+		res.latency = time.Since(start)
+		if res.Healthy || res.ConfigErr || try >= r.retries {
+			break
+		}
+		slog.Debug("retrying", append(checkAttrs(h), "err", res.Err)...)
+		time.Sleep(retryDelay)
+	}
+	r.results[h.ID()] = res
+	return res
+}
+
+// dependencyOrder returns the indexes of hs ordered so that checks come
+// after their dependencies and otherwise in config order.
+func dependencyOrder(hs []HealthCheck) []int {
+	index := make(map[string]int)
+	for i, h := range hs {
+		index[h.ID()] = i
+	}
+	order := make([]int, 0, len(hs))
+	seen := make([]bool, len(hs))
+	var visit func(i int)
+	visit = func(i int) {
+		if seen[i] {
+			return
+		}
+		seen[i] = true
+		for _, id := range hs[i].DependsOn {
+			if j, ok := index[id]; ok {
+				visit(j)
+			}
+		}
+		order = append(order, i)
+	}
+	for i := range hs {
+		visit(i)
+	}
+	return order
+}
+
+// dependencyProblems returns the problems with the DependsOn fields.
+func dependencyProblems(hs []HealthCheck) []problem {
+	index := make(map[string]int)
+	for i, h := range hs {
+		index[h.ID()] = i
+	}
+	var problems []problem
+	for i, h := range hs {
+		for _, id := range h.DependsOn {
+			switch j, ok := index[id]; {
+			case !ok:
+				problems = append(problems, problem{Check: i, Msg: fmt.Sprintf("DependsOn: no check %q", id)})
+			case j == i:
+				problems = append(problems, problem{Check: i, Msg: "DependsOn: check depends on itself"})
+			}
+		}
+	}
+
+	// Report each cycle once, at the check where the search found it.
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	marks := make([]int, len(hs))
+	var visit func(i int)
+	visit = func(i int) {
+		marks[i] = visiting
+		for _, id := range hs[i].DependsOn {
+			j, ok := index[id]
+			if !ok || j == i {
+				continue
+			}
+			switch marks[j] {
+			case unvisited:
+				visit(j)
+			case visiting:
+				problems = append(problems, problem{Check: i, Msg: fmt.Sprintf("DependsOn: cycle through %q", id)})
+			}
+		}
+		marks[i] = done
+	}
+	for i := range hs {
+		if marks[i] == unvisited {
+			visit(i)
+		}
+	}
+	return problems
+}
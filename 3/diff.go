@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// configDiff describes what changed between two configurations. Checks
+// are matched by ID.
+type configDiff struct {
+	Time     time.Time
+	Added    []string
+	Removed  []string
+	Modified []checkChange
+}
+
+type checkChange struct {
+	ID     string
+	Fields []string
+}
+
+func diffChecks(old, new []HealthCheck) configDiff {
+	diff := configDiff{Time: time.Now()}
+	oldByID := make(map[string]HealthCheck)
+	for _, h := range old {
+		oldByID[h.ID()] = h
+	}
+	newByID := make(map[string]bool)
+	for _, h := range new {
+		newByID[h.ID()] = true
+		o, ok := oldByID[h.ID()]
+		if !ok {
+			diff.Added = append(diff.Added, h.ID())
+			continue
+		}
+		if fields := changedFields(o, h); len(fields) > 0 {
+			diff.Modified = append(diff.Modified, checkChange{ID: h.ID(), Fields: fields})
+		}
+	}
+	for _, h := range old {
+		if !newByID[h.ID()] {
+			diff.Removed = append(diff.Removed, h.ID())
+		}
+	}
+	return diff
+}
+
+// changedFields returns the names of the exported fields that differ
+// between a and b.
+func changedFields(a, b HealthCheck) []string {
+	var fields []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := range va.NumField() {
+		if !va.Type().Field(i).IsExported() {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			fields = append(fields, va.Type().Field(i).Name)
+		}
+	}
+	return fields
+}
+
+// diffAttrs returns the changes as log attributes.
+func diffAttrs(d configDiff) []any {
+	modified := make([]string, len(d.Modified))
+	for i, c := range d.Modified {
+		modified[i] = fmt.Sprintf("%s (%s)", c.ID, strings.Join(c.Fields, ", "))
+	}
+	return []any{"added", d.Added, "removed", d.Removed, "modified", modified}
+}
+
+func (d configDiff) empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Modified) == 0
+}
+
+func (d configDiff) String() string {
+	var parts []string
+	for _, id := range d.Added {
+		parts = append(parts, "+"+id)
+	}
+	for _, id := range d.Removed {
+		parts = append(parts, "-"+id)
+	}
+	for _, c := range d.Modified {
+		parts = append(parts, fmt.Sprintf("~%s (%s)", c.ID, strings.Join(c.Fields, ", ")))
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+)
+
+// discoverer generates health checks of the services it finds, e.g. in
+// Kubernetes. The checks it returns must have their discovered field set
+// to its name.
+type discoverer interface {
+	name() string
+	discover(ctx context.Context) ([]HealthCheck, error)
+}
+
+// discover runs the checks src finds next to the config's, looking for
+// changes every refresh until ctx is done. Checks of the config win over
+// discovered ones with the same ID.
+func (d *daemon) discover(ctx context.Context, src discoverer, refresh time.Duration) {
+	for {
+		found, err := src.discover(ctx)
+		d.report(src.name(), err)
+		if err != nil {
+			slog.Error("can't discover checks", "source", src.name(), "err", err)
+		} else {
+			d.mu.Lock()
+			if d.discovered == nil {
+				d.discovered = make(map[string][]HealthCheck)
+			}
+			d.discovered[src.name()] = found
+			configured := slices.DeleteFunc(d.currentChecks(), func(h HealthCheck) bool { return h.discovered != "" })
+			checks := d.withDiscovered(configured)
+			diff := d.apply(checks)
+			d.mu.Unlock()
+			if !diff.empty() {
+				slog.Info("checks discovered", append([]any{"source", src.name()}, diffAttrs(diff)...)...)
+				if d.audit != nil {
+					d.audit.log(auditEvent{Time: diff.Time, Action: "discovery", Actor: src.name(), Message: diff.String()})
+				}
+				d.recordChange("discovery", src.name(), diff, checks)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(refresh):
+		}
+	}
+}
+
+// withDiscovered returns checks followed by the discovered checks whose
+// ID isn't taken. It must be called with d.mu held.
+func (d *daemon) withDiscovered(checks []HealthCheck) []HealthCheck {
+	ids := make(map[string]bool, len(checks))
+	for _, h := range checks {
+		ids[h.ID()] = true
+	}
+	for _, name := range slices.Sorted(maps.Keys(d.discovered)) {
+		for _, h := range d.discovered[name] {
+			if !ids[h.ID()] {
+				ids[h.ID()] = true
+				checks = append(checks, h)
+			}
+		}
+	}
+	return checks
+}
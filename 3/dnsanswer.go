@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsAnswerConfig configures a dns-answer check.
+type dnsAnswerConfig struct {
+	Types     []string `json:",omitempty"` // record types to query: A, AAAA, CNAME, MX, NS or TXT, default A and AAAA
+	Expected  []string `json:",omitempty"` // the records the answers must have, e.g. "A 192.0.2.1", empty means the ones seen last
+	IgnoreTTL bool     `json:",omitempty"` // don't alert on changed TTLs
+}
+
+// dnsTypes are the record types dns-answer checks understand.
+var dnsTypes = map[string]uint16{"A": 1, "NS": 2, "CNAME": 5, "MX": 15, "TXT": 16, "AAAA": 28}
+
+// dnsAnswerChecker queries a name, e.g. dns://www.example.com, with
+// DNSServer or the system's name server and is unhealthy if the answers
+// changed: records were added or dropped, or their TTL changed. Without
+// Expected records a change is reported once, the new answers are
+// compared with from then on. The Output lists the answers, so a history
+// of them is kept with -history.
+//
+// Resolvers count TTLs down while they cache records, so TTLs are
+// compared exactly only in authoritative answers, else only raised TTLs
+// are noticed. Query the name's authoritative server to catch all.
+type dnsAnswerChecker struct {
+	key       string // of the answers seen last, see dnsAnswers
+	host      string
+	server    string // host:port, empty means the system's
+	network   string // udp, udp4 or udp6
+	types     []string
+	expected  map[string]bool
+	ignoreTTL bool
+}
+
+func init() {
+	registerChecker("dns-answer", newDNSAnswerChecker)
+}
+
+func newDNSAnswerChecker(h HealthCheck) (Checker, error) {
+	u, err := parseURL(h, "dns")
+	if err != nil {
+		return nil, err
+	}
+	ipNet, err := h.ipNetwork()
+	if err != nil {
+		return nil, err
+	}
+	c := dnsAnswerChecker{key: h.ID() + "\x00" + h.URL, host: strings.TrimSuffix(u.Hostname(), "."), network: "udp" + ipNet[len("ip"):], types: []string{"A", "AAAA"}}
+	if h.DNSServer != "" {
+		c.server = h.DNSServer
+		if _, _, err := net.SplitHostPort(c.server); err != nil {
+			c.server = net.JoinHostPort(c.server, "53")
+		}
+	}
+	if cfg := h.DNSAnswer; cfg != nil {
+		if len(cfg.Types) > 0 {
+			c.types = nil
+			for _, t := range cfg.Types {
+				t = strings.ToUpper(t)
+				if _, ok := dnsTypes[t]; !ok {
+					return nil, fmt.Errorf("DNSAnswer.Types: unknown record type %q", t)
+				}
+				c.types = append(c.types, t)
+			}
+		}
+		for _, r := range cfg.Expected {
+			// TYPE data, or owner TYPE data for records of other names,
+			// e.g. the target of a CNAME.
+			fields := strings.Fields(r)
+			owner := ""
+			if len(fields) >= 3 && dnsTypes[strings.ToUpper(fields[0])] == 0 {
+				owner, fields = strings.TrimSuffix(fields[0], ".")+" ", fields[1:]
+			}
+			if len(fields) < 2 || dnsTypes[strings.ToUpper(fields[0])] == 0 {
+				return nil, fmt.Errorf("DNSAnswer.Expected: want [owner] TYPE data, not %q", r)
+			}
+			if c.expected == nil {
+				c.expected = make(map[string]bool)
+			}
+			c.expected[owner+strings.ToUpper(fields[0])+" "+strings.Join(fields[1:], " ")] = true
+		}
+		c.ignoreTTL = cfg.IgnoreTTL
+	}
+	return c, nil
+}
+
+// dnsAnswers are the answers dns-answer checks got last, by check, as
+// checkers are made for every run.
+var dnsAnswers = struct {
+	sync.Mutex
+	m map[string]map[string]uint32 // TTLs by record
+}{m: make(map[string]map[string]uint32)}
+
+func (c dnsAnswerChecker) Check(ctx context.Context) Result {
+	server := c.server
+	if server == "" {
+		var err error
+		if server, err = systemNameServer(); err != nil {
+			return Result{Err: err}
+		}
+	}
+	answers := make(map[string]uint32)
+	authoritative := true
+	for _, typ := range c.types {
+		rrs, aa, err := dnsQuery(ctx, c.network, server, c.host, dnsTypes[typ])
+		if err != nil {
+			return Result{Err: fmt.Errorf("%s %s: %v", c.host, typ, err)}
+		}
+		authoritative = authoritative && aa
+		for _, rr := range rrs {
+			r := rr.String()
+			if !strings.EqualFold(rr.name, c.host) {
+				r = rr.name + " " + r // e.g. the target of a CNAME
+			}
+			answers[r] = rr.ttl
+		}
+	}
+	var out strings.Builder
+	for _, r := range slices.Sorted(maps.Keys(answers)) {
+		fmt.Fprintf(&out, "%s ttl=%d\n", r, answers[r])
+	}
+
+	dnsAnswers.Lock()
+	last, seen := dnsAnswers.m[c.key]
+	dnsAnswers.m[c.key] = answers
+	dnsAnswers.Unlock()
+
+	var changes []string
+	want := c.expected
+	if want == nil && seen {
+		want = make(map[string]bool, len(last))
+		for r := range last {
+			want[r] = true
+		}
+	}
+	for _, r := range slices.Sorted(maps.Keys(answers)) {
+		if want != nil && !want[r] {
+			changes = append(changes, "new "+r)
+		}
+	}
+	for _, r := range slices.Sorted(maps.Keys(want)) {
+		if _, ok := answers[r]; !ok {
+			changes = append(changes, "dropped "+r)
+		}
+	}
+	if !c.ignoreTTL {
+		for _, r := range slices.Sorted(maps.Keys(answers)) {
+			was, ok := last[r]
+			if ok && (answers[r] > was || authoritative && answers[r] != was) {
+				changes = append(changes, fmt.Sprintf("TTL of %s from %d to %d", r, was, answers[r]))
+			}
+		}
+	}
+	if len(changes) > 0 {
+		return Result{Err: fmt.Errorf("answers for %s changed: %s", c.host, strings.Join(changes, ", ")), Output: out.String()}
+	}
+	if len(answers) == 0 {
+		return Result{Err: fmt.Errorf("no %v records for %s", c.types, c.host), Output: out.String()}
+	}
+	return Result{Healthy: true, Output: out.String()}
+}
+
+// systemNameServer returns the first name server of /etc/resolv.conf.
+func systemNameServer() (string, error) {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "", fmt.Errorf("no name server, set DNSServer: %v", err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if fields := strings.Fields(sc.Text()); len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	return "", errors.New("no name server in /etc/resolv.conf, set DNSServer")
+}
+
+// dnsRR is a resource record of an answer.
+type dnsRR struct {
+	name string
+	typ  uint16
+	ttl  uint32
+	data string // presentation format, e.g. 10 mail.example.com for MX
+}
+
+func (rr dnsRR) String() string {
+	for name, t := range dnsTypes {
+		if t == rr.typ {
+			return name + " " + rr.data
+		}
+	}
+	return fmt.Sprintf("TYPE%d %s", rr.typ, rr.data)
+}
+
+// dnsQuery asks server for the records of type typ of name, over UDP
+// and, if the answer is truncated, over TCP. It returns the records of the
+// answer section and whether the answer is authoritative. A name that
+// doesn't exist has no records.
+func dnsQuery(ctx context.Context, network, server, name string, typ uint16) ([]dnsRR, bool, error) {
+	id := uint16(rand.Uint32())
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = append(msg, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0) // recursion desired, one question
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, false, fmt.Errorf("invalid name %q", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, typ)
+	msg = binary.BigEndian.AppendUint16(msg, 1) // IN
+
+	resp, err := dnsExchange(ctx, network, server, msg)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(resp) >= 3 && resp[2]&0x02 != 0 { // truncated
+		resp, err = dnsExchange(ctx, "tcp"+network[len("udp"):], server, msg)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if len(resp) < 12 || binary.BigEndian.Uint16(resp) != id {
+		return nil, false, errors.New("malformed answer")
+	}
+	aa := resp[2]&0x04 != 0
+	switch rcode := resp[3] & 0x0f; rcode {
+	case 0:
+	case 3:
+		return nil, aa, nil // NXDOMAIN
+	default:
+		return nil, false, fmt.Errorf("server answered with %s", map[byte]string{1: "FORMERR", 2: "SERVFAIL", 4: "NOTIMP", 5: "REFUSED"}[rcode])
+	}
+	qd, an := binary.BigEndian.Uint16(resp[4:]), binary.BigEndian.Uint16(resp[6:])
+	off := 12
+	for range qd {
+		if _, off, err = dnsName(resp, off); err != nil {
+			return nil, false, err
+		}
+		off += 4
+	}
+	var rrs []dnsRR
+	for range an {
+		var rr dnsRR
+		if rr.name, off, err = dnsName(resp, off); err != nil {
+			return nil, false, err
+		}
+		if off+10 > len(resp) {
+			return nil, false, errors.New("malformed answer")
+		}
+		rr.typ = binary.BigEndian.Uint16(resp[off:])
+		rr.ttl = binary.BigEndian.Uint32(resp[off+4:])
+		n := int(binary.BigEndian.Uint16(resp[off+8:]))
+		off += 10
+		if off+n > len(resp) {
+			return nil, false, errors.New("malformed answer")
+		}
+		if rr.data, err = dnsRData(resp, off, n, rr.typ); err != nil {
+			return nil, false, err
+		}
+		off += n
+		rrs = append(rrs, rr)
+	}
+	return rrs, aa, nil
+}
+
+// dnsExchange sends msg to server and returns the answer.
+func dnsExchange(ctx context.Context, network, server string, msg []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	recordSource(ctx, conn)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(defaultResponseTimeout))
+	}
+	if strings.HasPrefix(network, "tcp") {
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...)); err != nil {
+			return nil, err
+		}
+		var n uint16
+		if err := binary.Read(conn, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		resp := make([]byte, n)
+		_, err := io.ReadFull(conn, resp)
+		return resp, err
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, 65535)
+	n, err := conn.Read(resp)
+	return resp[:n], err
+}
+
+// dnsName reads the possibly compressed name at off of msg. It returns
+// the name without the trailing dot and the offset after it.
+func dnsName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("malformed name")
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errors.New("malformed name")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errors.New("malformed name")
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// dnsRData returns the n bytes of record data at off of msg in
+// presentation format.
+func dnsRData(msg []byte, off, n int, typ uint16) (string, error) {
+	data := msg[off : off+n]
+	switch typ {
+	case dnsTypes["A"], dnsTypes["AAAA"]:
+		ip, ok := netip.AddrFromSlice(data)
+		if !ok {
+			return "", errors.New("malformed address record")
+		}
+		return ip.String(), nil
+	case dnsTypes["CNAME"], dnsTypes["NS"]:
+		name, _, err := dnsName(msg, off)
+		return name, err
+	case dnsTypes["MX"]:
+		if n < 3 {
+			return "", errors.New("malformed MX record")
+		}
+		name, _, err := dnsName(msg, off+2)
+		return fmt.Sprintf("%d %s", binary.BigEndian.Uint16(data), name), err
+	case dnsTypes["TXT"]:
+		var strs []string
+		for len(data) > 0 {
+			l := int(data[0])
+			if 1+l > len(data) {
+				return "", errors.New("malformed TXT record")
+			}
+			strs = append(strs, fmt.Sprintf("%q", data[1:1+l]))
+			data = data[1+l:]
+		}
+		return strings.Join(strs, " "), nil
+	}
+	return fmt.Sprintf("%x", data), nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Labels of containers that change their checks.
+const (
+	dockerPathLabel = "healthcheck.path" // check the port over HTTP at this path rather than over TCP
+	dockerPortLabel = "healthcheck.port" // container port to check, default all published ones
+	dockerExecLabel = "healthcheck.exec" // shell command to run in the container instead of checking ports
+)
+
+// dockerClient talks to the Docker Engine API.
+type dockerClient struct {
+	base   string // e.g. http://docker for the socket
+	client *http.Client
+}
+
+// newDockerClient returns a client of the Docker daemon at host, e.g.
+// unix:///var/run/docker.sock or tcp://127.0.0.1:2375, defaulting to
+// DOCKER_HOST and then the local socket.
+func newDockerClient(host string) (*dockerClient, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = "unix:///var/run/docker.sock"
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "unix":
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.Proxy = nil
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", u.Path)
+		}
+		return &dockerClient{base: "http://docker", client: &http.Client{Transport: t}}, nil
+	case "tcp", "http":
+		return &dockerClient{base: "http://" + u.Host, client: &http.Client{}}, nil
+	}
+	return nil, fmt.Errorf("unsupported Docker host %q, want unix:// or tcp://", host)
+}
+
+// do sends a request with a JSON body, if in isn't nil, and decodes the
+// JSON response into out, if it isn't nil.
+func (c *dockerClient) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var e struct{ Message string }
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&e)
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, e.Message)
+	}
+	if out == nil {
+		_, err := io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// dockerDiscovery generates checks of the running containers with a
+// label: TCP checks of their published ports, HTTP checks if they have a
+// healthcheck.path label, or exec checks in the container if they have a
+// healthcheck.exec label.
+type dockerDiscovery struct {
+	docker *dockerClient
+	label  string // key or key=value, e.g. com.docker.compose.project=shop
+}
+
+func (d *dockerDiscovery) name() string { return "docker" }
+
+func (d *dockerDiscovery) discover(ctx context.Context) ([]HealthCheck, error) {
+	filters, _ := json.Marshal(map[string][]string{"label": {d.label}, "status": {"running"}})
+	var containers []struct {
+		ID     string `json:"Id"`
+		Names  []string
+		Labels map[string]string
+		Ports  []struct {
+			IP          string
+			PrivatePort int
+			PublicPort  int
+			Type        string
+		}
+	}
+	if err := d.docker.do(ctx, http.MethodGet, "/containers/json?filters="+url.QueryEscape(string(filters)), nil, &containers); err != nil {
+		return nil, err
+	}
+	var out []HealthCheck
+	for _, c := range containers {
+		name := c.ID[:min(12, len(c.ID))]
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		check := HealthCheck{
+			Name:       "docker/" + name,
+			Tags:       []string{"docker"},
+			discovered: d.name(),
+		}
+		if project := c.Labels["com.docker.compose.project"]; project != "" {
+			check.Tags = append(check.Tags, project)
+		}
+		if cmd := c.Labels[dockerExecLabel]; cmd != "" {
+			check.URL = "docker://" + c.ID
+			check.Exec = &execConfig{Command: "sh", Args: []string{"-c", cmd}}
+			out = append(out, check)
+			continue
+		}
+		seen := make(map[int]bool) // ports are listed per host address
+		for _, p := range c.Ports {
+			if p.PublicPort == 0 || p.Type != "tcp" || seen[p.PrivatePort] {
+				continue
+			}
+			if want := c.Labels[dockerPortLabel]; want != "" && want != strconv.Itoa(p.PrivatePort) {
+				continue
+			}
+			seen[p.PrivatePort] = true
+			host := p.IP
+			if host == "" || host == "0.0.0.0" || host == "::" {
+				host = "localhost"
+			}
+			addr := net.JoinHostPort(host, strconv.Itoa(p.PublicPort))
+			h := check
+			h.Name += "/" + strconv.Itoa(p.PrivatePort)
+			h.URL = "tcp://" + addr
+			if path, ok := c.Labels[dockerPathLabel]; ok {
+				if !strings.HasPrefix(path, "/") {
+					path = "/" + path
+				}
+				h.URL = "http://" + addr + path
+				h.HealthyStatusCode = http.StatusOK
+			}
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
+
+// dockerHost is the Docker daemon docker checks use, see -docker-host.
+var dockerHost string
+
+// dockerExecChecker runs the command of the Exec field in a container,
+// e.g. docker://shop-db-1, and is healthy if it exits with status 0.
+type dockerExecChecker struct {
+	container string
+	cfg       execConfig
+	docker    *dockerClient
+}
+
+func init() {
+	registerChecker("docker", newDockerExecChecker)
+}
+
+func newDockerExecChecker(h HealthCheck) (Checker, error) {
+	if execDisabled != "" {
+		return nil, fmt.Errorf("docker checks are disabled by %s", execDisabled)
+	}
+	u, err := parseURL(h, "docker")
+	if err != nil {
+		return nil, err
+	}
+	if h.Exec == nil || h.Exec.Command == "" {
+		return nil, errors.New("missing Exec.Command")
+	}
+	if h.Exec.Dir != "" && !strings.HasPrefix(h.Exec.Dir, "/") {
+		return nil, fmt.Errorf("Exec.Dir %q must be absolute in the container", h.Exec.Dir)
+	}
+	docker, err := newDockerClient(dockerHost)
+	if err != nil {
+		return nil, err
+	}
+	return dockerExecChecker{container: u.Host, cfg: *h.Exec, docker: docker}, nil
+}
+
+func (c dockerExecChecker) Check(ctx context.Context) Result {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := c.docker.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(c.container)+"/exec", map[string]any{
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Cmd":          append([]string{c.cfg.Command}, c.cfg.Args...),
+		"Env":          c.cfg.Env,
+		"WorkingDir":   c.cfg.Dir,
+	}, &created)
+	if err != nil {
+		return Result{Err: err}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.docker.base+"/exec/"+created.ID+"/start", strings.NewReader(`{"Detach":false,"Tty":false}`))
+	if err != nil {
+		return Result{Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.docker.client.Do(req)
+	if err != nil {
+		return Result{Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return Result{Err: fmt.Errorf("POST /exec/%s/start: %s", created.ID, resp.Status)}
+	}
+	// The output of the command, multiplexed with 8 byte frame headers,
+	// until it exits.
+	var out bytes.Buffer
+	for {
+		var header [8]byte
+		if _, err = io.ReadFull(resp.Body, header[:]); err != nil {
+			break
+		}
+		if _, err = io.CopyN(&out, resp.Body, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			break
+		}
+	}
+	resp.Body.Close()
+	output := out.String()
+	if len(output) > maxOutput {
+		output = output[len(output)-maxOutput:]
+	}
+	if err != io.EOF {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("%v: %v", err, ctx.Err())
+		}
+		return Result{Err: err, Output: output}
+	}
+
+	var inspect struct {
+		Running  bool
+		ExitCode int
+	}
+	// The exec may take a moment to be marked done after its output ends.
+	for {
+		if err := c.docker.do(ctx, http.MethodGet, "/exec/"+created.ID+"/json", nil, &inspect); err != nil {
+			return Result{Err: err, Output: output}
+		}
+		if !inspect.Running {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return Result{Err: ctx.Err(), Output: output}
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	if inspect.ExitCode != 0 {
+		err := fmt.Errorf("exit status %d", inspect.ExitCode)
+		if last := lastLine(output); last != "" {
+			err = fmt.Errorf("%v: %s", err, last)
+		}
+		return Result{Err: err, Output: output}
+	}
+	return Result{Healthy: true, Output: output}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// jsonErrors makes fatal errors and the failures of one-shot runs be
+// written to stderr as JSON lines, see -output.
+var jsonErrors bool
+
+// Kinds of errorRecord.
+const (
+	errUsage   = "usage"   // invalid flags
+	errConfig  = "config"  // the config or a check in it is invalid
+	errRuntime = "runtime" // e.g. the history or the listen address can't be opened
+	errCheck   = "check"   // a check is unhealthy
+	errSkipped = "skipped" // a check wasn't run because a dependency is down
+)
+
+// errorRecord is an error as written to stderr with -output json.
+type errorRecord struct {
+	Kind  string
+	Check string `json:",omitempty"` // ID of the check
+	Error string
+	Fatal bool `json:",omitempty"` // the command exits
+}
+
+func writeError(r errorRecord) {
+	if !jsonErrors {
+		fmt.Fprintf(os.Stderr, "x: %s\n", r.Error)
+		return
+	}
+	json.NewEncoder(os.Stderr).Encode(r)
+}
+
+// fatal writes err as an error of the given kind and exits with status 1.
+func fatal(kind string, err error) {
+	writeError(errorRecord{Kind: kind, Error: err.Error(), Fatal: true})
+	os.Exit(1)
+}
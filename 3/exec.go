@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// execConfig configures an exec check.
+type execConfig struct {
+	Command string   // program to run, looked up in PATH if it has no slash
+	Args    []string `json:",omitempty"`
+	Env     []string `json:",omitempty"` // KEY=VALUE added to the environment
+	Dir     string   `json:",omitempty"` // working directory
+}
+
+// maxOutput limits how much of a command's output is kept in a Result.
+const maxOutput = 4096
+
+// execDisabled is the flag that keeps the config from running commands:
+// -read-only, -sandbox or -agent without -agent-exec. Empty means none.
+var execDisabled string
+
+// execChecker is healthy if a command exits with status 0. It's meant for
+// wrapping existing health check scripts.
+type execChecker struct {
+	cfg execConfig
+}
+
+func newExecChecker(h HealthCheck) (Checker, error) {
+	if execDisabled != "" {
+		return nil, fmt.Errorf("exec checks are disabled by %s", execDisabled)
+	}
+	var errs []error
+	if h.Name == "" {
+		errs = append(errs, errors.New("exec checks need a Name"))
+	}
+	if h.Exec == nil || h.Exec.Command == "" {
+		errs = append(errs, errors.New("missing Exec.Command"))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	for _, kv := range h.Exec.Env {
+		if !strings.Contains(kv, "=") {
+			return nil, fmt.Errorf("Exec.Env: want KEY=VALUE, not %q", kv)
+		}
+	}
+	return execChecker{cfg: *h.Exec}, nil
+}
+
+func (c execChecker) Check(ctx context.Context) Result {
+	cmd := exec.CommandContext(ctx, c.cfg.Command, c.cfg.Args...)
+	cmd.Dir = c.cfg.Dir
+	cmd.Env = append(os.Environ(), c.cfg.Env...)
+	// Don't wait forever for children that inherited the output pipe.
+	cmd.WaitDelay = time.Second
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+
+	output := out.String()
+	if len(output) > maxOutput {
+		output = output[len(output)-maxOutput:]
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("%v: %v", err, ctx.Err())
+		}
+		if last := lastLine(output); last != "" {
+			err = fmt.Errorf("%v: %s", err, last)
+		}
+		return Result{Err: err, Output: output}
+	}
+	return Result{Healthy: true, Output: output}
+}
+
+// lastLine returns the last non-empty line of s, typically the most
+// relevant error message of a script.
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	return s[strings.LastIndexByte(s, '\n')+1:]
+}
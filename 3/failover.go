@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// failoverChecker resolves the host of an HTTP or TCP URL and checks each
+// of its addresses directly. It's healthy if at least MinHealthy of them
+// are, so DNS based failover has somewhere to fail over to.
+type failoverChecker struct {
+	h          HealthCheck
+	host, port string
+	minHealthy int
+}
+
+func newFailoverChecker(h HealthCheck) (Checker, error) {
+	u, err := parseURL(h, "http", "https", "tcp")
+	if err != nil {
+		return nil, err
+	}
+	if h.MinHealthy < 0 {
+		return nil, errors.New("MinHealthy must not be negative")
+	}
+	port := u.Port()
+	switch {
+	case port != "":
+	case u.Scheme == "https":
+		port = "443"
+	case u.Scheme == "http":
+		port = "80"
+	default:
+		return nil, errors.New("URL has no port")
+	}
+	if u.Scheme != "tcp" {
+		// Validate the HTTP settings once rather than per address.
+		if _, err := newHTTPChecker(h); err != nil {
+			return nil, err
+		}
+	}
+	return failoverChecker{h: h, host: u.Hostname(), port: port, minHealthy: max(h.MinHealthy, 1)}, nil
+}
+
+func (c failoverChecker) Check(ctx context.Context) Result {
+	network, _ := c.h.ipNetwork() // validated by resolving
+	addrs, err := c.h.lookup().LookupNetIP(ctx, network, c.host)
+	if err != nil {
+		return Result{Err: err}
+	}
+	ips := make([]string, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.Unmap().String()
+	}
+	results := make([]Result, len(ips))
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addr := net.JoinHostPort(ip, c.port)
+			if strings.HasPrefix(c.h.URL, "tcp:") {
+				results[i] = tcpChecker{addr: addr, dial: c.h.dial}.Check(ctx)
+				return
+			}
+			// Keep the URL, so the Host header and TLS server name are right.
+			hc, _ := newHTTPChecker(c.h.dialing("tcp", addr)) // validated by newFailoverChecker
+			results[i] = hc.Check(ctx)
+		}()
+	}
+	wg.Wait()
+
+	var out strings.Builder
+	healthy := 0
+	for i, r := range results {
+		if r.Healthy {
+			healthy++
+			fmt.Fprintf(&out, "%s: healthy\n", ips[i])
+		} else {
+			fmt.Fprintf(&out, "%s: %v\n", ips[i], r.Err)
+		}
+	}
+	if healthy < c.minHealthy {
+		return Result{Err: fmt.Errorf("%d of %d addresses of %s healthy, want at least %d", healthy, len(ips), c.host, c.minHealthy), Output: out.String()}
+	}
+	return Result{Healthy: true, Output: out.String()}
+}
+
+// failoverURLChecker checks the FailoverURL of an HTTP check when its URL
+// fails or, if always, every time. It's healthy if the URL is and the
+// probed failover works, or if the failover takes over.
+type failoverURLChecker struct {
+	primary, failover httpChecker
+	always            bool
+}
+
+func (c failoverURLChecker) Check(ctx context.Context) Result {
+	r := c.primary.Check(ctx)
+	if r.Healthy && !c.always {
+		return r
+	}
+	f := c.failover.Check(ctx)
+	switch {
+	case r.Healthy && !f.Healthy:
+		return Result{Err: fmt.Errorf("failover %s is unhealthy: %v", c.failover.h.URL, f.Err), Redirects: r.Redirects, Protocol: r.Protocol}
+	case r.Healthy:
+		r.Output = fmt.Sprintf("failover %s: healthy\n", c.failover.h.URL)
+		return r
+	case f.Healthy:
+		f.Output = fmt.Sprintf("%s: %v\nfailover %s: healthy\n", c.primary.h.URL, r.Err, c.failover.h.URL)
+		return f
+	}
+	return Result{Err: fmt.Errorf("%v, and failover %s: %v", r.Err, c.failover.h.URL, f.Err), Redirects: r.Redirects, Protocol: r.Protocol}
+}
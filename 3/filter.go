@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// checkFilter selects a subset of the health checks.
+type checkFilter struct {
+	name string   // glob pattern matched against the ID, see path.Match
+	tags []string // a check must have all of them
+
+	// labels of this agent, e.g. region=eu-west, which must match the
+	// RunOn of checks that have one. Nil ignores RunOn.
+	labels map[string]string
+}
+
+func newCheckFilter(name, tags string) checkFilter {
+	f := checkFilter{name: name}
+	for _, t := range strings.Split(tags, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			f.tags = append(f.tags, t)
+		}
+	}
+	return f
+}
+
+func (f checkFilter) match(h HealthCheck) bool {
+	if f.name != "" {
+		if ok, _ := path.Match(f.name, h.ID()); !ok {
+			return false
+		}
+	}
+	for _, t := range f.tags {
+		if !slices.Contains(h.Tags, t) {
+			return false
+		}
+	}
+	if f.labels == nil {
+		return true
+	}
+	for k, values := range h.RunOn {
+		if v, ok := f.labels[k]; !ok || !slices.Contains(values, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLabels parses comma separated key=value labels. Without any it
+// returns nil, so checks are run regardless of their RunOn.
+func parseLabels(s string) (map[string]string, error) {
+	var labels map[string]string
+	for _, l := range strings.Split(s, ",") {
+		if l = strings.TrimSpace(l); l == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(l, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("label %q isn't key=value", l)
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+func (f checkFilter) filter(hs []HealthCheck) []HealthCheck {
+	var out []HealthCheck
+	for _, h := range hs {
+		if f.match(h) {
+			out = append(out, h)
+		}
+	}
+	return out
+}
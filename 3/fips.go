@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/fips140"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// fipsMode is set by -fips. The checker then runs only in Go's FIPS 140-3
+// mode, where crypto/tls negotiates only approved versions, cipher suites,
+// curves and signature algorithms, and explains the handshake failures
+// this causes with servers that don't support them.
+var fipsMode bool
+
+// checkFIPS makes sure Go's FIPS 140-3 mode is on, which can only be
+// enabled when the program starts.
+func checkFIPS() error {
+	if !fips140.Enabled() {
+		return errors.New("-fips needs Go's FIPS 140-3 mode: run with GODEBUG=fips140=on or build with GOFIPS140=v1.0.0")
+	}
+	return nil
+}
+
+// fipsHint adds to TLS errors in FIPS mode that the server may not support
+// the approved algorithms.
+func fipsHint(err error) error {
+	if !fipsMode || err == nil {
+		return err
+	}
+	var alert tls.AlertError
+	if errors.As(err, &alert) || strings.Contains(err.Error(), "tls: ") {
+		return fmt.Errorf("%w (in FIPS mode only TLS 1.2 and 1.3 with approved algorithms are allowed, the server may not support them)", err)
+	}
+	return err
+}
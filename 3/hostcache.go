@@ -0,0 +1,166 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// hostCache is what the checks of one scheduling round found out about the
+// hosts they connect to: their addresses and whether their certificates
+// verify. Many checks of the same host thus look it up and verify its
+// certificate once per round, while still making their own requests.
+type hostCache struct {
+	mu    sync.Mutex
+	addrs map[string]*hostLookup // by DNS server, network and host
+	certs map[string]error       // verifications by roots, server name and chain
+}
+
+type hostLookup struct {
+	done chan struct{} // closed once ips and err are set
+	ips  []netip.Addr
+	err  error
+}
+
+// roundHosts is the hostCache of the current round, nil before the first.
+var roundHosts atomic.Pointer[hostCache]
+
+// newHostRound starts a new round of checks, which look up and verify
+// hosts anew.
+func newHostRound() {
+	roundHosts.Store(&hostCache{addrs: make(map[string]*hostLookup), certs: make(map[string]error)})
+}
+
+// lookup returns the addresses of host in network (ip, ip4 or ip6) by r,
+// whose DNS server is server, "" for the system's. Concurrent lookups of
+// the same host wait for the first.
+func (c *hostCache) lookup(ctx context.Context, r *net.Resolver, server, network, host string) ([]netip.Addr, error) {
+	key := server + " " + network + " " + host
+	c.mu.Lock()
+	l, ok := c.addrs[key]
+	if !ok {
+		l = &hostLookup{done: make(chan struct{})}
+		c.addrs[key] = l
+	}
+	c.mu.Unlock()
+	if !ok {
+		l.ips, l.err = r.LookupNetIP(ctx, network, host)
+		close(l.done)
+	}
+	select {
+	case <-l.done:
+		return l.ips, l.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dialHost connects to addr like d, but looks up its host in the current
+// round's hostCache. server is the DNS server of d's resolver, "" for the
+// system's. The addresses are tried in order, without the Happy Eyeballs
+// of net.Dialer.
+func dialHost(ctx context.Context, d *net.Dialer, server, network, addr string) (net.Conn, error) {
+	c := roundHosts.Load()
+	host, port, err := net.SplitHostPort(addr)
+	if c == nil || err != nil || !strings.HasPrefix(network, "tcp") {
+		return d.DialContext(ctx, network, addr)
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return d.DialContext(ctx, network, addr)
+	}
+	ips, err := c.lookup(ctx, cmp.Or(d.Resolver, net.DefaultResolver), server, "ip"+network[len("tcp"):], host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	var first error
+	for _, ip := range ips {
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip.Unmap().String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		first = cmp.Or(first, err)
+	}
+	if first == nil {
+		first = &net.OpError{Op: "dial", Net: network, Err: &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}}
+	}
+	return nil, first
+}
+
+// verify verifies the certificate chain of serverName with roots, nil for
+// the system's, like crypto/tls does, but only once per round.
+func (c *hostCache) verify(chain []*x509.Certificate, roots *x509.CertPool, serverName string) error {
+	if len(chain) == 0 {
+		return errors.New("tls: server sent no certificates")
+	}
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%p %s\n", roots, serverName)
+	for _, cert := range chain {
+		sum.Write(cert.Raw)
+	}
+	key := string(sum.Sum(nil))
+	if c != nil {
+		c.mu.Lock()
+		err, ok := c.certs[key]
+		c.mu.Unlock()
+		if ok {
+			return err
+		}
+	}
+	opts := x509.VerifyOptions{Roots: roots, DNSName: serverName, Intermediates: x509.NewCertPool()}
+	for _, cert := range chain[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(opts)
+	if err != nil {
+		err = &tls.CertificateVerificationError{UnverifiedCertificates: chain, Err: err}
+	}
+	if c != nil {
+		c.mu.Lock()
+		c.certs[key] = err
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// reuseHosts makes t look up hosts and verify their certificates once per
+// round, see hostCache. It must be called again on clones of t that dial
+// differently.
+func reuseHosts(t *http.Transport) {
+	t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dial := t.DialContext
+		if dial == nil {
+			var d net.Dialer
+			dial = d.DialContext
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg := &tls.Config{}
+		if t.TLSClientConfig != nil {
+			cfg = t.TLSClientConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		if !cfg.InsecureSkipVerify {
+			roots, name := cfg.RootCAs, cfg.ServerName
+			cfg.InsecureSkipVerify = true // verified here instead
+			cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+				return roundHosts.Load().verify(cs.PeerCertificates, roots, name)
+			}
+		}
+		// The transport does the handshake, tracing it like its own.
+		return tls.Client(conn, cfg), nil
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// normalizeURL returns rawURL with an internationalized host converted to
+// its ASCII (punycode) form and the scheme and host lowercased, so a check
+// of https://bücher.example/ connects to https://xn--bcher-kva.example/.
+// URLs without a host, like ARNs or exec checks' empty URL, are returned
+// unchanged.
+func normalizeURL(rawURL string) (string, error) {
+	if rawURL == "" {
+		return "", nil
+	}
+	if !utf8.ValidString(rawURL) {
+		return "", fmt.Errorf("URL %q is not valid UTF-8", rawURL)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %v", rawURL, errors.Unwrap(err))
+	}
+	if u.Host == "" {
+		return rawURL, nil
+	}
+	host, err := hostToASCII(u.Hostname())
+	if err != nil {
+		return "", fmt.Errorf("URL %q: %v", rawURL, err)
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" {
+		host += ":" + port
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = host
+	return u.String(), nil
+}
+
+// hostToASCII converts a host name to the ASCII form DNS uses, encoding
+// each non-ASCII label with punycode (RFC 3492). It lowercases but doesn't
+// apply the full IDNA mapping, so names should be written in their
+// normalized (NFC) form.
+func hostToASCII(host string) (string, error) {
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+	// Dots other scripts use to separate labels (IDNA 2003).
+	host = strings.NewReplacer("。", ".", "．", ".", "｡", ".").Replace(host)
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(host), "."), ".")
+	for i, label := range labels {
+		switch {
+		case label == "":
+			return "", fmt.Errorf("host %q has an empty label", host)
+		case strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-"):
+			return "", fmt.Errorf("host %q: label %q must not start or end with a hyphen", host, label)
+		}
+		ascii := true
+		for _, r := range label {
+			if r >= utf8.RuneSelf {
+				ascii = false
+			}
+			if unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune("/?#@[]%", r) {
+				return "", fmt.Errorf("host %q: label %q contains %q", host, label, r)
+			}
+		}
+		if !ascii {
+			label = "xn--" + punycode(label)
+		}
+		if len(label) > 63 {
+			return "", fmt.Errorf("host %q: label %q is longer than 63 bytes", host, label)
+		}
+		labels[i] = label
+	}
+	ascii := strings.Join(labels, ".")
+	if len(ascii) > 253 {
+		return "", fmt.Errorf("host %q is longer than 253 bytes", host)
+	}
+	return ascii, nil
+}
+
+// punycode encodes s as described in RFC 3492, without the xn-- prefix.
+func punycode(s string) string {
+	const (
+		base        = 36
+		tmin        = 1
+		tmax        = 26
+		skew        = 38
+		damp        = 700
+		initialBias = 72
+		initialN    = 128
+	)
+	adapt := func(delta, points int, first bool) int {
+		if first {
+			delta /= damp
+		} else {
+			delta /= 2
+		}
+		delta += delta / points
+		k := 0
+		for delta > (base-tmin)*tmax/2 {
+			delta /= base - tmin
+			k += base
+		}
+		return k + (base-tmin+1)*delta/(delta+skew)
+	}
+	digit := func(d int) byte {
+		if d < 26 {
+			return byte('a' + d)
+		}
+		return byte('0' + d - 26)
+	}
+
+	runes := []rune(s)
+	var out []byte
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	if basic > 0 {
+		out = append(out, '-')
+	}
+	n, delta, bias := initialN, 0, initialBias
+	for handled := basic; handled < len(runes); {
+		m := int(unicode.MaxRune) + 1
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+		delta += (m - n) * (handled + 1)
+		n = m
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+			q := delta
+			for k := base; ; k += base {
+				t := min(max(k-bias, tmin), tmax)
+				if q < t {
+					break
+				}
+				out = append(out, digit(t+(q-t)%(base-t)))
+				q = (q - t) / (base - t)
+			}
+			out = append(out, digit(q))
+			bias = adapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// instance is a discovered instance of a service, the data of the URL
+// template of its check, e.g. http://{{.Address}}:{{.Port}}/healthz.
+type instance struct {
+	Service string // Consul service or SRV name
+	ID      string // Consul service ID or SRV target:port
+	Node    string // Consul node, empty for SRV
+	Address string // IP address or, for SRV, host name
+	Port    int
+	Tags    []string // Consul service tags
+}
+
+// defaultInstanceURL is the default URL template of discovered instances.
+const defaultInstanceURL = "http://{{.Address}}:{{.Port}}/healthz"
+
+func parseInstanceURL(text string) (*template.Template, error) {
+	t, err := template.New("url").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	// Fail now rather than at every discovery.
+	if err := t.Execute(io.Discard, instance{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// instanceChecks returns HTTP checks of instances, named source/service/ID.
+func instanceChecks(source string, urlTemplate *template.Template, instances []instance) ([]HealthCheck, error) {
+	var out []HealthCheck
+	for _, in := range instances {
+		var u strings.Builder
+		if err := urlTemplate.Execute(&u, in); err != nil {
+			return nil, err
+		}
+		out = append(out, HealthCheck{
+			Name:              source + "/" + in.Service + "/" + in.ID,
+			URL:               u.String(),
+			HealthyStatusCode: http.StatusOK,
+			Tags:              append([]string{source, in.Service}, in.Tags...),
+			discovered:        source,
+		})
+	}
+	return out, nil
+}
+
+// consulDiscovery generates checks of the instances of services in the
+// Consul catalog.
+type consulDiscovery struct {
+	addr     string   // e.g. http://127.0.0.1:8500
+	services []string // names
+	tag      string   // only instances with this tag, if not empty
+	url      *template.Template
+	client   *http.Client
+}
+
+func (c *consulDiscovery) name() string { return "consul" }
+
+func (c *consulDiscovery) discover(ctx context.Context) ([]HealthCheck, error) {
+	var instances []instance
+	for _, service := range c.services {
+		u := strings.TrimSuffix(c.addr, "/") + "/v1/catalog/service/" + url.PathEscape(service)
+		if c.tag != "" {
+			u += "?tag=" + url.QueryEscape(c.tag)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+			req.Header.Set("X-Consul-Token", token)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var entries []struct {
+			Node           string
+			Address        string // of the node
+			ServiceID      string
+			ServiceAddress string // empty if the node's
+			ServicePort    int
+			ServiceTags    []string
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&entries)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: %v", u, err)
+		}
+		for _, e := range entries {
+			addr := e.ServiceAddress
+			if addr == "" {
+				addr = e.Address
+			}
+			instances = append(instances, instance{Service: service, ID: e.ServiceID, Node: e.Node, Address: addr, Port: e.ServicePort, Tags: e.ServiceTags})
+		}
+	}
+	return instanceChecks(c.name(), c.url, instances)
+}
+
+// srvDiscovery generates checks of the targets of DNS SRV records, e.g.
+// _http._tcp.api.example.com.
+type srvDiscovery struct {
+	names []string
+	url   *template.Template
+}
+
+func (s *srvDiscovery) name() string { return "srv" }
+
+func (s *srvDiscovery) discover(ctx context.Context) ([]HealthCheck, error) {
+	var instances []instance
+	for _, name := range s.names {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvs {
+			target := strings.TrimSuffix(srv.Target, ".")
+			port := int(srv.Port)
+			instances = append(instances, instance{Service: name, ID: net.JoinHostPort(target, strconv.Itoa(port)), Address: target, Port: port})
+		}
+	}
+	return instanceChecks(s.name(), s.url, instances)
+}
+
+// splitList returns the non-empty elements of the comma separated list s.
+func splitList(s string) []string {
+	var out []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
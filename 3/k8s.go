@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Files of the service account token Kubernetes mounts into pods.
+const (
+	k8sTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	k8sCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// Annotations of Services and Ingresses that change their checks.
+const (
+	k8sPathAnnotation = "healthcheck/path" // health path, instead of -k8s-path
+	k8sPortAnnotation = "healthcheck/port" // name or number of the Service port to check
+)
+
+// k8sDiscovery generates HTTP checks of the Services or Ingresses matching
+// a label selector. Services are checked at their cluster DNS name, so the
+// checker must run in the cluster, Ingresses at their hosts.
+type k8sDiscovery struct {
+	api       string // e.g. https://kubernetes.default.svc
+	tokenFile string // read for every request, as tokens are rotated
+	client    *http.Client
+	kind      string // services or ingresses
+	namespace string // empty means all
+	selector  string
+	path      string // default health path
+}
+
+// newK8sDiscovery returns a discovery of the given kind using the API at
+// api or, if it's empty, the API of the cluster the checker runs in.
+func newK8sDiscovery(api, kind, namespace, selector, path string) (*k8sDiscovery, error) {
+	if kind != "services" && kind != "ingresses" {
+		return nil, fmt.Errorf("unknown kind %q, want services or ingresses", kind)
+	}
+	k := &k8sDiscovery{api: api, kind: kind, namespace: namespace, selector: selector, path: path, client: &http.Client{Timeout: 30 * time.Second}}
+	if api != "" {
+		// E.g. kubectl proxy, which authenticates itself.
+		return k, nil
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster, set the API's URL")
+	}
+	k.api = "https://" + net.JoinHostPort(host, port)
+	k.tokenFile = k8sTokenFile
+	pem, err := os.ReadFile(k8sCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", k8sCAFile)
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	k.client.Transport = t
+	return k, nil
+}
+
+func (k *k8sDiscovery) name() string { return "kubernetes" }
+
+// k8sMeta is the metadata of Kubernetes objects.
+type k8sMeta struct {
+	Name        string
+	Namespace   string
+	Annotations map[string]string
+}
+
+type k8sPort struct {
+	Name string
+	Port int
+}
+
+func (k *k8sDiscovery) discover(ctx context.Context) ([]HealthCheck, error) {
+	switch k.kind {
+	case "services":
+		var list struct {
+			Items []struct {
+				Metadata k8sMeta
+				Spec     struct {
+					Type  string
+					Ports []k8sPort
+				}
+			}
+		}
+		if err := k.list(ctx, "/api/v1", &list); err != nil {
+			return nil, err
+		}
+		var out []HealthCheck
+		for _, s := range list.Items {
+			if s.Spec.Type == "ExternalName" || len(s.Spec.Ports) == 0 {
+				continue
+			}
+			port := s.Spec.Ports[0]
+			if want, ok := s.Metadata.Annotations[k8sPortAnnotation]; ok {
+				i := slices.IndexFunc(s.Spec.Ports, func(p k8sPort) bool { return p.Name == want || strconv.Itoa(p.Port) == want })
+				if i < 0 {
+					continue // nothing to check
+				}
+				port = s.Spec.Ports[i]
+			} else if i := slices.IndexFunc(s.Spec.Ports, func(p k8sPort) bool { return p.Name == "http" || p.Name == "https" }); i >= 0 {
+				port = s.Spec.Ports[i]
+			}
+			scheme := "http"
+			if port.Name == "https" || port.Port == 443 {
+				scheme = "https"
+			}
+			host := net.JoinHostPort(s.Metadata.Name+"."+s.Metadata.Namespace+".svc", strconv.Itoa(port.Port))
+			out = append(out, k.check(s.Metadata, "", scheme+"://"+host))
+		}
+		return out, nil
+	default:
+		var list struct {
+			Items []struct {
+				Metadata k8sMeta
+				Spec     struct {
+					TLS []struct {
+						Hosts []string
+					}
+					Rules []struct {
+						Host string
+					}
+				}
+			}
+		}
+		if err := k.list(ctx, "/apis/networking.k8s.io/v1", &list); err != nil {
+			return nil, err
+		}
+		var out []HealthCheck
+		for _, ing := range list.Items {
+			for _, r := range ing.Spec.Rules {
+				if r.Host == "" || strings.HasPrefix(r.Host, "*") {
+					continue // no name to check
+				}
+				scheme := "http"
+				for _, t := range ing.Spec.TLS {
+					if slices.Contains(t.Hosts, r.Host) {
+						scheme = "https"
+					}
+				}
+				out = append(out, k.check(ing.Metadata, r.Host, scheme+"://"+r.Host))
+			}
+		}
+		return out, nil
+	}
+}
+
+// check returns the check of an object at base, named after the object
+// and, for Ingresses with several hosts, the host.
+func (k *k8sDiscovery) check(m k8sMeta, host, base string) HealthCheck {
+	path := k.path
+	if p, ok := m.Annotations[k8sPathAnnotation]; ok {
+		path = p
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	name := "k8s/" + m.Namespace + "/" + m.Name
+	if host != "" {
+		name += "/" + host
+	}
+	return HealthCheck{
+		Name:              name,
+		URL:               base + path,
+		HealthyStatusCode: http.StatusOK,
+		Tags:              []string{"k8s", m.Namespace},
+		discovered:        k.name(),
+	}
+}
+
+// list gets the objects of k's kind from the API group at prefix into v.
+func (k *k8sDiscovery) list(ctx context.Context, prefix string, v any) error {
+	path := prefix
+	if k.namespace != "" {
+		path += "/namespaces/" + url.PathEscape(k.namespace)
+	}
+	u := strings.TrimSuffix(k.api, "/") + path + "/" + k.kind
+	if k.selector != "" {
+		u += "?labelSelector=" + url.QueryEscape(k.selector)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if k.tokenFile != "" {
+		token, err := os.ReadFile(k.tokenFile)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("GET %s: %s: %s", u, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
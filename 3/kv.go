@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// kvStore is a key-value store the daemon exports health check states to,
+// so service routing and other infrastructure can react to them.
+type kvStore interface {
+	put(key string, value []byte) error
+}
+
+func newKVStore(kind, addr string) (kvStore, error) {
+	addr = strings.TrimSuffix(addr, "/")
+	client := &http.Client{Timeout: 5 * time.Second}
+	switch kind {
+	case "consul":
+		return consulKV{addr: addr, client: client}, nil
+	case "etcd":
+		return etcdKV{addr: addr, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown key-value store %q, want consul or etcd", kind)
+	}
+}
+
+// consulKV uses the Consul HTTP API, e.g. http://127.0.0.1:8500.
+type consulKV struct {
+	addr   string
+	client *http.Client
+}
+
+func (c consulKV) put(key string, value []byte) error {
+	req, err := http.NewRequest(http.MethodPut, c.addr+"/v1/kv/"+key, bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+	return doRequest(c.client, req)
+}
+
+// etcdKV uses the etcd v3 JSON gateway, e.g. http://127.0.0.1:2379.
+type etcdKV struct {
+	addr   string
+	client *http.Client
+}
+
+func (e etcdKV) put(key string, value []byte) error {
+	body, err := json.Marshal(map[string]string{
+		"key":   base64.StdEncoding.EncodeToString([]byte(key)),
+		"value": base64.StdEncoding.EncodeToString(value),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.addr+"/v3/kv/put", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doRequest(e.client, req)
+}
+
+func doRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
+	}
+	return nil
+}
+
+// kvState is the value exported for a health check.
+type kvState struct {
+	ResultID string // of the result that changed the state
+	Name     string `json:",omitempty"`
+	URL      string
+	Healthy  bool
+	Since    time.Time
+	Error    string `json:",omitempty"`
+}
+
+// exportState writes the state of h to d.kv under the key prefix followed
+// by the escaped ID of the check.
+func (d *daemon) exportState(h HealthCheck, resultID string, healthy bool, since time.Time, err error) {
+	v := kvState{ResultID: resultID, Name: h.Name, URL: h.URL, Healthy: healthy, Since: since}
+	if err != nil {
+		v.Error = err.Error()
+	}
+	data, _ := json.Marshal(v)
+	err = d.kv.put(d.kvPrefix+url.PathEscape(h.ID()), data)
+	d.report("kv-export", err)
+	if err != nil {
+		slog.Error("can't export state", append(checkAttrs(h), "err", err)...)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// lang is the language of the CLI output, see addLangFlag.
+var lang = defaultLang()
+
+// catalogs translate the format strings of the CLI output, keyed by
+// language and English format. Messages missing from a catalog are printed
+// in English. Log messages aren't translated as they're meant for machines
+// as much as for humans.
+var catalogs = map[string]map[string]string{
+	"en": {},
+	"de": {
+		"PASS":                         "OK",
+		"FAIL":                         "FEHLER",
+		"SKIP":                         "ÜBERSPRUNGEN",
+		"paused":                       "pausiert",
+		"dependency %s is down":        "Abhängigkeit %s ist ausgefallen",
+		"%s:%d: check %d (%s): %s\n":   "%s:%d: Check %d (%s): %s\n",
+		"%s: %d problem(s) found":      "%s: %d Problem(e) gefunden",
+		"still unhealthy after %v: %s": "nach %v immer noch nicht gesund: %s",
+		"no check %q in %s":            "kein Check %q in %s",
+		"CHECK\tCHECKS\tUPTIME\tMEAN\tP50\tP95\tP99\tDOWNTIME":           "CHECK\tANZAHL\tVERFÜGBARKEIT\tMITTEL\tP50\tP95\tP99\tAUSFALLZEIT",
+		"CHECK\tBASELINE\tALERTS\tSHORT\tFLAPPING\tSILENCED\tALERT TIME": "CHECK\tBISHER\tALARME\tKURZ\tFLATTERND\tSTUMM\tALARMZEIT",
+		"TIME\tSOURCE\tACTOR\tCHANGES":                                   "ZEIT\tQUELLE\tAKTEUR\tÄNDERUNGEN",
+		"TOTAL":                                                          "SUMME",
+		"%s: %d runs, %d failed, in %v\n\n":                              "%s: %d Läufe, %d fehlgeschlagen, in %v\n\n",
+		"\nErrors:\n":                                                    "\nFehler:\n",
+		"\nStatus: %s\n":                                                 "\nStatus: %s\n",
+		"\nHeaders:\n":                                                   "\nHeader:\n",
+		"\nBody (%d bytes):\n":                                           "\nBody (%d Bytes):\n",
+		"\nAssertions:\n":                                                "\nPrüfungen:\n",
+		"  FAIL %s: %v\n":                                                "  FEHLER %s: %v\n",
+		"  PASS %s\n":                                                    "  OK %s\n",
+		"Health checks":                                                  "Health-Checks",
+		"STATE\tCHECK\tLATENCY\tLAST\tUPTIME\tERROR":                     "ZUSTAND\tCHECK\tLATENZ\tLETZTE\tVERFÜGBARKEIT\tFEHLER",
+		"healthy":                                                        "gesund",
+		"unhealthy":                                                      "nicht gesund",
+		"unknown":                                                        "unbekannt",
+		"skipped":                                                        "übersprungen",
+		"%d ok, %d failing, ran in %v\n":                                 "%d ok, %d fehlerhaft, Laufzeit %v\n",
+		"%d ok, %d failing, %d skipped, ran in %v\n":                     "%d ok, %d fehlerhaft, %d übersprungen, Laufzeit %v\n",
+	},
+}
+
+// defaultLang returns the language of the locale set in the environment if
+// there's a catalog for it, and English otherwise.
+func defaultLang() string {
+	for _, v := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if l := os.Getenv(v); l != "" {
+			l, _, _ = strings.Cut(l, "_") // e.g. de_DE.UTF-8
+			if _, ok := catalogs[l]; ok {
+				return l
+			}
+			break
+		}
+	}
+	return "en"
+}
+
+// addLangFlag registers the -lang flag.
+func addLangFlag(fs *flag.FlagSet) {
+	langs := slices.Sorted(maps.Keys(catalogs))
+	usage := fmt.Sprintf("print messages in `language`: %s (default from $LANG)", strings.Join(langs, ", "))
+	fs.Func("lang", usage, func(s string) error {
+		if _, ok := catalogs[s]; !ok {
+			return fmt.Errorf("want one of %s", strings.Join(langs, ", "))
+		}
+		lang = s
+		return nil
+	})
+}
+
+// tr formats a message in the selected language.
+func tr(format string, args ...any) string {
+	if t, ok := catalogs[lang][format]; ok {
+		format = t
+	}
+	return fmt.Sprintf(format, args...)
+}
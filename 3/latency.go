@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// latencyWindow is a histogram of the latencies of the last results of a
+// check, so the status page can show percentiles without storing every
+// latency. Like an HDR histogram, its buckets are exact up to 128µs and
+// within 1/64 (1.6%) of the latency above.
+type latencyWindow struct {
+	size   int
+	ring   []int // bucket indexes of the latencies, oldest at next once full
+	next   int
+	counts []int // per bucket
+}
+
+// defaultLatencyWindow is how many latencies a latencyWindow has by default.
+const defaultLatencyWindow = 1000
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{size: size}
+}
+
+// add adds d, dropping the oldest latency if the window is full.
+func (w *latencyWindow) add(d time.Duration) {
+	i := latencyBucket(d)
+	if i >= len(w.counts) {
+		w.counts = append(w.counts, make([]int, i+1-len(w.counts))...)
+	}
+	w.counts[i]++
+	if len(w.ring) < w.size {
+		w.ring = append(w.ring, i)
+		return
+	}
+	w.counts[w.ring[w.next]]--
+	w.ring[w.next] = i
+	w.next = (w.next + 1) % w.size
+}
+
+// percentile returns the pth percentile of the latencies in w using the
+// nearest-rank method, 0 if w is empty.
+func (w *latencyWindow) percentile(p float64) time.Duration {
+	if len(w.ring) == 0 {
+		return 0
+	}
+	rank := max(int(math.Ceil(p/100*float64(len(w.ring)))), 1)
+	for i, n := range w.counts {
+		if rank -= n; rank <= 0 {
+			return latencyOf(i)
+		}
+	}
+	return 0 // not reached
+}
+
+// latencyBucket returns the index of the bucket of d.
+func latencyBucket(d time.Duration) int {
+	us := uint64(max(d.Microseconds(), 0))
+	if us < 128 {
+		return int(us)
+	}
+	e := bits.Len64(us) - 7 // us>>e is in [64, 128)
+	return 128 + (e-1)*64 + int(us>>e) - 64
+}
+
+// latencyOf returns the latency in the middle of bucket i.
+func latencyOf(i int) time.Duration {
+	if i < 128 {
+		return time.Duration(i) * time.Microsecond
+	}
+	e := (i-128)/64 + 1
+	m := uint64((i-128)%64 + 64)
+	return time.Duration(m<<e+1<<e/2) * time.Microsecond
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backend is a server behind a load balancer, as the load balancer sees it.
+type backend struct {
+	Name  string // e.g. the HAProxy backend and server, "web/web1"
+	Addr  string // host:port, empty if the load balancer doesn't say
+	State string // as reported, e.g. UP, unhealthy
+	Up    bool   // whether the load balancer sends it traffic
+}
+
+// lbChecker asks a load balancer for the health of its backends. It's
+// healthy if at least MinHealthy of them are up. With ProbeBackends each
+// backend is also probed over TCP, so the health the load balancer reports
+// can be compared with the health seen from here.
+type lbChecker struct {
+	h          HealthCheck
+	backends   func(ctx context.Context) ([]backend, error)
+	minHealthy int
+}
+
+func init() {
+	registerChecker("haproxy", newHAProxyChecker)
+	registerChecker("nginx", newNginxChecker)
+	registerChecker("aws-target-group", newTargetGroupChecker)
+}
+
+func newLBChecker(h HealthCheck, backends func(ctx context.Context) ([]backend, error)) (Checker, error) {
+	if h.MinHealthy < 0 {
+		return nil, errors.New("MinHealthy must not be negative")
+	}
+	return lbChecker{h: h, backends: backends, minHealthy: max(h.MinHealthy, 1)}, nil
+}
+
+func (c lbChecker) Check(ctx context.Context) Result {
+	bs, err := c.backends(ctx)
+	if err != nil {
+		return Result{Err: err}
+	}
+	if c.h.Backend != "" {
+		bs = slices.DeleteFunc(bs, func(b backend) bool {
+			group, _, _ := strings.Cut(b.Name, "/")
+			return group != c.h.Backend
+		})
+	}
+	if len(bs) == 0 {
+		return Result{Err: errors.New("load balancer reports no backends")}
+	}
+	probes := make([]Result, len(bs))
+	if c.h.ProbeBackends {
+		var wg sync.WaitGroup
+		for i, b := range bs {
+			if b.Addr == "" {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				probes[i] = tcpChecker{addr: b.Addr}.Check(ctx)
+			}()
+		}
+		wg.Wait()
+	}
+
+	var out strings.Builder
+	up, disagree := 0, 0
+	for i, b := range bs {
+		if b.Up {
+			up++
+		}
+		fmt.Fprintf(&out, "%s", b.Name)
+		if b.Addr != "" {
+			fmt.Fprintf(&out, " (%s)", b.Addr)
+		}
+		fmt.Fprintf(&out, ": %s", b.State)
+		switch p := probes[i]; {
+		case !c.h.ProbeBackends:
+		case b.Addr == "":
+			fmt.Fprintf(&out, ", can't probe, no address")
+		case p.Healthy:
+			fmt.Fprintf(&out, ", probe healthy")
+		default:
+			fmt.Fprintf(&out, ", probe %v", p.Err)
+		}
+		if c.h.ProbeBackends && b.Addr != "" && probes[i].Healthy != b.Up {
+			disagree++
+			fmt.Fprintf(&out, " (disagrees)")
+		}
+		fmt.Fprintln(&out)
+	}
+	if disagree > 0 {
+		fmt.Fprintf(&out, "%d backend(s) where the load balancer and the probe disagree\n", disagree)
+	}
+	if up < c.minHealthy {
+		return Result{Err: fmt.Errorf("%d of %d backends up, want at least %d", up, len(bs), c.minHealthy), Output: out.String()}
+	}
+	return Result{Healthy: true, Output: out.String()}
+}
+
+// lbStatus gets the status page of a load balancer at the URL of h.
+func (h HealthCheck) lbStatus(ctx context.Context) ([]byte, error) {
+	resp, body, err := h.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status page returned %s", resp.Status)
+	}
+	return body, nil
+}
+
+// newHAProxyChecker reads the CSV export of the HAProxy stats page, e.g.
+// http://lb:8404/stats;csv. Backend selects an HAProxy backend.
+func newHAProxyChecker(h HealthCheck) (Checker, error) {
+	if _, err := parseURL(h, "http", "https"); err != nil {
+		return nil, err
+	}
+	return newLBChecker(h, func(ctx context.Context) ([]backend, error) {
+		body, err := h.lbStatus(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return parseHAProxyCSV(body)
+	})
+}
+
+func parseHAProxyCSV(data []byte) ([]backend, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("# "))))
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("HAProxy stats: %v", err)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("HAProxy stats: empty")
+	}
+	col := make(map[string]int)
+	for i, name := range rows[0] {
+		col[name] = i
+	}
+	for _, name := range []string{"pxname", "svname", "status"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("HAProxy stats: no %s column, is the URL the ;csv export?", name)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	var bs []backend
+	for _, row := range rows[1:] {
+		sv := field(row, "svname")
+		if sv == "FRONTEND" || sv == "BACKEND" {
+			continue
+		}
+		status := field(row, "status")
+		bs = append(bs, backend{
+			Name:  field(row, "pxname") + "/" + sv,
+			Addr:  field(row, "addr"), // HAProxy 1.9 and later
+			State: status,
+			Up:    strings.HasPrefix(status, "UP") || status == "no check",
+		})
+	}
+	return bs, nil
+}
+
+// newNginxChecker reads the upstreams of the NGINX Plus API, e.g.
+// http://lb:8080/api/9/http/upstreams. Backend selects an upstream.
+func newNginxChecker(h HealthCheck) (Checker, error) {
+	if _, err := parseURL(h, "http", "https"); err != nil {
+		return nil, err
+	}
+	return newLBChecker(h, func(ctx context.Context) ([]backend, error) {
+		body, err := h.lbStatus(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return parseNginxUpstreams(body)
+	})
+}
+
+func parseNginxUpstreams(data []byte) ([]backend, error) {
+	var upstreams map[string]struct {
+		Peers []struct {
+			Server string
+			State  string
+		}
+	}
+	if err := json.Unmarshal(data, &upstreams); err != nil {
+		return nil, fmt.Errorf("NGINX upstreams: %v", err)
+	}
+	var bs []backend
+	for name, u := range upstreams {
+		for _, p := range u.Peers {
+			bs = append(bs, backend{Name: name + "/" + p.Server, Addr: p.Server, State: p.State, Up: p.State == "up"})
+		}
+	}
+	slices.SortFunc(bs, func(a, b backend) int { return strings.Compare(a.Name, b.Name) })
+	return bs, nil
+}
+
+// newTargetGroupChecker asks AWS for the health of the targets of the
+// ALB/NLB target group whose ARN is the URL. The credentials are read from
+// the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// environment variables.
+func newTargetGroupChecker(h HealthCheck) (Checker, error) {
+	// arn:aws:elasticloadbalancing:region:account:targetgroup/name/id
+	parts := strings.SplitN(h.URL, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "elasticloadbalancing" || !strings.HasPrefix(parts[5], "targetgroup/") {
+		return nil, fmt.Errorf("URL must be the ARN of a target group, not %q", h.URL)
+	}
+	if h.Backend != "" {
+		return nil, errors.New("Backend isn't supported by aws-target-group checks, use one check per target group")
+	}
+	region := parts[3]
+	return newLBChecker(h, func(ctx context.Context) ([]backend, error) {
+		return describeTargetHealth(ctx, h, region)
+	})
+}
+
+func describeTargetHealth(ctx context.Context, h HealthCheck, region string) ([]backend, error) {
+	keyID, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if keyID == "" || secret == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	form := url.Values{
+		"Action":         {"DescribeTargetHealth"},
+		"Version":        {"2015-12-01"},
+		"TargetGroupArn": {h.URL},
+	}.Encode()
+	endpoint := "https://elasticloadbalancing." + region + ".amazonaws.com/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	signAWS(req, []byte(form), keyID, secret, region, "elasticloadbalancing", time.Now())
+
+	client := http.Client{Transport: h.transport, Timeout: h.timeout()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error struct{ Code, Message string }
+		}
+		if xml.Unmarshal(body, &e) == nil && e.Error.Code != "" {
+			return nil, fmt.Errorf("AWS: %s: %s", e.Error.Code, e.Error.Message)
+		}
+		return nil, fmt.Errorf("AWS returned %s", resp.Status)
+	}
+	var result struct {
+		Members []struct {
+			Target struct {
+				Id   string
+				Port string
+			}
+			TargetHealth struct {
+				State  string
+				Reason string
+			}
+		} `xml:"DescribeTargetHealthResult>TargetHealthDescriptions>member"`
+	}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("AWS: %v", err)
+	}
+	var bs []backend
+	for _, m := range result.Members {
+		b := backend{
+			Name:  m.Target.Id + ":" + m.Target.Port,
+			State: m.TargetHealth.State,
+			Up:    m.TargetHealth.State == "healthy",
+		}
+		if m.TargetHealth.Reason != "" {
+			b.State += " (" + m.TargetHealth.Reason + ")"
+		}
+		if net.ParseIP(m.Target.Id) != nil { // not an instance or Lambda target
+			b.Addr = net.JoinHostPort(m.Target.Id, m.Target.Port)
+		}
+		bs = append(bs, b)
+	}
+	return bs, nil
+}
+
+// signAWS adds an AWS Signature Version 4 to req, whose body is payload.
+func signAWS(req *http.Request, payload []byte, keyID, secret, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	hash := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := func(key []byte, s string) []byte {
+		m := hmac.New(sha256.New, key)
+		m.Write([]byte(s))
+		return m.Sum(nil)
+	}
+
+	names := []string{"host"}
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	slices.Sort(names)
+	var headers strings.Builder
+	for _, name := range names {
+		v := req.URL.Host
+		if name != "host" {
+			v = strings.TrimSpace(req.Header.Get(name))
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", name, v)
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		cmp.Or(req.URL.EscapedPath(), "/"),
+		req.URL.RawQuery,
+		headers.String(),
+		signed,
+		hash(payload),
+	}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hash([]byte(canonical))
+	key := mac(mac(mac(mac([]byte("AWS4"+secret), day), region), service), "aws4_request")
+	signature := hex.EncodeToString(mac(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", keyID, scope, signed, signature))
+}
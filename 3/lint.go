@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxCriticalGap is the longest a critical check should go between runs.
+const maxCriticalGap = 15 * time.Minute
+
+func lint(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	configFile := addConfigFlags(fs)
+	fs.Parse(args)
+
+	healthChecks, lines, problems, err := readConfigLines(*configFile)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		printProblems(*configFile, healthChecks, lines, problems)
+		return fmt.Errorf("%s: invalid config, see the validate subcommand", *configFile)
+	}
+	printProblems(*configFile, healthChecks, lines, lintChecks(healthChecks))
+	return nil
+}
+
+// lintChecks returns warnings about health checks that are valid but
+// probably not what the author wanted.
+func lintChecks(hs []HealthCheck) []problem {
+	var warnings []problem
+	warn := func(i int, format string, args ...any) {
+		warnings = append(warnings, problem{Check: i, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	seen := make(map[string]int)
+	for i, h := range hs {
+		if h.timeout() <= 0 {
+			warn(i, "no response timeout, a hanging service blocks the check forever")
+		}
+		if h.Severity == "critical" {
+			if gap := longestGap(h); gap > maxCriticalGap {
+				warn(i, "critical check runs only every %v per its Schedule", gap)
+			}
+		}
+		if h.Owner == "" {
+			warn(i, "no Owner set")
+		}
+		if h.Runbook == "" && h.Severity == "critical" {
+			warn(i, "critical check has no Runbook")
+		}
+		first := h
+		first.dup = 0
+		if j, ok := seen[first.ID()]; ok {
+			warn(i, "same Name or URL as check %d, reported as %s", j+1, h.ID())
+		} else {
+			seen[first.ID()] = i
+		}
+		if u, err := url.Parse(h.URL); err == nil && u.Scheme == "http" && !isInternalHost(u.Hostname()) {
+			warn(i, "plain HTTP to external host %s, consider HTTPS", u.Hostname())
+		}
+	}
+	return warnings
+}
+
+// longestGap returns the longest time between two runs of h in the next
+// week per its Schedule, or zero if it has none.
+func longestGap(h HealthCheck) time.Duration {
+	s, err := h.schedule()
+	if err != nil || s == nil {
+		return 0
+	}
+	var gap time.Duration
+	t := s.next(time.Now())
+	for end := t.AddDate(0, 0, 7); !t.IsZero() && t.Before(end); {
+		n := s.next(t)
+		if n.IsZero() {
+			break
+		}
+		gap = max(gap, n.Sub(t))
+		t = n
+	}
+	return gap
+}
+
+// isInternalHost reports whether host looks like it's not reachable from
+// the internet.
+func isInternalHost(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast()
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if !strings.Contains(host, ".") {
+		return true // localhost or a single label name
+	}
+	for _, suffix := range []string{".localhost", ".local", ".internal", ".lan"} {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// logOutput is where logs are written, see tuiLog.
+var logOutput io.Writer = os.Stderr
+
+// addLogFlags registers the logging flags. The returned function installs
+// the logger they describe as slog's default and must be called after the
+// flags are parsed.
+func addLogFlags(fs *flag.FlagSet) func() error {
+	level := fs.String("log-level", "info", "log messages of at least this `level`: debug, info, warn or error")
+	format := fs.String("log-format", "text", "log `format`: text or json")
+	return func() error {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(*level)); err != nil {
+			return fmt.Errorf("invalid -log-level %q", *level)
+		}
+		opts := &slog.HandlerOptions{Level: l}
+		var h slog.Handler
+		switch *format {
+		case "text":
+			h = slog.NewTextHandler(logOutput, opts)
+		case "json":
+			h = slog.NewJSONHandler(logOutput, opts)
+		default:
+			return fmt.Errorf("invalid -log-format %q", *format)
+		}
+		slog.SetDefault(slog.New(h))
+		return nil
+	}
+}
+
+// checkAttrs returns the attributes identifying h in log messages.
+func checkAttrs(h HealthCheck) []any {
+	return []any{"name", h.ID(), "url", h.URL}
+}
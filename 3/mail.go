@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/textproto"
+	"slices"
+	"strings"
+)
+
+// mailChecker is healthy if a mail server greets with a positive banner,
+// e.g. for smtp://mx.example.com or imaps://mail.example.com. With
+// StartTLS the connection is upgraded to TLS, which must succeed too.
+type mailChecker struct {
+	h        HealthCheck
+	protocol string // smtp, imap or pop3
+	implicit bool   // TLS from the start, e.g. smtps
+	host     string
+	addr     string
+}
+
+// mailPorts are the default ports of the mail URL schemes.
+var mailPorts = map[string]string{
+	"smtp": "25", "smtps": "465", "submission": "587",
+	"imap": "143", "imaps": "993",
+	"pop3": "110", "pop3s": "995",
+}
+
+func init() {
+	for scheme := range mailPorts {
+		registerChecker(scheme, newMailChecker)
+	}
+}
+
+func newMailChecker(h HealthCheck) (Checker, error) {
+	u, err := parseURL(h, "smtp", "smtps", "submission", "imap", "imaps", "pop3", "pop3s")
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = mailPorts[u.Scheme]
+	}
+	protocol := strings.TrimSuffix(u.Scheme, "s")
+	if u.Scheme == "submission" {
+		protocol = "smtp"
+	}
+	implicit := strings.HasSuffix(u.Scheme, "s")
+	if implicit && h.StartTLS {
+		return nil, fmt.Errorf("StartTLS can't be used with %s, which uses TLS from the start", u.Scheme)
+	}
+	return mailChecker{h: h, protocol: protocol, implicit: implicit, host: u.Hostname(), addr: net.JoinHostPort(u.Hostname(), port)}, nil
+}
+
+func (c mailChecker) Check(ctx context.Context) Result {
+	conn, err := c.h.dialContext(ctx, c.addr)
+	if err != nil {
+		return Result{Err: err}
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if c.implicit {
+		tc := tls.Client(conn, c.h.tlsConfig(c.host))
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return Result{Err: err}
+		}
+		conn = tc
+	}
+	var banner string
+	switch c.protocol {
+	case "smtp":
+		banner, err = c.checkSMTP(conn)
+	default:
+		banner, err = c.checkIMAPOrPOP3(conn)
+	}
+	return Result{Healthy: err == nil, Err: err, Output: banner}
+}
+
+// checkSMTP expects the 220 greeting, says EHLO and, with StartTLS,
+// upgrades the connection.
+func (c mailChecker) checkSMTP(conn net.Conn) (string, error) {
+	tp := textproto.NewConn(conn)
+	_, banner, err := tp.ReadResponse(220)
+	if err != nil {
+		return banner, fmt.Errorf("greeting: %v", err)
+	}
+	if err := tp.PrintfLine("EHLO localhost"); err != nil {
+		return banner, err
+	}
+	_, ext, err := tp.ReadResponse(250)
+	if err != nil {
+		return banner, fmt.Errorf("EHLO: %v", err)
+	}
+	if c.h.StartTLS {
+		if !slices.ContainsFunc(strings.Split(ext, "\n"), func(l string) bool { return strings.EqualFold(l, "STARTTLS") }) {
+			return banner, fmt.Errorf("server doesn't offer STARTTLS")
+		}
+		if err := tp.PrintfLine("STARTTLS"); err != nil {
+			return banner, err
+		}
+		if _, _, err := tp.ReadResponse(220); err != nil {
+			return banner, fmt.Errorf("STARTTLS: %v", err)
+		}
+		tc := tls.Client(conn, c.h.tlsConfig(c.host))
+		if err := tc.Handshake(); err != nil {
+			return banner, fmt.Errorf("STARTTLS: %v", err)
+		}
+		tp = textproto.NewConn(tc)
+		if err := tp.PrintfLine("EHLO localhost"); err != nil {
+			return banner, err
+		}
+		if _, _, err := tp.ReadResponse(250); err != nil {
+			return banner, fmt.Errorf("EHLO after STARTTLS: %v", err)
+		}
+	}
+	tp.PrintfLine("QUIT")
+	return banner, nil
+}
+
+// checkIMAPOrPOP3 expects an OK greeting and, with StartTLS, upgrades the
+// connection.
+func (c mailChecker) checkIMAPOrPOP3(conn net.Conn) (string, error) {
+	tp := textproto.NewConn(conn)
+	ok := "+OK"
+	startTLS, logout := "STLS", "QUIT"
+	if c.protocol == "imap" {
+		ok = "* OK"
+		startTLS, logout = "a1 STARTTLS", "a2 LOGOUT"
+	}
+	banner, err := tp.ReadLine()
+	if err != nil {
+		return "", fmt.Errorf("greeting: %v", err)
+	}
+	if !strings.HasPrefix(banner, ok) && !(c.protocol == "imap" && strings.HasPrefix(banner, "* PREAUTH")) {
+		return banner, fmt.Errorf("greeting %q doesn't start with %q", banner, ok)
+	}
+	if c.h.StartTLS {
+		if err := tp.PrintfLine("%s", startTLS); err != nil {
+			return banner, err
+		}
+		// IMAP servers may send untagged responses first.
+		want := strings.Replace(ok, "*", "a1", 1)
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return banner, fmt.Errorf("%s: %v", startTLS, err)
+			}
+			if strings.HasPrefix(line, "* ") {
+				continue
+			}
+			if !strings.HasPrefix(line, want) {
+				return banner, fmt.Errorf("%s: got %q", startTLS, line)
+			}
+			break
+		}
+		tc := tls.Client(conn, c.h.tlsConfig(c.host))
+		if err := tc.Handshake(); err != nil {
+			return banner, fmt.Errorf("%s: %v", startTLS, err)
+		}
+		tp = textproto.NewConn(tc)
+	}
+	tp.PrintfLine("%s", logout)
+	return banner, nil
+}
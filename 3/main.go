@@ -1,53 +1,449 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 )
 
-type HealthCheck struct {
-	URL               string
-	ResponseTimeout   time.Duration // defaults to zero
-	HealthyStatusCode int
-}
+// exitUnhealthy is the exit status of one-shot runs with failing checks.
+const exitUnhealthy = 1
 
-func (h HealthCheck) Do() (bool, error) {
-	client := http.Client{Timeout: h.ResponseTimeout} // zero means no timeout
-	resp, err := client.Get(h.URL)
-	if err != nil {
-		return false, err
+// exitConfigErrors is the exit status of runs with -keep-going whose
+// config has problems.
+const exitConfigErrors = 3
+
+func main() {
+	subcommands := map[string]func([]string) error{
+		"report":         report,
+		"lint":           lint,
+		"validate":       validate,
+		"wait":           wait,
+		"schema":         schema,
+		"aggregate":      aggregate,
+		"assert-preview": assertPreview,
+		"simulate":       simulate,
+		"bench":          bench,
+		"drill":          timedCommand("drill"),
+		"snooze":         timedCommand("snooze"),
+		"config":         configCommand,
+		"enable":         bulkCommand("enable"),
+		"disable":        bulkCommand("disable"),
+		"silence":        bulkCommand("silence"),
+		"tag":            bulkCommand("tag"),
+		"untag":          bulkCommand("untag"),
 	}
-	if resp.StatusCode != h.HealthyStatusCode {
-		return false, err
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "x: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
 	}
-	return true, nil
-}
 
-func readConfig(filepath string) ([]HealthCheck, error) {
-	data, err := os.ReadFile(filepath)
-	if err != nil {
-		return nil, err
+	configFile := addConfigFlags(flag.CommandLine)
+	name := flag.String("name", "", "run only checks whose name (or URL) matches the glob `pattern`")
+	tags := flag.String("tags", "", "run only checks that have all of the comma separated `tags`")
+	labels := flag.String("labels", "", "comma separated key=value `labels` of this agent, e.g. region=eu-west, matched against the checks' RunOn")
+	flag.BoolVar(&keepGoing, "keep-going", false, fmt.Sprintf("run the valid checks of a config with problems, report the others as failing with their config error and, unless in daemon mode, exit with status %d", exitConfigErrors))
+	accessible := flag.Bool("accessible", false, "print PASS, FAIL or SKIP for every check without colors or latencies, for screen readers")
+	quiet := flag.Bool("quiet", false, "print only the failing checks and no summary, nothing if all are healthy, e.g. for cron")
+	verbose := flag.Bool("verbose", false, "print the details of every result, e.g. the timing of HTTP requests")
+	var urls []string
+	flag.Func("url", "run a check of `URL` instead of the config's, may be repeated", func(s string) error {
+		urls = append(urls, s)
+		return nil
+	})
+	expect := flag.Int("expect", http.StatusOK, "healthy status `code` of the HTTP checks of -url")
+	urlTimeout := flag.Duration("timeout", 0, fmt.Sprintf("response `timeout` of the checks of -url, zero means %v", defaultResponseTimeout))
+	retries := flag.Int("retries", 0, "in one-shot runs, run failing checks up to `n` more times, a second apart, before reporting them")
+	flag.Float64Var(&outbound.rate, "rate", 0, "run at most `n` checks per second (0 means no limit)")
+	flag.Float64Var(&outbound.hostRate, "host-rate", 0, "run at most `n` checks per second against a single host (0 means no limit)")
+	interval := flag.Duration("interval", 0, "run the checks every `duration` (daemon mode)")
+	jitter := flag.Float64("jitter", 0, "in daemon mode, randomize the first start and the waits between runs by this `fraction` of the interval, e.g. 0.1")
+	latencyWindow := flag.Int("latency-window", defaultLatencyWindow, "in daemon mode, compute the latency percentiles of the status page over the last `n` results of each check")
+	logEvery := flag.Int("log-every", 1, "in daemon mode, log only every `n`th consecutive healthy result")
+	historyFile := flag.String("history", "", "in daemon mode, append results to the history `file` (see the report subcommand), or to PostgreSQL at a postgres:// URL")
+	persist := flag.Bool("persist", false, "in daemon mode, write changes made via the API back to the config file")
+	apiToken := flag.String("api-token", os.Getenv("HEALTHCHECK_API_TOKEN"), "in daemon mode, accept changes via the API bearing this `token`, none are accepted without it")
+	apiExec := flag.Bool("api-exec", false, "in daemon mode, accept exec and docker checks via the API, which lets anyone with the -api-token run commands")
+	readOnly := flag.Bool("read-only", false, "reject changes via the API and don't run exec checks, for locked-down environments")
+	flag.BoolVar(&fipsMode, "fips", false, "refuse to run unless Go's FIPS 140-3 mode is on (GODEBUG=fips140=on), which limits TLS to approved algorithms")
+	sandboxed := flag.Bool("sandbox", false, "in daemon mode, restrict file access to the config and history with Landlock and don't run exec checks (Linux, CGO_ENABLED=0 builds)")
+	sandboxUser := flag.String("sandbox-user", "", "with -sandbox, switch to the `user` after opening the history and listening")
+	shadow := flag.Duration("shadow", 0, "in daemon mode, don't alert on failures of added or changed checks for `duration`")
+	watch := flag.Duration("watch", 0, "in daemon mode, reload the config file when it changes, checking every `duration`")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "in daemon mode, wait at most `duration` for running checks on SIGINT or SIGTERM")
+	agentCert := flag.String("agent-cert", "", "with -agent or -remote-write, present the client certificate in `file` to the aggregate server, see -agent-key")
+	agentKey := flag.String("agent-key", "", "private key `file` of -agent-cert")
+	agentCA := flag.String("agent-ca", "", "trust the CAs in `file` for the aggregate server's certificate instead of the system ones")
+	agentToken := flag.String("agent-token", os.Getenv("HEALTHCHECK_AGENT_TOKEN"), "with -agent or -remote-write, authenticate to the aggregate server with this bearer `token`")
+	agentExec := flag.Bool("agent-exec", false, "with -agent, run exec and docker checks the aggregate server serves, which lets whoever controls it run commands here")
+	agent := flag.String("agent", "", "run as an agent of the aggregate server at `URL`: fetch the checks from it into the -config file, reloading them every -watch (default 1m), and send it the results")
+	remoteWrite := flag.String("remote-write", "", "in daemon mode, send results to the aggregate server at `URL`")
+	site := flag.String("site", hostname(), "`name` of this checker's site for -remote-write, -influx and -graphite")
+	influx := flag.String("influx", "", "in daemon mode, write results to the InfluxDB write API at `URL`, e.g. http://localhost:8086/api/v2/write?org=ops&bucket=checks")
+	influxToken := flag.String("influx-token", os.Getenv("INFLUX_TOKEN"), "API `token` of -influx")
+	graphite := flag.String("graphite", "", "in daemon mode, write results to Graphite's plaintext protocol at `address`, e.g. localhost:2003")
+	graphitePrefix := flag.String("graphite-prefix", "healthcheck", "`prefix` of the Graphite metrics")
+	graphiteGzip := flag.Bool("graphite-gzip", false, "compress the results to -graphite with gzip, for relays that accept it")
+	var sinkOpts sinkOptions
+	flag.IntVar(&sinkOpts.batch, "sink-batch", 1000, "write at most `n` results at a time to -influx and -graphite")
+	flag.DurationVar(&sinkOpts.flush, "sink-flush", 10*time.Second, "write results to -influx and -graphite every `duration`")
+	flag.IntVar(&sinkOpts.retries, "sink-retries", 3, "retry failed writes to -influx and -graphite `n` times before dropping the results")
+	listen := flag.String("listen", "", "in daemon mode, serve a status page on `address` (e.g. :9090)")
+	kvExport := flag.String("kv-export", "", "in daemon mode, export state changes to a key-value `store`: consul or etcd")
+	kvAddr := flag.String("kv-addr", "http://127.0.0.1:8500", "`URL` of the key-value store's HTTP API")
+	kvPrefix := flag.String("kv-prefix", "healthcheck/", "`prefix` of the exported keys")
+	audit := flag.String("audit", "", "in daemon mode, export state changes and changes of the config for a SIEM to a `file` or syslog (udp://host:514, tcp://host:514 or unix:///dev/log)")
+	auditFormat := flag.String("audit-format", "cef", "`format` of -audit: cef or ocsf (JSON)")
+	otlp := flag.String("otlp", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "in daemon mode, export traces of the runs and checks to the OpenTelemetry collector at `URL` (OTLP over HTTP, e.g. http://localhost:4318)")
+	k8sDiscover := flag.String("k8s-discover", "", "in daemon mode, check the Kubernetes `kind` of objects matching -k8s-selector too: services or ingresses")
+	k8sAPI := flag.String("k8s-api", "", "`URL` of the Kubernetes API, e.g. of kubectl proxy (default the API of the cluster the checker runs in)")
+	k8sNamespace := flag.String("k8s-namespace", "", "discover objects in the `namespace` only (default all)")
+	k8sSelector := flag.String("k8s-selector", "", "discover objects matching the label `selector`, e.g. app.kubernetes.io/part-of=shop")
+	k8sPath := flag.String("k8s-path", "/healthz", "health `path` of discovered objects without a "+k8sPathAnnotation+" annotation")
+	consulDiscover := flag.String("consul-discover", "", "in daemon mode, check the instances of the comma separated Consul `services` too, see -consul-addr")
+	consulTag := flag.String("consul-tag", "", "discover Consul service instances with this `tag` only")
+	srvDiscover := flag.String("srv-discover", "", "in daemon mode, check the targets of the comma separated DNS SRV `names` too, e.g. _http._tcp.api.example.com")
+	dockerDiscover := flag.String("docker-discover", "", "in daemon mode, check the running containers with this `label` too, key or key=value, e.g. com.docker.compose.project=shop")
+	dockerHostFlag := flag.String("docker-host", "", "`URL` of the Docker daemon of docker checks and -docker-discover (default $DOCKER_HOST or unix:///var/run/docker.sock)")
+	discoverURL := flag.String("discover-url", defaultInstanceURL, "`template` of the URLs of Consul or SRV instances, with .Service, .ID, .Node, .Address, .Port and .Tags")
+	discoverRefresh := flag.Duration("discover-refresh", time.Minute, "look for new or removed services to check every `duration`")
+	consulTTL := flag.Duration("consul-ttl", 0, "in daemon mode, register checks as Consul TTL checks with this `ttl`")
+	consulAddr := flag.String("consul-addr", "http://127.0.0.1:8500", "`URL` of the Consul agent's HTTP API")
+	consulService := flag.String("consul-service", "", "attach the Consul TTL checks to the service with this `id`")
+	pagerDutyKey := flag.String("pagerduty-key", os.Getenv("PAGERDUTY_ROUTING_KEY"), "in daemon mode, trigger and resolve PagerDuty incidents of unhealthy checks with this Events API v2 routing `key` (checks can override it with Notify.PagerDutyKey)")
+	pagerDutyURL := flag.String("pagerduty-url", pagerDutyURL, "`URL` of the PagerDuty Events API v2")
+	opsgenieKey := flag.String("opsgenie-key", os.Getenv("OPSGENIE_API_KEY"), "in daemon mode, create and close Opsgenie alerts of unhealthy checks with this API `key` (checks can override it with Notify.OpsgenieKey)")
+	opsgenieURL := flag.String("opsgenie-url", opsgenieURL, "`URL` of the Opsgenie API, e.g. https://api.eu.opsgenie.com")
+	chaos := flag.Duration("chaos", 0, "in daemon mode, verify every `duration`, e.g. 168h, that alerts are delivered and resolved by failing a builtin check named chaos until all notifiers were alerted")
+	tui := flag.Bool("tui", false, "in daemon mode, show a live table of the checks on the terminal, with the latest logs below it")
+	output := flag.String("output", "text", "`format` of fatal errors and of the failures of one-shot runs: text, or json for JSON lines on stderr; or of the report of one-shot runs on stdout: csv or junit for JUnit XML")
+	setupLog := addLogFlags(flag.CommandLine)
+	flag.Parse()
+	switch *output {
+	case "text":
+	case "json":
+		jsonErrors = true
+	case "csv", "junit":
+		if *interval > 0 {
+			fatal(errUsage, fmt.Errorf("-output %s is only for one-shot runs", *output))
+		}
+	default:
+		fatal(errUsage, fmt.Errorf("invalid -output %q", *output))
 	}
-	var hs []HealthCheck
-	if err := json.Unmarshal(data, &hs); err != nil {
-		return nil, err
+	if *quiet && *verbose {
+		fatal(errUsage, errors.New("-quiet and -verbose can't be used together"))
+	}
+	tuiLogs := &tuiLog{}
+	if *tui {
+		if *interval <= 0 {
+			fatal(errUsage, errors.New("-tui needs -interval"))
+		}
+		logOutput = tuiLogs
+	}
+	if err := setupLog(); err != nil {
+		fatal(errUsage, err)
 	}
-	return hs, nil
-}
 
-func main() {
-	healthChecks, err := readConfig("healthchecks.json")
+	if *readOnly && *persist {
+		fatal(errUsage, errors.New("-read-only and -persist can't be used together"))
+	}
+	if *apiExec && *apiToken == "" {
+		fatal(errUsage, errors.New("-api-exec needs -api-token"))
+	}
+	if fipsMode {
+		if err := checkFIPS(); err != nil {
+			fatal(errRuntime, err)
+		}
+	}
+	if len(urls) > 0 && (*interval > 0 || *agent != "") {
+		fatal(errUsage, errors.New("-url is only for one-shot runs"))
+	}
+	agentRT, err := agentTransport(*agentCert, *agentKey, *agentCA, *agentToken)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "x: %v\n", err)
-		os.Exit(1)
+		fatal(errUsage, err)
+	}
+	agentClient.Transport = agentRT
+	if *agentToken != "" && (strings.HasPrefix(*agent, "http:") || strings.HasPrefix(*remoteWrite, "http:")) {
+		slog.Warn("sending the agent token in the clear, use HTTPS")
+	}
+	if *agent != "" {
+		if *persist || strings.Contains(*configFile, ",") {
+			fatal(errUsage, errors.New("-agent needs a single config file and no -persist"))
+		}
+		// Else anyone on the way could serve the agent checks.
+		if !strings.HasPrefix(*agent, "https:") && *agentToken == "" {
+			fatal(errUsage, errors.New("-agent needs an https:// URL or -agent-token"))
+		}
+		if _, err := fetchChecks(*agent, *configFile); err != nil {
+			if _, serr := os.Stat(*configFile); serr != nil {
+				fatal(errRuntime, fmt.Errorf("-agent: %v", err))
+			}
+			slog.Warn("can't fetch checks from coordinator, using the last ones", "coordinator", *agent, "err", err)
+		}
+	}
+	var files []string
+	if len(urls) == 0 {
+		if files, err = configFiles(*configFile); err != nil {
+			fatal(errRuntime, err)
+		}
+	}
+	if *persist && len(files) > 1 {
+		fatal(errUsage, errors.New("-persist needs a single config file"))
+	}
+	if *sandboxUser != "" && !*sandboxed {
+		fatal(errUsage, errors.New("-sandbox-user needs -sandbox"))
+	}
+	switch {
+	case *readOnly:
+		execDisabled = "-read-only"
+	case *sandboxed:
+		execDisabled = "-sandbox"
+	case *agent != "" && !*agentExec:
+		execDisabled = "-agent without -agent-exec"
+	}
+	dockerHost = *dockerHostFlag
+	var healthChecks []HealthCheck
+	if len(urls) > 0 {
+		healthChecks, err = adHocChecks(urls, *expect, *urlTimeout)
+	} else {
+		healthChecks, err = readConfigs(*configFile)
+	}
+	if err != nil {
+		kind := errConfig
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+			kind = errRuntime
+		}
+		fatal(kind, err)
+	}
+
+	if *jitter < 0 || *jitter >= 1 {
+		fatal(errUsage, errors.New("-jitter must be at least 0 and less than 1"))
+	}
+
+	filter := newCheckFilter(*name, *tags)
+	if filter.labels, err = parseLabels(*labels); err != nil {
+		fatal(errUsage, fmt.Errorf("-labels: %v", err))
+	}
+
+	if *interval <= 0 {
+		slog.Debug("running checks", "run_id", runID(time.Now()))
+		start := time.Now()
+		checks := filter.filter(healthChecks)
+		results := runAll(healthChecks, checks, *retries)
+		var failing int
+		switch *output {
+		case "csv":
+			failing, err = writeCSV(os.Stdout, checks, results)
+		case "junit":
+			failing, err = writeJUnit(os.Stdout, checks, results, start, time.Since(start))
+		default:
+			opts := printOptions{accessible: *accessible, color: colorTerminal(), quiet: *quiet, verbose: *verbose}
+			failing = printResults(os.Stdout, checks, results, opts, time.Since(start))
+		}
+		if err != nil {
+			fatal(errRuntime, err)
+		}
+		if slices.ContainsFunc(checks, func(h HealthCheck) bool { return h.configErr != "" }) {
+			os.Exit(exitConfigErrors)
+		}
+		if failing > 0 {
+			os.Exit(exitUnhealthy)
+		}
+		return
+	}
+
+	var hist store
+	if *historyFile != "" {
+		hist, err = openStore(*historyFile, true)
+		if err != nil {
+			fatal(errRuntime, err)
+		}
+	}
+	d := newDaemon(*configFile, healthChecks, *logEvery, hist)
+	if isHistoryFile(*historyFile) {
+		d.historyFile = *historyFile
+	}
+	d.persist = *persist
+	d.readOnly = *readOnly
+	d.apiToken = *apiToken
+	d.apiExec = *apiExec
+	d.shadow = *shadow
+	d.watch = *watch
+	d.jitter = *jitter
+	d.latencies = max(*latencyWindow, 1)
+	d.filter = filter
+	if *remoteWrite != "" {
+		d.remote = newRemoteWriter(*remoteWrite, *site, agentRT)
+	}
+	if *agent != "" {
+		d.coordinator = *agent
+		if d.watch <= 0 {
+			d.watch = time.Minute
+		}
+		if d.remote == nil {
+			d.remote = newRemoteWriter(*agent, *site, agentRT)
+		}
+	}
+	if d.remote != nil && hist != nil {
+		go func() {
+			if err := d.remote.resume(hist); err != nil {
+				slog.Warn("can't resend results the aggregate server is missing", "err", err)
+			}
+		}()
+	}
+	if *influx != "" {
+		d.sinks = append(d.sinks, newSink("influxdb", influxWriter(*influx, *influxToken, *site), sinkOpts))
+	}
+	if *k8sDiscover != "" {
+		k, err := newK8sDiscovery(*k8sAPI, *k8sDiscover, *k8sNamespace, *k8sSelector, *k8sPath)
+		if err != nil {
+			fatal(errUsage, fmt.Errorf("-k8s-discover: %v", err))
+		}
+		d.discoverers = append(d.discoverers, k)
+		d.refresh = *discoverRefresh
+	}
+	if *dockerDiscover != "" {
+		docker, err := newDockerClient(dockerHost)
+		if err != nil {
+			fatal(errUsage, fmt.Errorf("-docker-host: %v", err))
+		}
+		d.discoverers = append(d.discoverers, &dockerDiscovery{docker: docker, label: *dockerDiscover})
+		d.refresh = *discoverRefresh
+	}
+	if *consulDiscover != "" || *srvDiscover != "" {
+		t, err := parseInstanceURL(*discoverURL)
+		if err != nil {
+			fatal(errUsage, fmt.Errorf("-discover-url: %v", err))
+		}
+		if *consulDiscover != "" {
+			d.discoverers = append(d.discoverers, &consulDiscovery{addr: *consulAddr, services: splitList(*consulDiscover), tag: *consulTag, url: t, client: &http.Client{Timeout: 30 * time.Second}})
+		}
+		if *srvDiscover != "" {
+			d.discoverers = append(d.discoverers, &srvDiscovery{names: splitList(*srvDiscover), url: t})
+		}
+		d.refresh = *discoverRefresh
+	}
+	if *graphite != "" {
+		d.sinks = append(d.sinks, newSink("graphite", graphiteWriter(*graphite, *graphitePrefix, *site, *graphiteGzip), sinkOpts))
+	}
+	if *consulTTL > 0 {
+		d.consul = newConsulAgent(*consulAddr, *consulTTL, *consulService)
+	}
+	if *kvExport != "" {
+		d.kv, err = newKVStore(*kvExport, *kvAddr)
+		if err != nil {
+			fatal(errRuntime, err)
+		}
+		d.kvPrefix = *kvPrefix
+	}
+	if *audit != "" {
+		d.audit, err = openAuditor(*audit, *auditFormat, *site)
+		if err != nil {
+			fatal(errRuntime, err)
+		}
+		defer d.audit.Close()
+	}
+
+	if *otlp != "" {
+		d.tracer = newTracer(*otlp, *site)
+	}
+
+	// Checks may have their own keys, so a notifier is used if any of its
+	// keys is set at start.
+	d.notifiers = make(map[string]notifier)
+	if *pagerDutyKey != "" || slices.ContainsFunc(healthChecks, func(h HealthCheck) bool { return h.Notify != nil && h.Notify.PagerDutyKey != "" }) {
+		d.notifiers["pagerduty"] = pagerDuty{url: *pagerDutyURL, key: *pagerDutyKey, client: notifyClient}
+	}
+	if *opsgenieKey != "" || slices.ContainsFunc(healthChecks, func(h HealthCheck) bool { return h.Notify != nil && h.Notify.OpsgenieKey != "" }) {
+		d.notifiers["opsgenie"] = opsgenie{url: *opsgenieURL, key: *opsgenieKey, client: notifyClient}
+	}
+	if *chaos > 0 {
+		if len(d.notifiers) == 0 {
+			fatal(errUsage, errors.New("-chaos needs a notifier, e.g. -pagerduty-key"))
+		}
+		d.chaos = *chaos
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Addr: *listen, Handler: d.handler()}
+	var ln net.Listener
+	if *listen != "" {
+		// Listen before entering the sandbox, which may drop the privileges
+		// needed for ports below 1024.
+		if ln, err = net.Listen("tcp", *listen); err != nil {
+			fatal(errRuntime, err)
+		}
+	}
+	if *sandboxed {
+		if err := enterSandbox(*sandboxUser, files, d.historyFile, *persist); err != nil {
+			fatal(errRuntime, fmt.Errorf("-sandbox: %v", err))
+		}
+		slog.Info("entered sandbox")
+	}
+	if ln != nil {
+		go func() {
+			if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				slog.Error("can't serve status page", "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		d.run(ctx, *interval)
+		close(done)
+	}()
+	tuiDone := make(chan struct{})
+	go func() {
+		if *tui {
+			d.runTUI(ctx, os.Stdout, tuiLogs)
+		}
+		close(tuiDone)
+	}()
+
+	<-ctx.Done()
+	// Restore the terminal before logging about the shutdown.
+	<-tuiDone
+	stop() // a second signal kills us right away
+	slog.Info("shutting down")
+	timeout := time.After(*shutdownTimeout)
+	select {
+	case <-done:
+	case <-timeout:
+		slog.Warn("running checks didn't finish in time", "timeout", *shutdownTimeout)
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+	if d.remote != nil {
+		d.remote.Close()
+	}
+	for _, s := range d.sinks {
+		s.Close()
+	}
+	if d.tracer != nil {
+		d.tracer.Close()
 	}
-	for _, h := range healthChecks {
-		ok, err := h.Do()
-		if !ok {
-			fmt.Printf("%s is unhealthy (%v)\n", h.URL, err)
+	if hist != nil {
+		if err := hist.Close(); err != nil {
+			slog.Error("can't close history", "err", err)
 		}
 	}
 }
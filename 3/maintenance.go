@@ -0,0 +1,28 @@
+package main
+
+import "time"
+
+// window is a maintenance window. Checks still run during a window but
+// their failures aren't alerted on and don't count in reports.
+type window struct {
+	Start  time.Time // RFC 3339, e.g. 2025-06-01T22:00:00Z
+	End    time.Time
+	Reason string `json:",omitempty"` // e.g. planned deploy
+}
+
+func (w window) contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+// maintenance returns the maintenance window of h, its own or one from the
+// config, that contains t.
+func (h HealthCheck) maintenance(t time.Time) (window, bool) {
+	for _, ws := range [][]window{h.Maintenance, h.globalMaintenance} {
+		for _, w := range ws {
+			if w.contains(t) {
+				return w, true
+			}
+		}
+	}
+	return window{}, false
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// phaseBuckets are the upper bounds in seconds of the buckets of the HTTP
+// phase histograms, Prometheus' defaults.
+var phaseBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram is a Prometheus histogram of durations.
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative, the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(phaseBuckets)+1)
+	}
+	s := d.Seconds()
+	i, _ := slices.BinarySearch(phaseBuckets, s)
+	h.counts[i]++
+	h.sum += s
+	h.count++
+}
+
+// phaseMetrics are histograms of the httpTiming phases per check, exposed
+// on /metrics in the Prometheus text format.
+type phaseMetrics struct {
+	mu sync.Mutex
+	m  map[[2]string]*histogram // by check ID and phase
+}
+
+func (m *phaseMetrics) observe(id string, t *httpTiming) {
+	if t == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.m == nil {
+		m.m = make(map[[2]string]*histogram)
+	}
+	for _, p := range t.phases() {
+		if p.d == 0 {
+			continue // didn't happen, e.g. TLS of http:// URLs
+		}
+		key := [2]string{id, p.name}
+		h := m.m[key]
+		if h == nil {
+			h = &histogram{}
+			m.m[key] = h
+		}
+		h.observe(p.d)
+	}
+}
+
+func (m *phaseMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP healthcheck_http_phase_seconds Time HTTP checks spent in DNS, connect, TLS, TTFB and transfer.")
+	fmt.Fprintln(w, "# TYPE healthcheck_http_phase_seconds histogram")
+	keys := slices.SortedFunc(func(yield func([2]string) bool) {
+		for k := range m.m {
+			if !yield(k) {
+				return
+			}
+		}
+	}, func(a, b [2]string) int { return strings.Compare(a[0]+"\x00"+a[1], b[0]+"\x00"+b[1]) })
+	label := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for _, k := range keys {
+		h := m.m[k]
+		labels := fmt.Sprintf(`check="%s",phase="%s"`, label.Replace(k[0]), k[1])
+		var cum uint64
+		for i, le := range phaseBuckets {
+			cum += h.counts[i]
+			fmt.Fprintf(w, "healthcheck_http_phase_seconds_bucket{%s,le=\"%s\"} %d\n", labels, strconv.FormatFloat(le, 'g', -1, 64), cum)
+		}
+		fmt.Fprintf(w, "healthcheck_http_phase_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "healthcheck_http_phase_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(w, "healthcheck_http_phase_seconds_count{%s} %d\n", labels, h.count)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// configFiles expands spec, a comma separated list of config files and
+// directories, into the files to read. Directories contribute their *.json
+// files.
+func configFiles(spec string) ([]string, error) {
+	var files []string
+	for _, p := range strings.Split(spec, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(p, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: no *.json config files", p)
+		}
+		files = append(files, matches...) // sorted by Glob
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no config file")
+	}
+	return files, nil
+}
+
+// configNamespace returns the namespace of the checks of a config file, its
+// name without the extension, e.g. shop for conf.d/shop.json.
+func configNamespace(file string) string {
+	return strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+}
+
+// readConfigs reads the configs of spec, see configFiles. If there are
+// several, the IDs of their checks are prefixed with the namespace of their
+// config, e.g. shop/api, and so are their DependsOn, which can only refer
+// to checks of the same config.
+func readConfigs(spec string) ([]HealthCheck, error) {
+	files, err := configFiles(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 1 {
+		return readConfig(files[0])
+	}
+	var out []HealthCheck
+	namespaces := make(map[string]string)
+	for _, file := range files {
+		ns := configNamespace(file)
+		if other, ok := namespaces[ns]; ok {
+			return nil, fmt.Errorf("%s and %s have the same namespace %s, rename one", other, file, ns)
+		}
+		namespaces[ns] = file
+		hs, err := readConfig(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range hs {
+			h.namespace = ns
+			deps := make([]string, len(h.DependsOn))
+			for i, id := range h.DependsOn {
+				deps[i] = ns + "/" + id
+			}
+			if h.DependsOn != nil {
+				h.DependsOn = deps
+			}
+			out = append(out, h)
+		}
+	}
+	return out, nil
+}
+
+// namespaces returns the namespaces of hs in order of appearance.
+func namespaces(hs []HealthCheck) []string {
+	var out []string
+	for _, h := range hs {
+		if !slices.Contains(out, h.namespace) {
+			out = append(out, h.namespace)
+		}
+	}
+	return out
+}
+
+// runAll runs checks, of all, in parallel per namespace, each namespace's
+// checks one after the other as if they were run alone. Paused checks
+// aren't run, failing ones are run up to retries more times.
+func runAll(all, checks []HealthCheck, retries int) []runResult {
+	results := make([]runResult, len(checks))
+	newHostRound()
+	var wg sync.WaitGroup
+	for _, ns := range namespaces(checks) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run := newRunner(slices.DeleteFunc(slices.Clone(all), func(h HealthCheck) bool { return h.namespace != ns }))
+			run.retries = retries
+			for i, h := range checks {
+				if h.namespace == ns && !h.Paused {
+					results[i] = run.run(h)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Capability flags of the MySQL client/server protocol.
+const (
+	mysqlLongPassword     = 0x1
+	mysqlConnectWithDB    = 0x8
+	mysqlProtocol41       = 0x200
+	mysqlSSL              = 0x800
+	mysqlSecureConnection = 0x8000
+	mysqlPluginAuth       = 0x80000
+)
+
+// mysqlConn speaks just enough of the MySQL client/server protocol to log
+// in and run a query.
+type mysqlConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	seq  byte
+}
+
+func (my *mysqlConn) readPacket() ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(my.br, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := int(hdr[0]) | int(hdr[1])<<8 | int(hdr[2])<<16
+	my.seq = hdr[3] + 1
+	p := make([]byte, n)
+	if _, err := io.ReadFull(my.br, p); err != nil {
+		return nil, err
+	}
+	if len(p) > 0 && p[0] == 0xff {
+		return p, mysqlError(p)
+	}
+	return p, nil
+}
+
+func (my *mysqlConn) writePacket(p []byte) error {
+	hdr := []byte{byte(len(p)), byte(len(p) >> 8), byte(len(p) >> 16), my.seq}
+	my.seq++
+	_, err := my.conn.Write(append(hdr, p...))
+	return err
+}
+
+// mysqlError parses an ERR packet.
+func mysqlError(p []byte) error {
+	e := &dbError{}
+	if len(p) >= 3 {
+		e.Code = fmt.Sprint(binary.LittleEndian.Uint16(p[1:]))
+		msg := p[3:]
+		if len(msg) > 0 && msg[0] == '#' && len(msg) >= 6 {
+			msg = msg[6:] // SQL state
+		}
+		e.Message = string(msg)
+	}
+	return e
+}
+
+// pingMySQL logs in, using TLS as the tls parameter of the DSN says, and
+// runs SELECT 1.
+func (c *dbChecker) pingMySQL(conn net.Conn) (time.Duration, error) {
+	start := time.Now()
+	my := &mysqlConn{conn: conn, br: bufio.NewReader(conn)}
+	greeting, err := my.readPacket()
+	if err != nil {
+		return 0, err
+	}
+	if len(greeting) < 1 || greeting[0] != 10 {
+		return 0, errors.New("unsupported handshake, is this MySQL?")
+	}
+	// Protocol version, server version, connection ID, the first 8 bytes of
+	// the scramble, a filler, the lower capabilities, character set,
+	// status, upper capabilities, scramble length and 10 reserved bytes.
+	rest := greeting[1:]
+	end := bytes.IndexByte(rest, 0)
+	if end < 0 || len(rest) < end+1+4+8+1+2+1+2+2+1+10 {
+		return 0, errors.New("short handshake")
+	}
+	rest = rest[end+1+4:]
+	scramble := append([]byte(nil), rest[:8]...)
+	rest = rest[8+1:]
+	caps := uint32(binary.LittleEndian.Uint16(rest))
+	rest = rest[2+1+2:]
+	caps |= uint32(binary.LittleEndian.Uint16(rest)) << 16
+	rest = rest[2+1+10:]
+	plugin := "mysql_native_password"
+	if n := bytes.IndexByte(rest, 0); n >= 0 {
+		scramble = append(scramble, rest[:n]...)
+		if p := rest[n+1:]; len(p) > 0 {
+			plugin = strings.TrimRight(string(p), "\x00")
+		}
+	}
+
+	flags := uint32(mysqlLongPassword | mysqlProtocol41 | mysqlSecureConnection | mysqlPluginAuth)
+	db := strings.TrimPrefix(c.url.Path, "/")
+	if db != "" {
+		flags |= mysqlConnectWithDB
+	}
+	tlsMode := c.url.Query().Get("tls")
+	secure := false
+	if tlsMode == "true" || tlsMode == "skip-verify" {
+		if caps&mysqlSSL == 0 {
+			return 0, errors.New("tls is set but the server doesn't support TLS")
+		}
+		flags |= mysqlSSL
+		// SSLRequest: the start of the handshake response.
+		req := binary.LittleEndian.AppendUint32(nil, flags)
+		req = binary.LittleEndian.AppendUint32(req, 1<<24)
+		req = append(req, 45) // utf8mb4
+		req = append(req, make([]byte, 23)...)
+		if err := my.writePacket(req); err != nil {
+			return 0, err
+		}
+		tc, err := c.upgradeTLS(conn, tlsMode == "true")
+		if err != nil {
+			return 0, err
+		}
+		my.conn, my.br = tc, bufio.NewReader(tc)
+		secure = true
+	}
+
+	user := c.url.User.Username()
+	password, _ := c.url.User.Password()
+	auth, err := mysqlAuth(plugin, password, scramble)
+	if err != nil {
+		return 0, err
+	}
+	resp := binary.LittleEndian.AppendUint32(nil, flags)
+	resp = binary.LittleEndian.AppendUint32(resp, 1<<24)
+	resp = append(resp, 45)
+	resp = append(resp, make([]byte, 23)...)
+	resp = append(resp, user+"\x00"...)
+	resp = append(resp, byte(len(auth)))
+	resp = append(resp, auth...)
+	if db != "" {
+		resp = append(resp, db+"\x00"...)
+	}
+	resp = append(resp, plugin+"\x00"...)
+	if err := my.writePacket(resp); err != nil {
+		return 0, err
+	}
+	if err := my.finishAuth(plugin, password, secure); err != nil {
+		return 0, err
+	}
+	login := time.Since(start)
+
+	my.seq = 0
+	if err := my.writePacket([]byte("\x03SELECT 1")); err != nil {
+		return login, err
+	}
+	if _, err := my.readPacket(); err != nil {
+		return login, err
+	}
+	my.seq = 0
+	my.writePacket([]byte{0x01}) // COM_QUIT
+	return login, nil
+}
+
+// finishAuth reads the server's answers to the login until it's accepted,
+// switching the authentication method if asked to.
+func (my *mysqlConn) finishAuth(plugin, password string, secure bool) error {
+	for {
+		p, err := my.readPacket()
+		if err != nil {
+			return err
+		}
+		switch {
+		case len(p) == 0:
+			return errors.New("empty packet during authentication")
+		case p[0] == 0x00: // OK
+			return nil
+		case p[0] == 0xfe: // AuthSwitchRequest
+			name, data, _ := bytes.Cut(p[1:], []byte{0})
+			plugin = string(name)
+			auth, err := mysqlAuth(plugin, password, bytes.TrimRight(data, "\x00"))
+			if err != nil {
+				return err
+			}
+			if err := my.writePacket(auth); err != nil {
+				return err
+			}
+		case p[0] == 0x01 && plugin == "caching_sha2_password" && len(p) == 2:
+			switch p[1] {
+			case 3: // fast authentication succeeded, OK follows
+			case 4: // full authentication
+				if !secure {
+					return errors.New("caching_sha2_password needs the password sent over TLS, set tls=true in the URL")
+				}
+				if err := my.writePacket([]byte(password + "\x00")); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unexpected caching_sha2_password state %d", p[1])
+			}
+		default:
+			return fmt.Errorf("unexpected packet 0x%x during authentication", p[0])
+		}
+	}
+}
+
+// mysqlAuth scrambles password with the server's random scramble as the
+// authentication plugin does.
+func mysqlAuth(plugin, password string, scramble []byte) ([]byte, error) {
+	if password == "" {
+		return nil, nil
+	}
+	switch plugin {
+	case "mysql_native_password":
+		// SHA1(password) XOR SHA1(scramble + SHA1(SHA1(password)))
+		h1 := sha1.Sum([]byte(password))
+		h2 := sha1.Sum(h1[:])
+		h3 := sha1.Sum(append(append([]byte(nil), scramble[:min(len(scramble), 20)]...), h2[:]...))
+		for i := range h1 {
+			h1[i] ^= h3[i]
+		}
+		return h1[:], nil
+	case "caching_sha2_password":
+		// SHA256(password) XOR SHA256(SHA256(SHA256(password)) + scramble)
+		h1 := sha256.Sum256([]byte(password))
+		h2 := sha256.Sum256(h1[:])
+		h3 := sha256.Sum256(append(h2[:], scramble[:min(len(scramble), 20)]...))
+		for i := range h1 {
+			h1[i] ^= h3[i]
+		}
+		return h1[:], nil
+	}
+	return nil, fmt.Errorf("unsupported authentication plugin %q", plugin)
+}
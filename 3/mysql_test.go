@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// mysqlScramble is the scramble of the test handshakes, bytes 1 to 20.
+var mysqlScramble = []byte("\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13\x14")
+
+// mysqlPacket returns payload as a packet with sequence number seq.
+func mysqlPacket(seq byte, payload string) []byte {
+	n := len(payload)
+	return append([]byte{byte(n), byte(n >> 8), byte(n >> 16), seq}, payload...)
+}
+
+// mysqlGreeting returns the initial handshake of a server with plugin.
+func mysqlGreeting(plugin string) string {
+	return "\x0a8.0.36\x00" +
+		"\x01\x00\x00\x00" + // connection ID
+		string(mysqlScramble[:8]) + "\x00" +
+		"\xff\xff" + // lower capabilities
+		"\x2d" + // utf8mb4
+		"\x02\x00" + // status
+		"\xff\x01" + // upper capabilities
+		"\x15" + strings.Repeat("\x00", 10) +
+		string(mysqlScramble[8:]) + "\x00" +
+		plugin + "\x00"
+}
+
+func TestMySQLAuth(t *testing.T) {
+	for _, tt := range []struct {
+		plugin, password string
+		want             string // hex
+		wantErr          bool
+	}{
+		// SHA1(password) XOR SHA1(scramble + SHA1(SHA1(password)))
+		{"mysql_native_password", "secret", "b32bb3a583e1340c0a1108d58b1be49781ad8c2f", false},
+		// SHA256(password) XOR SHA256(SHA256(SHA256(password)) + scramble)
+		{"caching_sha2_password", "secret", "746ebe205d56a0707acb3e796e834e0dd7b1d61743b26bd5202c7a623230c7c9", false},
+		{"mysql_native_password", "", "", false},
+		{"caching_sha2_password", "", "", false},
+		{"mysql_clear_password", "secret", "", true},
+	} {
+		got, err := mysqlAuth(tt.plugin, tt.password, mysqlScramble)
+		if (err != nil) != tt.wantErr || hex.EncodeToString(got) != tt.want {
+			t.Errorf("mysqlAuth(%q, %q) = %x, %v, want %s", tt.plugin, tt.password, got, err, tt.want)
+		}
+	}
+	// The scramble of auth switch requests may be NUL terminated, which
+	// the callers trim, or longer than the 20 bytes used.
+	long, _ := mysqlAuth("mysql_native_password", "secret", append(mysqlScramble, "extra"...))
+	if got := hex.EncodeToString(long); got != "b32bb3a583e1340c0a1108d58b1be49781ad8c2f" {
+		t.Errorf("mysqlAuth of a long scramble = %s", got)
+	}
+}
+
+func TestPingMySQL(t *testing.T) {
+	c, err := newDBChecker(HealthCheck{URL: "mysql://app:secret@db/shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		server  [][]byte
+		wantErr string
+	}{
+		{
+			name:   "native password",
+			server: [][]byte{mysqlPacket(0, mysqlGreeting("mysql_native_password")), mysqlPacket(2, "\x00\x00\x00\x02\x00\x00\x00"), mysqlPacket(1, "\x01")},
+		},
+		{
+			name:   "caching sha2 fast authentication",
+			server: [][]byte{mysqlPacket(0, mysqlGreeting("caching_sha2_password")), mysqlPacket(2, "\x01\x03"), mysqlPacket(3, "\x00\x00\x00\x02\x00\x00\x00"), mysqlPacket(1, "\x01")},
+		},
+		{
+			name:    "caching sha2 full authentication without TLS",
+			server:  [][]byte{mysqlPacket(0, mysqlGreeting("caching_sha2_password")), mysqlPacket(2, "\x01\x04")},
+			wantErr: "needs the password sent over TLS",
+		},
+		{
+			name:    "access denied",
+			server:  [][]byte{mysqlPacket(0, mysqlGreeting("mysql_native_password")), mysqlPacket(2, "\xff\x15\x04#28000Access denied for user 'app'")},
+			wantErr: "Access denied",
+		},
+		{
+			name:    "short error",
+			server:  [][]byte{mysqlPacket(0, mysqlGreeting("mysql_native_password")), mysqlPacket(2, "\xff")},
+			wantErr: " ()", // without code and message
+		},
+		{
+			name:    "empty packet",
+			server:  [][]byte{mysqlPacket(0, mysqlGreeting("mysql_native_password")), mysqlPacket(2, "")},
+			wantErr: "empty packet",
+		},
+		{
+			name:    "auth switch to an unknown plugin",
+			server:  [][]byte{mysqlPacket(0, mysqlGreeting("mysql_native_password")), mysqlPacket(2, "\xfe")},
+			wantErr: "unsupported authentication plugin",
+		},
+		{
+			name:    "not mysql",
+			server:  [][]byte{mysqlPacket(0, "\x09")},
+			wantErr: "unsupported handshake",
+		},
+		{
+			name:    "empty greeting",
+			server:  [][]byte{mysqlPacket(0, "")},
+			wantErr: "unsupported handshake",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &scriptedConn{r: bytes.NewReader(bytes.Join(tt.server, nil))}
+			_, err := c.(*dbChecker).pingMySQL(conn)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestPingMySQLHandshakeResponse checks the scramble sent in the
+// handshake response.
+func TestPingMySQLHandshakeResponse(t *testing.T) {
+	c, err := newDBChecker(HealthCheck{URL: "mysql://app:secret@db/shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := bytes.Join([][]byte{mysqlPacket(0, mysqlGreeting("mysql_native_password")), mysqlPacket(2, "\x00\x00\x00\x02\x00\x00\x00"), mysqlPacket(1, "\x01")}, nil)
+	conn := &scriptedConn{r: bytes.NewReader(server)}
+	if _, err := c.(*dbChecker).pingMySQL(conn); err != nil {
+		t.Fatal(err)
+	}
+	my := &mysqlConn{br: bufio.NewReader(&conn.written)}
+	resp, err := my.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, _ := hex.DecodeString("b32bb3a583e1340c0a1108d58b1be49781ad8c2f")
+	want := append([]byte("app\x00\x14"), auth...)
+	want = append(want, "shop\x00mysql_native_password\x00"...)
+	if len(resp) < 32 || !bytes.Equal(resp[32:], want) {
+		t.Errorf("handshake response %q, want the capabilities and %q", resp, want)
+	}
+}
+
+// TestPingMySQLTruncatedGreeting makes sure every truncation of the
+// greeting is an error, not a panic.
+func TestPingMySQLTruncatedGreeting(t *testing.T) {
+	c, err := newDBChecker(HealthCheck{URL: "mysql://app:secret@db/shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	greeting := mysqlGreeting("caching_sha2_password")
+	for n := range len(greeting) {
+		conn := &scriptedConn{r: bytes.NewReader(mysqlPacket(0, greeting[:n]))}
+		if _, err := c.(*dbChecker).pingMySQL(conn); err == nil {
+			t.Errorf("greeting of %d bytes: no error", n)
+		}
+	}
+}
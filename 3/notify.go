@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// notifyConfig routes the alerts of a check, overriding the keys given
+// with -pagerduty-key and -opsgenie-key. Keys are better set as ${VAR}.
+type notifyConfig struct {
+	PagerDutyKey string `json:",omitempty"` // routing key of a PagerDuty Events API v2 integration
+	OpsgenieKey  string `json:",omitempty"` // key of an Opsgenie API integration
+	OpsgenieTeam string `json:",omitempty"` // responder of the Opsgenie alerts
+}
+
+// notifier opens an incident in an incident management service when a
+// check becomes unhealthy and resolves it once the check recovers.
+// Incidents are identified by the check's ID, so sending the same alert
+// again doesn't open another one.
+type notifier interface {
+	trigger(h HealthCheck, err error) error
+	resolve(h HealthCheck) error
+}
+
+// Defaults of -pagerduty-url and -opsgenie-url and of the URL of channels.
+const (
+	pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	opsgenieURL  = "https://api.opsgenie.com"
+)
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// notify triggers the incidents of e on the channels of its route that
+// weren't notified yet, escalating as the outage goes on, or resolves them
+// once it's healthy. Checks without a route use d.notifiers. Failed
+// notifications are retried with the next result.
+func (d *daemon) notify(e *entry, ok bool, err error) {
+	d.mu.Lock()
+	send := make(map[string]notifier)
+	if ok {
+		maps.Copy(send, e.alerted)
+	} else {
+		want := d.notifiers
+		if e.check.route != nil {
+			want = e.check.route.notifiers(time.Since(e.failingSince))
+		}
+		for name, n := range want {
+			if _, done := e.alerted[name]; !done {
+				send[name] = n
+			}
+		}
+	}
+	escalated := !ok && len(e.alerted) > 0 && len(send) > 0
+	outage := time.Since(e.failingSince)
+	d.mu.Unlock()
+	if len(send) == 0 {
+		return // nothing new to tell
+	}
+
+	var errs []error
+	done := make(map[string]notifier)
+	for _, name := range slices.Sorted(maps.Keys(send)) {
+		n := send[name]
+		var nerr error
+		if ok {
+			nerr = n.resolve(e.check)
+		} else {
+			nerr = n.trigger(e.check, err)
+		}
+		if nerr != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name, nerr))
+			continue
+		}
+		done[name] = n
+	}
+	nerr := errors.Join(errs...)
+	d.report("notify", nerr)
+	if nerr != nil {
+		slog.Error("can't notify", append(checkAttrs(e.check), "err", nerr)...)
+	}
+	if escalated && len(done) > 0 {
+		slog.Warn("escalated", append(checkAttrs(e.check), "channels", slices.Sorted(maps.Keys(done)), "outage", outage.Round(time.Second))...)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e.alerted == nil {
+		e.alerted = make(map[string]notifier)
+	}
+	for name, n := range done {
+		if ok {
+			delete(e.alerted, name)
+		} else {
+			e.alerted[name] = n
+		}
+	}
+}
+
+// pagerDutySeverity maps the Severity of a check to PagerDuty's.
+func pagerDutySeverity(severity string) string {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return "critical"
+	case "warning":
+		return "warning"
+	case "info":
+		return "info"
+	}
+	return "error"
+}
+
+// opsgeniePriority maps the Severity of a check to an Opsgenie priority.
+func opsgeniePriority(severity string) string {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return "P1"
+	case "error", "major", "high":
+		return "P2"
+	case "warning":
+		return "P4"
+	case "info":
+		return "P5"
+	}
+	return "P3"
+}
+
+// pagerDuty sends events to the PagerDuty Events API v2.
+type pagerDuty struct {
+	url    string // e.g. https://events.pagerduty.com/v2/enqueue
+	key    string // default routing key
+	client *http.Client
+}
+
+func (p pagerDuty) routingKey(h HealthCheck) string {
+	if h.Notify != nil && h.Notify.PagerDutyKey != "" {
+		return h.Notify.PagerDutyKey
+	}
+	return p.key
+}
+
+func (p pagerDuty) trigger(h HealthCheck, err error) error {
+	key := p.routingKey(h)
+	if key == "" {
+		return nil
+	}
+	event := map[string]any{
+		"routing_key":  key,
+		"event_action": "trigger",
+		"dedup_key":    h.ID(),
+		"payload": map[string]any{
+			"summary":   truncate(fmt.Sprintf("%s is unhealthy: %v", h.ID(), err), 1024),
+			"source":    cmp.Or(h.URL, h.ID()),
+			"severity":  pagerDutySeverity(h.Severity),
+			"timestamp": time.Now().Format(time.RFC3339),
+			"group":     h.Owner,
+			"custom_details": map[string]any{
+				"error": fmt.Sprint(err),
+				"url":   h.URL,
+				"tags":  h.Tags,
+			},
+		},
+	}
+	if h.Runbook != "" {
+		event["links"] = []map[string]string{{"href": h.Runbook, "text": "Runbook"}}
+	}
+	return p.send(event)
+}
+
+func (p pagerDuty) resolve(h HealthCheck) error {
+	key := p.routingKey(h)
+	if key == "" {
+		return nil
+	}
+	return p.send(map[string]any{"routing_key": key, "event_action": "resolve", "dedup_key": h.ID()})
+}
+
+func (p pagerDuty) send(event map[string]any) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doAccepted(p.client, req)
+}
+
+// opsgenie creates and closes alerts with the Opsgenie Alert API.
+type opsgenie struct {
+	url    string // e.g. https://api.opsgenie.com, or api.eu.opsgenie.com
+	key    string // default API key
+	team   string // default responder
+	client *http.Client
+}
+
+func (o opsgenie) apiKey(h HealthCheck) string {
+	if h.Notify != nil && h.Notify.OpsgenieKey != "" {
+		return h.Notify.OpsgenieKey
+	}
+	return o.key
+}
+
+func (o opsgenie) trigger(h HealthCheck, err error) error {
+	key := o.apiKey(h)
+	if key == "" {
+		return nil
+	}
+	alert := map[string]any{
+		"message":     truncate(fmt.Sprintf("%s is unhealthy", h.ID()), 130),
+		"alias":       h.ID(),
+		"description": truncate(fmt.Sprint(err), 15000),
+		"priority":    opsgeniePriority(h.Severity),
+		"source":      "healthcheck",
+		"tags":        h.Tags,
+		"details":     map[string]string{"url": h.URL, "owner": h.Owner, "runbook": h.Runbook},
+	}
+	team := o.team
+	if h.Notify != nil && h.Notify.OpsgenieTeam != "" {
+		team = h.Notify.OpsgenieTeam
+	}
+	if team != "" {
+		alert["responders"] = []map[string]string{{"name": team, "type": "team"}}
+	}
+	return o.send(key, "/v2/alerts", alert)
+}
+
+func (o opsgenie) resolve(h HealthCheck) error {
+	key := o.apiKey(h)
+	if key == "" {
+		return nil
+	}
+	return o.send(key, "/v2/alerts/"+url.PathEscape(h.ID())+"/close?identifierType=alias",
+		map[string]any{"source": "healthcheck", "note": "The check is healthy again."})
+}
+
+func (o opsgenie) send(key, path string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(o.url, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "GenieKey "+key)
+	return doAccepted(o.client, req)
+}
+
+// slack posts messages to a Slack incoming webhook.
+type slack struct {
+	url    string
+	client *http.Client
+}
+
+func (s slack) trigger(h HealthCheck, err error) error {
+	text := fmt.Sprintf(":red_circle: *%s* is unhealthy: %v", h.ID(), err)
+	if h.Runbook != "" {
+		text += fmt.Sprintf(" (<%s|runbook>)", h.Runbook)
+	}
+	return s.send(text)
+}
+
+func (s slack) resolve(h HealthCheck) error {
+	return s.send(fmt.Sprintf(":large_green_circle: *%s* is healthy again", h.ID()))
+}
+
+func (s slack) send(text string) error {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doAccepted(s.client, req)
+}
+
+// doAccepted is like doRequest but accepts any 2xx status, as both APIs
+// answer 202 Accepted.
+func doAccepted(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// truncate shortens s to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// defaultMaxOffset is the clock offset NTP checks tolerate by default.
+const defaultMaxOffset = time.Second
+
+// ntpEpochOffset is the number of seconds from 1900, NTP's epoch, to 1970.
+const ntpEpochOffset = 2208988800
+
+// ntpChecker asks an NTP server, e.g. ntp://pool.ntp.org, for the time and
+// is unhealthy if this host's clock is off by more than MaxOffset. The
+// Output tells the offset, the round trip delay and the server's stratum.
+type ntpChecker struct {
+	h         HealthCheck
+	addr      string
+	maxOffset time.Duration
+}
+
+func init() {
+	registerChecker("ntp", newNTPChecker)
+}
+
+func newNTPChecker(h HealthCheck) (Checker, error) {
+	u, err := parseURL(h, "ntp")
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "123"
+	}
+	if h.MaxOffset < 0 {
+		return nil, errors.New("MaxOffset must not be negative")
+	}
+	maxOffset := time.Duration(h.MaxOffset)
+	if maxOffset == 0 {
+		maxOffset = defaultMaxOffset
+	}
+	return ntpChecker{h: h, addr: net.JoinHostPort(u.Hostname(), port), maxOffset: maxOffset}, nil
+}
+
+func (c ntpChecker) Check(ctx context.Context) Result {
+	ipNet, err := c.h.ipNetwork()
+	if err != nil {
+		return Result{Err: err}
+	}
+	d := net.Dialer{Resolver: c.h.lookup()}
+	conn, err := d.DialContext(ctx, "udp"+ipNet[len("ip"):], c.addr)
+	if err != nil {
+		return Result{Err: err}
+	}
+	defer conn.Close()
+	recordSource(ctx, conn)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	req := make([]byte, 48)
+	req[0] = 4<<3 | 3 // version 4, client mode
+	t1 := time.Now()
+	putNTPTime(req[40:], t1)
+	if _, err := conn.Write(req); err != nil {
+		return Result{Err: err}
+	}
+	resp := make([]byte, 128)
+	var n int
+	for {
+		if n, err = conn.Read(resp); err != nil {
+			if ctx.Err() != nil && errors.Is(err, net.ErrClosed) {
+				err = fmt.Errorf("no answer: %v", ctx.Err())
+			}
+			return Result{Err: err}
+		}
+		// Ignore stray datagrams that don't answer our request.
+		if n >= 48 && string(resp[24:32]) == string(req[40:48]) {
+			break
+		}
+	}
+	t4 := t1.Add(time.Since(t1))
+	resp = resp[:n]
+
+	leap, mode, stratum := resp[0]>>6, resp[0]&7, resp[1]
+	switch {
+	case mode != 4:
+		return Result{Err: fmt.Errorf("got an NTP packet of mode %d, want 4 (server)", mode)}
+	case stratum == 0:
+		return Result{Err: fmt.Errorf("kiss-o'-death %s", strings.TrimRight(string(resp[12:16]), "\x00"))}
+	case leap == 3 || stratum >= 16:
+		return Result{Err: errors.New("the server's clock isn't synchronized")}
+	}
+	t2, t3 := ntpTime(resp[32:]), ntpTime(resp[40:])
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	delay := t4.Sub(t1) - t3.Sub(t2)
+	out := fmt.Sprintf("offset %v, delay %v, stratum %d", offset.Round(time.Microsecond), delay.Round(time.Microsecond), stratum)
+	if offset.Abs() > c.maxOffset {
+		return Result{Err: fmt.Errorf("clock offset %v exceeds %v", offset.Round(time.Millisecond), c.maxOffset), Output: out}
+	}
+	return Result{Healthy: true, Output: out}
+}
+
+// putNTPTime writes t as a 64-bit NTP timestamp to b.
+func putNTPTime(b []byte, t time.Time) {
+	sec := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	binary.BigEndian.PutUint64(b, sec<<32|frac)
+}
+
+// ntpTime reads a 64-bit NTP timestamp from b.
+func ntpTime(b []byte) time.Time {
+	v := binary.BigEndian.Uint64(b)
+	sec, frac := int64(v>>32), int64(v&0xffffffff)
+	return time.Unix(sec-ntpEpochOffset, frac*1e9>>32)
+}
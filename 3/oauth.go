@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// authConfig makes HTTP and transaction checks send a bearer token they
+// get with the OAuth2 client credentials flow. Tokens are shared by the
+// checks with the same authConfig and got again shortly before they expire.
+type authConfig struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string   // better set as ${VAR}, see -secrets
+	Scopes       []string `json:",omitempty"`
+}
+
+// tokenExpiryMargin is how long before it expires a token is replaced.
+const tokenExpiryMargin = 30 * time.Second
+
+func (a *authConfig) validate() error {
+	var errs []error
+	if u, err := url.Parse(a.TokenURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("Auth.TokenURL %q isn't an http or https URL", a.TokenURL))
+	}
+	if a.ClientID == "" {
+		errs = append(errs, errors.New("missing Auth.ClientID"))
+	}
+	return errors.Join(errs...)
+}
+
+// key identifies the token of a.
+func (a *authConfig) key() string {
+	return strings.Join([]string{a.TokenURL, a.ClientID, a.ClientSecret, strings.Join(a.Scopes, " ")}, "\x00")
+}
+
+// token is a cached access token.
+type token struct {
+	mu      sync.Mutex // held while getting the token
+	value   string
+	expires time.Time // zero if the server didn't tell
+}
+
+var tokens = struct {
+	mu sync.Mutex
+	m  map[string]*token
+}{m: make(map[string]*token)}
+
+// authorize sets the Authorization header of req to a bearer token,
+// getting one if there's no valid token cached.
+func (a *authConfig) authorize(req *http.Request, transport http.RoundTripper) error {
+	tokens.mu.Lock()
+	t, ok := tokens.m[a.key()]
+	if !ok {
+		t = &token{}
+		tokens.m[a.key()] = t
+	}
+	tokens.mu.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.value == "" || (!t.expires.IsZero() && time.Now().After(t.expires.Add(-tokenExpiryMargin))) {
+		value, expires, err := a.fetchToken(req.Context(), transport)
+		if err != nil {
+			return fmt.Errorf("getting a token from %s: %v", a.TokenURL, err)
+		}
+		t.value, t.expires = value, expires
+	}
+	req.Header.Set("Authorization", "Bearer "+t.value)
+	return nil
+}
+
+// forget drops the cached token of a, e.g. after it was rejected, so the
+// next check gets a new one.
+func (a *authConfig) forget() {
+	tokens.mu.Lock()
+	t := tokens.m[a.key()]
+	tokens.mu.Unlock()
+	if t != nil {
+		t.mu.Lock()
+		t.value = ""
+		t.mu.Unlock()
+	}
+}
+
+// fetchToken requests a token from the token endpoint (RFC 6749, 4.4).
+func (a *authConfig) fetchToken(ctx context.Context, transport http.RoundTripper) (string, time.Time, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+	client := http.Client{Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	jsonErr := json.Unmarshal(data, &body)
+	switch {
+	case body.Error != "":
+		return "", time.Time{}, fmt.Errorf("%s: %s", body.Error, body.ErrorDescription)
+	case resp.StatusCode != http.StatusOK:
+		return "", time.Time{}, fmt.Errorf("got status %d", resp.StatusCode)
+	case jsonErr != nil:
+		return "", time.Time{}, fmt.Errorf("the response isn't JSON: %v", jsonErr)
+	case body.AccessToken == "":
+		return "", time.Time{}, errors.New("the response has no access_token")
+	case body.TokenType != "" && !strings.EqualFold(body.TokenType, "bearer"):
+		return "", time.Time{}, fmt.Errorf("got token_type %q, want Bearer", body.TokenType)
+	}
+	var expires time.Time
+	if body.ExpiresIn > 0 {
+		expires = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return body.AccessToken, expires, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// colorTerminal reports whether stdout is a terminal that may be written
+// in color, unless NO_COLOR (https://no-color.org) says otherwise.
+func colorTerminal() bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// printOptions are how printResults prints, see the flags of the same
+// names.
+type printOptions struct {
+	accessible bool
+	color      bool
+	quiet      bool // only failures and checks skipped because of them, no summary
+	verbose    bool // details of every result, e.g. its timing
+}
+
+// printResults prints the results of a one-shot run of checks in config
+// order, aligned and, if colored, colored, followed by a summary. Failures
+// are written as JSON records instead with -output json. It returns how
+// many checks failed.
+func printResults(w io.Writer, checks []HealthCheck, results []runResult, opts printOptions, elapsed time.Duration) int {
+	accessible := opts.accessible
+	paint := func(c, s string) string {
+		if !opts.color || accessible {
+			return s
+		}
+		return c + s + ansiReset
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	ok, failing, skipped := 0, 0, 0
+	for i, h := range checks {
+		r := results[i]
+		var state, detail string
+		switch {
+		case h.Paused:
+			skipped++
+			state, detail = paint(ansiYellow, tr("SKIP")), tr("paused")
+		case r.skippedFor != "":
+			skipped++
+			state, detail = paint(ansiYellow, tr("SKIP")), tr("dependency %s is down", r.skippedFor)
+		case r.Healthy:
+			ok++
+			state = paint(ansiGreen, tr("PASS"))
+		default:
+			failing++
+			state, detail = paint(ansiRed, tr("FAIL")), fmt.Sprint(r.Err)
+		}
+		if opts.verbose && !h.Paused && r.skippedFor == "" {
+			detail = strings.Join(slices.DeleteFunc([]string{detail, resultDetails(r.Result)}, func(s string) bool { return s == "" }), "; ")
+		}
+		if jsonErrors && !h.Paused && (!r.Healthy || r.skippedFor != "") {
+			rec := errorRecord{Kind: errCheck, Check: h.ID(), Error: fmt.Sprint(r.Err)}
+			switch {
+			case r.skippedFor != "":
+				rec.Kind, rec.Error = errSkipped, fmt.Sprintf("dependency %s is down", r.skippedFor)
+			case r.ConfigErr:
+				rec.Kind = errConfig
+			}
+			writeError(rec)
+		}
+		switch {
+		case jsonErrors && !accessible:
+		case opts.quiet && (r.Healthy || h.Paused):
+		case accessible:
+			// Fewer columns for screen readers.
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", state, h.ID(), detail)
+		default:
+			latency := ""
+			if l := r.latency.Round(10 * time.Microsecond); l > 0 {
+				latency = l.String()
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", state, h.ID(), latency, detail)
+		}
+	}
+	tw.Flush()
+	if (jsonErrors && !accessible) || opts.quiet {
+		return failing
+	}
+	elapsed = elapsed.Round(time.Millisecond)
+	if skipped > 0 {
+		fmt.Fprint(w, tr("%d ok, %d failing, %d skipped, ran in %v\n", ok, failing, skipped, elapsed))
+	} else {
+		fmt.Fprint(w, tr("%d ok, %d failing, ran in %v\n", ok, failing, elapsed))
+	}
+	return failing
+}
+
+// resultDetails describes the details of r for -verbose: how long the
+// phases of its HTTP requests took, their protocol and redirects, and what
+// it printed.
+func resultDetails(r Result) string {
+	var parts []string
+	if r.Timing != nil {
+		var phases []string
+		for _, p := range r.Timing.phases() {
+			if p.d > 0 {
+				phases = append(phases, p.name+" "+p.d.Round(10*time.Microsecond).String())
+			}
+		}
+		if len(phases) > 0 {
+			parts = append(parts, strings.Join(phases, " "))
+		}
+	}
+	if r.Protocol != "" {
+		parts = append(parts, r.Protocol)
+	}
+	if len(r.Redirects) > 0 {
+		parts = append(parts, "redirected from "+strings.Join(r.Redirects, " -> "))
+	}
+	if r.Output != "" {
+		parts = append(parts, "output: "+strings.Join(strings.Fields(r.Output), " "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// outcome returns whether h passed, failed or was skipped in a one-shot
+// run, and why it didn't pass.
+func outcome(h HealthCheck, r runResult) (state, reason string) {
+	switch {
+	case h.Paused:
+		return "skip", "paused"
+	case r.skippedFor != "":
+		return "skip", fmt.Sprintf("dependency %s is down", r.skippedFor)
+	case r.Healthy:
+		return "pass", ""
+	}
+	return "fail", fmt.Sprint(r.Err)
+}
+
+// writeCSV writes the results of a one-shot run as CSV, one row per check
+// in config order, for archiving in spreadsheets. It returns how many
+// checks failed.
+func writeCSV(w io.Writer, checks []HealthCheck, results []runResult) (int, error) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"check", "url", "state", "latency_ms", "error"})
+	failing := 0
+	for i, h := range checks {
+		state, reason := outcome(h, results[i])
+		if state == "fail" {
+			failing++
+		}
+		latency := ""
+		if l := results[i].latency; l > 0 {
+			latency = strconv.FormatFloat(ms(l), 'f', 3, 64)
+		}
+		cw.Write([]string{h.ID(), h.URL, state, latency, reason})
+	}
+	cw.Flush()
+	return failing, cw.Error()
+}
+
+// junitSuite is a JUnit XML report, as rendered by CI systems.
+type junitSuite struct {
+	XMLName   xml.Name    `xml:"testsuite"`
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"` // seconds
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"` // the check type
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Skipped   *junitMessage `xml:"skipped"`
+	SystemOut string        `xml:"system-out,omitempty"` // what the check printed
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"` // check or config
+}
+
+// writeJUnit writes the results of a one-shot run started at start as a
+// JUnit XML test suite, a test case per check. It returns how many checks
+// failed.
+func writeJUnit(w io.Writer, checks []HealthCheck, results []runResult, start time.Time, elapsed time.Duration) (int, error) {
+	seconds := func(d time.Duration) string { return strconv.FormatFloat(d.Seconds(), 'f', 3, 64) }
+	suite := junitSuite{Name: "healthcheck", Tests: len(checks), Time: seconds(elapsed), Timestamp: start.Format(time.RFC3339)}
+	for i, h := range checks {
+		r := results[i]
+		c := junitCase{Name: h.ID(), ClassName: h.checkType(), Time: seconds(r.latency), SystemOut: r.Output}
+		switch state, reason := outcome(h, r); state {
+		case "fail":
+			suite.Failures++
+			c.Failure = &junitMessage{Message: reason, Type: errCheck}
+			if r.ConfigErr {
+				c.Failure.Type = errConfig
+			}
+		case "skip":
+			suite.Skipped++
+			c.Skipped = &junitMessage{Message: reason}
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return suite.Failures, err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return suite.Failures, err
+	}
+	_, err := io.WriteString(w, "\n")
+	return suite.Failures, err
+}
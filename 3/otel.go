@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tracer exports spans of the daemon's runs and checks to an OpenTelemetry
+// collector with OTLP over HTTP, in its JSON encoding. Spans are sent in
+// batches and dropped if the collector can't be reached.
+type tracer struct {
+	url    string
+	site   string // the host.name of the resource
+	client *http.Client
+	queue  chan *span
+	done   chan struct{}
+}
+
+// span is an OpenTelemetry span. Methods of nil spans do nothing, so code
+// can be traced whether or not a tracer is configured.
+type span struct {
+	tracer  *tracer
+	traceID [16]byte
+	id      [8]byte
+	parent  [8]byte // zero for the root span of a trace
+	name    string
+	start   time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs map[string]any // string, bool, int or int64 values
+	err   error
+}
+
+type spanKey struct{}
+
+// newTracer starts exporting spans to the collector at endpoint, e.g.
+// http://localhost:4318.
+func newTracer(endpoint, site string) *tracer {
+	t := &tracer{
+		url:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		site:   site,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan *span, 1000),
+		done:   make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// start starts the root span of a trace. If t is nil it returns ctx and a
+// nil span.
+func (t *tracer) start(ctx context.Context, name string) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &span{tracer: t, name: name, start: time.Now()}
+	rand.Read(s.traceID[:])
+	rand.Read(s.id[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// startSpan starts a child of the span of ctx. If ctx has no span it
+// returns ctx and a nil span.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	parent, ok := ctx.Value(spanKey{}).(*span)
+	if !ok || parent == nil {
+		return ctx, nil
+	}
+	s := &span{tracer: parent.tracer, traceID: parent.traceID, parent: parent.id, name: name, start: time.Now()}
+	rand.Read(s.id[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// set sets the attribute key of s.
+func (s *span) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]any)
+	}
+	s.attrs[key] = value
+}
+
+// finish ends s, with an error status if err isn't nil, and queues it to
+// be exported. It doesn't block.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.end, s.err = time.Now(), err
+	s.mu.Unlock()
+	select {
+	case s.tracer.queue <- s:
+	default:
+		slog.Warn("trace queue full, dropping span", "span", s.name)
+	}
+}
+
+// traceSpans returns ctx with a trace that adds dns, connect, tls and ttfb
+// (time to first byte) spans of HTTP requests to the span of ctx.
+func traceSpans(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(spanKey{}).(*span); !ok {
+		return ctx
+	}
+	var mu sync.Mutex
+	var dns, handshake, ttfb *span
+	connects := make(map[string]*span) // by address, they may race
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			_, dns = startSpan(ctx, "dns")
+			dns.set("dns.question.name", info.Host)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			dns.set("dns.answers", len(info.Addrs))
+			dns.finish(info.Err)
+		},
+		ConnectStart: func(network, addr string) {
+			mu.Lock()
+			defer mu.Unlock()
+			_, s := startSpan(ctx, "connect")
+			s.set("network.transport", network)
+			s.set("network.peer.address", addr)
+			connects[addr] = s
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			connects[addr].finish(err)
+			delete(connects, addr)
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			_, handshake = startSpan(ctx, "tls")
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				handshake.set("tls.protocol.version", strings.TrimPrefix(tls.VersionName(state.Version), "TLS "))
+			}
+			handshake.finish(err)
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			_, ttfb = startSpan(ctx, "ttfb")
+			if info.Err != nil {
+				ttfb.finish(info.Err)
+				ttfb = nil
+			}
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			ttfb.finish(nil)
+			ttfb = nil
+		},
+	})
+}
+
+func (t *tracer) run() {
+	defer close(t.done)
+	var pending []*span
+	tick := time.NewTicker(5 * time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case s, ok := <-t.queue:
+			if !ok {
+				if len(pending) > 0 {
+					if err := t.send(pending); err != nil {
+						slog.Error("can't export traces", "spans", len(pending), "err", err)
+					}
+				}
+				return
+			}
+			pending = append(pending, s)
+		case <-tick.C:
+			if len(pending) == 0 {
+				continue
+			}
+			if err := t.send(pending); err != nil {
+				slog.Warn("can't export traces, dropping them", "spans", len(pending), "err", err)
+			}
+			pending = nil
+		}
+	}
+}
+
+// send posts spans as an OTLP ExportTraceServiceRequest.
+func (t *tracer) send(spans []*span) error {
+	var out []map[string]any
+	for _, s := range spans {
+		s.mu.Lock()
+		o := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.id[:]),
+			"name":              s.name,
+			"kind":              1, // internal
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.attrs),
+		}
+		if s.parent != [8]byte{} {
+			o["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != nil {
+			o["status"] = map[string]any{"code": 2, "message": s.err.Error()} // error
+		}
+		s.mu.Unlock()
+		out = append(out, o)
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttributes(map[string]any{
+				"service.name": "healthcheck",
+				"host.name":    t.site,
+			})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "healthcheck"},
+				"spans": out,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doRequest(t.client, req)
+}
+
+// otlpAttributes returns attrs as OTLP key-values.
+func otlpAttributes(attrs map[string]any) []any {
+	out := make([]any, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]any
+		switch v := v.(type) {
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]any{"key": k, "value": value})
+	}
+	return out
+}
+
+// Close exports the remaining spans.
+func (t *tracer) Close() {
+	close(t.queue)
+	<-t.done
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// outputVersion is the latest version of the output contract of the JSON
+// and CSV outputs of report, simulate and config history, and of their
+// tables with -output-version:
+//
+//   - a version only adds columns, after the existing ones, so parsers can
+//     ask for the version they know with -output-version;
+//   - names don't depend on -lang or the locale;
+//   - times are RFC 3339 in UTC and durations are milliseconds;
+//   - all columns are always there, empty values included.
+//
+// Version 2 added p50_latency_ms to report.
+const outputVersion = 2
+
+// column is a column of an output.
+type column[T any] struct {
+	json  string // name in JSON
+	csv   string // name in CSV and, upper case, in tables; empty if only in JSON
+	since int    // the output version that added it
+	value func(T) any
+	text  func(T) string // the value in CSV and tables, if not the default
+}
+
+// addOutputVersionFlag registers the -output-version flag.
+func addOutputVersionFlag(fs *flag.FlagSet) *int {
+	return fs.Int("output-version", 0, fmt.Sprintf("print the columns of version `n` of the output contract, 1 to %d, and tables like CSV (default the latest, and tables for humans)", outputVersion))
+}
+
+// writeOutput prints rows in format, table, json or csv, with the columns
+// of the given output version, zero meaning the latest.
+func writeOutput[T any](format string, version int, cols []column[T], rows []T) error {
+	if version == 0 {
+		version = outputVersion
+	}
+	if version < 1 || version > outputVersion {
+		return fmt.Errorf("unsupported -output-version %d, want 1 to %d", version, outputVersion)
+	}
+	var vcols []column[T]
+	for _, c := range cols {
+		if c.since <= version && (c.csv != "" || format == "json") {
+			vcols = append(vcols, c)
+		}
+	}
+
+	switch format {
+	case "json":
+		// Build the objects by hand to keep the column order.
+		var buf bytes.Buffer
+		buf.WriteByte('[')
+		for i, row := range rows {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('{')
+			for j, c := range vcols {
+				if j > 0 {
+					buf.WriteByte(',')
+				}
+				key, _ := json.Marshal(c.json)
+				value, err := json.Marshal(contractValue(c.value(row)))
+				if err != nil {
+					return fmt.Errorf("%s: %v", c.json, err)
+				}
+				buf.Write(key)
+				buf.WriteByte(':')
+				buf.Write(value)
+			}
+			buf.WriteByte('}')
+		}
+		buf.WriteByte(']')
+		var out bytes.Buffer
+		if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+			return err
+		}
+		out.WriteByte('\n')
+		_, err := out.WriteTo(os.Stdout)
+		return err
+	case "csv", "table":
+		records := make([][]string, 0, len(rows)+1)
+		header := make([]string, len(vcols))
+		for i, c := range vcols {
+			header[i] = c.csv
+			if format == "table" {
+				header[i] = strings.ToUpper(c.csv)
+			}
+		}
+		records = append(records, header)
+		for _, row := range rows {
+			record := make([]string, len(vcols))
+			for i, c := range vcols {
+				if c.text != nil {
+					record[i] = c.text(row)
+				} else {
+					record[i] = contractText(c.value(row))
+				}
+			}
+			records = append(records, record)
+		}
+		if format == "table" {
+			tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			for _, r := range records {
+				fmt.Fprintln(tw, strings.Join(r, "\t"))
+			}
+			return tw.Flush()
+		}
+		w := csv.NewWriter(os.Stdout)
+		w.WriteAll(records)
+		return w.Error()
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// contractValue returns v as the output contract has it in JSON.
+func contractValue(v any) any {
+	switch v := v.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339)
+	case time.Duration:
+		return ms(v)
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.IsNil() {
+		return reflect.MakeSlice(rv.Type(), 0, 0).Interface() // [] rather than null
+	}
+	return v
+}
+
+// contractText returns v as the output contract has it in CSV and tables.
+// Lists are separated by semicolons.
+func contractText(v any) string {
+	switch v := contractValue(v).(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', 3, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice {
+		parts := make([]string, rv.Len())
+		for i := range parts {
+			parts[i] = contractText(rv.Index(i).Interface())
+		}
+		return strings.Join(parts, ";")
+	}
+	return fmt.Sprint(v)
+}
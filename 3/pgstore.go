@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// pgStore keeps the history in PostgreSQL, for daemons on read-only
+// filesystems or several sharing one history. Records and config changes
+// are stored as JSON with their time, in tables it creates. There are no
+// rollups, report's -rollup needs a history file.
+type pgStore struct {
+	c *dbChecker // of the DSN, for logging in like postgres checks
+
+	mu sync.Mutex
+	pg *pgConn // nil until connected and after the connection broke
+}
+
+// pgStoreTimeout limits connecting to the store and each of its queries.
+const pgStoreTimeout = 10 * time.Second
+
+const pgSchema = `CREATE TABLE IF NOT EXISTS healthcheck_results (time timestamptz NOT NULL, record json NOT NULL);
+CREATE INDEX IF NOT EXISTS healthcheck_results_time ON healthcheck_results (time);
+CREATE TABLE IF NOT EXISTS healthcheck_config_changes (time timestamptz NOT NULL, change json NOT NULL);`
+
+// pgUndefinedTable is the SQLSTATE of queries of tables that don't exist,
+// which read-only stores don't create.
+const pgUndefinedTable = "42P01"
+
+// openPGStore connects to the database at dsn, a URL like the ones of
+// postgres checks, creating the tables if write is set.
+func openPGStore(dsn string, write bool) (*pgStore, error) {
+	c, err := newDBChecker(HealthCheck{URL: dsn})
+	if err != nil {
+		return nil, err
+	}
+	s := &pgStore{c: c.(*dbChecker)}
+	if write {
+		_, err = s.query(pgSchema)
+	} else {
+		_, err = s.query("SELECT 1")
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// query runs q, connecting first if needed. A broken connection is
+// dropped, so the next query connects again.
+func (s *pgStore) query(q string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pg == nil {
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+	s.pg.conn.SetDeadline(time.Now().Add(pgStoreTimeout))
+	rows, err := s.pg.query(q)
+	var dbErr *dbError
+	if err != nil && !errors.As(err, &dbErr) {
+		s.pg.conn.Close()
+		s.pg = nil
+	}
+	return rows, err
+}
+
+// connect logs in. It must be called with s.mu held.
+func (s *pgStore) connect() error {
+	ctx, cancel := context.WithTimeout(context.Background(), pgStoreTimeout)
+	defer cancel()
+	conn, err := s.c.h.dialContext(ctx, s.c.addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(pgStoreTimeout))
+	pg, err := s.c.loginPostgres(conn)
+	if err == nil {
+		// See pgQuote.
+		_, err = pg.query("SET standard_conforming_strings = on")
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	s.pg = pg
+	return nil
+}
+
+// insert adds v as JSON to the column of table, with time t.
+func (s *pgStore) insert(table, column string, t time.Time, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.query("INSERT INTO " + table + " (time, " + column + ") VALUES (" + pgQuote(t.UTC().Format(time.RFC3339Nano)) + ", " + pgQuote(string(b)) + ")")
+	return err
+}
+
+// rows returns the JSON of the column of table not older than t, oldest
+// first, or nothing if the table doesn't exist.
+func (s *pgStore) rows(table, column string, t time.Time) ([]string, error) {
+	rows, err := s.query("SELECT " + column + " FROM " + table + " WHERE time >= " + pgQuote(t.UTC().Format(time.RFC3339Nano)) + " ORDER BY time")
+	var dbErr *dbError
+	if errors.As(err, &dbErr) && dbErr.Code == pgUndefinedTable {
+		return nil, nil
+	}
+	return rows, err
+}
+
+func (s *pgStore) add(r record) error {
+	return s.insert("healthcheck_results", "record", r.Time, r)
+}
+
+func (s *pgStore) since(t time.Time) ([]record, error) {
+	rows, err := s.rows("healthcheck_results", "record", t)
+	if err != nil {
+		return nil, err
+	}
+	rs := make([]record, len(rows))
+	for i, row := range rows {
+		if err := json.Unmarshal([]byte(row), &rs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+
+func (s *pgStore) addChange(c configChange) error {
+	return s.insert("healthcheck_config_changes", "change", c.Time, c)
+}
+
+func (s *pgStore) changes(t time.Time) ([]configChange, error) {
+	rows, err := s.rows("healthcheck_config_changes", "change", t)
+	if err != nil {
+		return nil, err
+	}
+	cs := make([]configChange, len(rows))
+	for i, row := range rows {
+		if err := json.Unmarshal([]byte(row), &cs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return cs, nil
+}
+
+// Close logs out.
+func (s *pgStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pg == nil {
+		return nil
+	}
+	s.pg.send('X', nil)
+	err := s.pg.conn.Close()
+	s.pg = nil
+	return err
+}
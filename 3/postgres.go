@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// pgConn speaks just enough of the PostgreSQL frontend/backend protocol
+// (version 3) to log in and run a simple query.
+type pgConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// send writes a message of type typ, or the untyped startup message if typ
+// is 0.
+func (pg *pgConn) send(typ byte, body []byte) error {
+	var msg []byte
+	if typ != 0 {
+		msg = append(msg, typ)
+	}
+	msg = binary.BigEndian.AppendUint32(msg, uint32(4+len(body)))
+	msg = append(msg, body...)
+	_, err := pg.conn.Write(msg)
+	return err
+}
+
+// receive reads a message. Error responses are returned as a *dbError.
+func (pg *pgConn) receive() (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(pg.br, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n < 4 || n > 1<<24 {
+		return 0, nil, fmt.Errorf("invalid message length %d, is this PostgreSQL?", n)
+	}
+	body := make([]byte, n-4)
+	if _, err := io.ReadFull(pg.br, body); err != nil {
+		return 0, nil, err
+	}
+	if hdr[0] == 'E' {
+		return hdr[0], body, pgError(body)
+	}
+	return hdr[0], body, nil
+}
+
+// pgError parses the fields of an ErrorResponse.
+func pgError(body []byte) error {
+	e := &dbError{}
+	for _, f := range bytes.Split(body, []byte{0}) {
+		if len(f) < 2 {
+			continue
+		}
+		switch f[0] {
+		case 'C':
+			e.Code = string(f[1:])
+		case 'M':
+			e.Message = string(f[1:])
+		}
+	}
+	return e
+}
+
+// pingPostgres logs in and runs SELECT 1.
+func (c *dbChecker) pingPostgres(conn net.Conn) (time.Duration, error) {
+	start := time.Now()
+	pg, err := c.loginPostgres(conn)
+	if err != nil {
+		return 0, err
+	}
+	login := time.Since(start)
+
+	if err := pg.send('Q', []byte("SELECT 1\x00")); err != nil {
+		return login, err
+	}
+	if err := pg.readyForQuery(); err != nil {
+		return login, err
+	}
+	pg.send('X', nil)
+	return login, nil
+}
+
+// loginPostgres negotiates TLS as the sslmode of the DSN says and logs in
+// until the server is ready for queries.
+func (c *dbChecker) loginPostgres(conn net.Conn) (*pgConn, error) {
+	mode := c.url.Query().Get("sslmode")
+	if mode == "" {
+		mode = "prefer" // like libpq
+	}
+	if mode != "disable" {
+		// SSLRequest
+		if _, err := conn.Write([]byte{0, 0, 0, 8, 0x04, 0xd2, 0x16, 0x2f}); err != nil {
+			return nil, err
+		}
+		var answer [1]byte
+		if _, err := io.ReadFull(conn, answer[:]); err != nil {
+			return nil, err
+		}
+		switch {
+		case answer[0] == 'S':
+			tc, err := c.upgradeTLS(conn, mode == "verify-full")
+			if err != nil {
+				return nil, err
+			}
+			conn = tc
+		case mode != "prefer":
+			return nil, fmt.Errorf("sslmode=%s but the server doesn't support TLS", mode)
+		}
+	}
+	pg := &pgConn{conn: conn, br: bufio.NewReader(conn)}
+
+	user := c.url.User.Username()
+	password, _ := c.url.User.Password()
+	db := strings.TrimPrefix(c.url.Path, "/")
+	startup := binary.BigEndian.AppendUint32(nil, 3<<16) // protocol 3.0
+	startup = append(startup, "user\x00"+user+"\x00"...)
+	if db != "" {
+		startup = append(startup, "database\x00"+db+"\x00"...)
+	}
+	startup = append(startup, "application_name\x00healthcheck\x00\x00"...)
+	if err := pg.send(0, startup); err != nil {
+		return nil, err
+	}
+	if err := pg.authenticate(user, password); err != nil {
+		return nil, err
+	}
+	if err := pg.readyForQuery(); err != nil {
+		return nil, err
+	}
+	return pg, nil
+}
+
+// readyForQuery reads messages until the server is ready for a query,
+// returning the first error it reports.
+func (pg *pgConn) readyForQuery() error {
+	var first error
+	for {
+		typ, _, err := pg.receive()
+		var dbErr *dbError
+		switch {
+		case errors.As(err, &dbErr):
+			first = cmp.Or(first, err)
+		case err != nil:
+			return cmp.Or(first, err)
+		case typ == 'Z':
+			return first
+		}
+	}
+}
+
+// query runs the statements of q with the simple query protocol and
+// returns the first column of the rows they return as text, "" for NULL.
+// An error the server reports is returned as a *dbError once it's ready
+// for the next query.
+func (pg *pgConn) query(q string) ([]string, error) {
+	if err := pg.send('Q', append([]byte(q), 0)); err != nil {
+		return nil, err
+	}
+	var rows []string
+	var first error
+	for {
+		typ, body, err := pg.receive()
+		var dbErr *dbError
+		switch {
+		case errors.As(err, &dbErr):
+			first = cmp.Or(first, err)
+		case err != nil:
+			return nil, err
+		case typ == 'D':
+			v, err := pgFirstColumn(body)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, v)
+		case typ == 'Z':
+			return rows, first
+		}
+	}
+}
+
+// pgFirstColumn returns the first column of a DataRow message.
+func pgFirstColumn(row []byte) (string, error) {
+	if len(row) < 2+4 || binary.BigEndian.Uint16(row) == 0 {
+		return "", errors.New("invalid DataRow")
+	}
+	n := int32(binary.BigEndian.Uint32(row[2:]))
+	switch {
+	case n < 0: // NULL
+		return "", nil
+	case int64(n) > int64(len(row)-2-4):
+		return "", errors.New("truncated DataRow")
+	}
+	return string(row[2+4 : 2+4+n]), nil
+}
+
+// pgQuote returns s as a string literal, which needs the server's
+// standard_conforming_strings to be on, the default since PostgreSQL 9.1.
+func pgQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// authenticate answers the server's authentication requests until it
+// accepts the login. Cleartext, MD5 and SCRAM-SHA-256 passwords are
+// supported.
+func (pg *pgConn) authenticate(user, password string) error {
+	var scram *scramClient
+	for {
+		typ, body, err := pg.receive()
+		if err != nil {
+			return err
+		}
+		if typ != 'R' || len(body) < 4 {
+			return fmt.Errorf("unexpected message %q during authentication", typ)
+		}
+		code := binary.BigEndian.Uint32(body)
+		switch {
+		case code == 5 && len(body) < 4+4:
+			return errors.New("truncated MD5 salt")
+		case code >= 10 && code <= 12 && len(body) == 4:
+			return fmt.Errorf("empty SASL message %d", code)
+		}
+		switch code {
+		case 0: // AuthenticationOk
+			return nil
+		case 3: // AuthenticationCleartextPassword
+			err = pg.send('p', []byte(password+"\x00"))
+		case 5: // AuthenticationMD5Password
+			if fipsMode {
+				return errors.New("the server asks for an MD5 password, which FIPS mode doesn't allow, use SCRAM-SHA-256")
+			}
+			hash := func(s string) string {
+				sum := md5.Sum([]byte(s))
+				return hex.EncodeToString(sum[:])
+			}
+			err = pg.send('p', []byte("md5"+hash(hash(password+user)+string(body[4:8]))+"\x00"))
+		case 10: // AuthenticationSASL
+			if !bytes.Contains(body[4:], []byte("SCRAM-SHA-256\x00")) {
+				return fmt.Errorf("the server offers no supported SASL mechanism: %q", body[4:])
+			}
+			scram = newSCRAMClient(password)
+			first := scram.clientFirst()
+			msg := append([]byte("SCRAM-SHA-256\x00"), binary.BigEndian.AppendUint32(nil, uint32(len(first)))...)
+			err = pg.send('p', append(msg, first...))
+		case 11: // AuthenticationSASLContinue
+			if scram == nil {
+				return errors.New("SASL continue without SASL")
+			}
+			var final string
+			if final, err = scram.clientFinal(string(body[4:])); err == nil {
+				err = pg.send('p', []byte(final))
+			}
+		case 12: // AuthenticationSASLFinal
+			if scram == nil || !scram.verifyServer(string(body[4:])) {
+				return errors.New("the server's SCRAM signature is invalid")
+			}
+		default:
+			return fmt.Errorf("unsupported authentication method %d", code)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// scramClient is the client side of SCRAM-SHA-256 (RFC 5802, RFC 7677)
+// without channel binding.
+type scramClient struct {
+	password    string
+	nonce       string
+	authMessage string
+	saltedPass  []byte
+}
+
+func newSCRAMClient(password string) *scramClient {
+	nonce := make([]byte, 18)
+	rand.Read(nonce)
+	return &scramClient{password: password, nonce: base64.StdEncoding.EncodeToString(nonce)}
+}
+
+func (s *scramClient) clientFirst() string {
+	// PostgreSQL takes the user name from the startup message.
+	return "n,,n=,r=" + s.nonce
+}
+
+func (s *scramClient) clientFinal(serverFirst string) (string, error) {
+	var nonce, salt string
+	iterations := 0
+	for _, attr := range strings.Split(serverFirst, ",") {
+		k, v, _ := strings.Cut(attr, "=")
+		switch k {
+		case "r":
+			nonce = v
+		case "s":
+			salt = v
+		case "i":
+			fmt.Sscan(v, &iterations)
+		}
+	}
+	if !strings.HasPrefix(nonce, s.nonce) || iterations <= 0 {
+		return "", errors.New("invalid SCRAM server-first-message")
+	}
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return "", fmt.Errorf("invalid SCRAM salt: %v", err)
+	}
+	if s.saltedPass, err = pbkdf2.Key(sha256.New, s.password, saltBytes, iterations, sha256.Size); err != nil {
+		return "", err
+	}
+	withoutProof := "c=biws,r=" + nonce
+	s.authMessage = "n=,r=" + s.nonce + "," + serverFirst + "," + withoutProof
+	clientKey := hmacSHA256(s.saltedPass, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	proof := hmacSHA256(storedKey[:], s.authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (s *scramClient) verifyServer(serverFinal string) bool {
+	want := hmacSHA256(hmacSHA256(s.saltedPass, "Server Key"), s.authMessage)
+	got, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(serverFinal, "v="))
+	return err == nil && hmac.Equal(got, want)
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(s))
+	return m.Sum(nil)
+}
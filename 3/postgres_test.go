@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// scriptedConn answers with the bytes of its reader and records what's
+// written to it.
+type scriptedConn struct {
+	net.Conn
+	r       io.Reader
+	written bytes.Buffer
+}
+
+func (c *scriptedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *scriptedConn) Write(b []byte) (int, error) {
+	return c.written.Write(b)
+}
+
+// pgMessage returns a message of the PostgreSQL protocol.
+func pgMessage(typ byte, body ...[]byte) []byte {
+	b := bytes.Join(body, nil)
+	return append(binary.BigEndian.AppendUint32([]byte{typ}, uint32(4+len(b))), b...)
+}
+
+// pgAuth returns an authentication request with code and data.
+func pgAuth(code uint32, data string) []byte {
+	return pgMessage('R', binary.BigEndian.AppendUint32(nil, code), []byte(data))
+}
+
+func TestPGAuthenticate(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		server  [][]byte
+		want    []byte // the messages sent
+		wantErr string
+	}{
+		{
+			name:   "trust",
+			server: [][]byte{pgAuth(0, "")},
+		},
+		{
+			name:   "cleartext",
+			server: [][]byte{pgAuth(3, ""), pgAuth(0, "")},
+			want:   pgMessage('p', []byte("secret\x00")),
+		},
+		{
+			// md5(md5(password + user) + salt), hex encoded
+			name:   "md5",
+			server: [][]byte{pgAuth(5, "\x01\x02\x03\x04"), pgAuth(0, "")},
+			want:   pgMessage('p', []byte("md5bb41a296aab6baccb36ff243a562abff\x00")),
+		},
+		{
+			name:    "md5 truncated salt",
+			server:  [][]byte{pgAuth(5, "\x01\x02")},
+			wantErr: "truncated MD5 salt",
+		},
+		{
+			name:    "sasl without mechanisms",
+			server:  [][]byte{pgAuth(10, "")},
+			wantErr: "empty SASL message 10",
+		},
+		{
+			name:    "sasl without scram",
+			server:  [][]byte{pgAuth(10, "SCRAM-SHA-256-PLUS\x00\x00")},
+			wantErr: "no supported SASL mechanism",
+		},
+		{
+			name:    "sasl continue without data",
+			server:  [][]byte{pgAuth(11, "")},
+			wantErr: "empty SASL message 11",
+		},
+		{
+			name:    "sasl continue without sasl",
+			server:  [][]byte{pgAuth(11, "r=x,s=eA==,i=1")},
+			wantErr: "SASL continue without SASL",
+		},
+		{
+			name:    "sasl final without data",
+			server:  [][]byte{pgAuth(12, "")},
+			wantErr: "empty SASL message 12",
+		},
+		{
+			name:    "no code",
+			server:  [][]byte{pgMessage('R', []byte{0, 0})},
+			wantErr: "unexpected message 'R'",
+		},
+		{
+			name:    "truncated message",
+			server:  [][]byte{pgAuth(5, "\x01\x02\x03\x04")[:7]},
+			wantErr: "unexpected EOF",
+		},
+		{
+			name:    "invalid length",
+			server:  [][]byte{{'R', 0, 0, 0, 2}},
+			wantErr: "invalid message length 2",
+		},
+		{
+			name:    "unsupported method",
+			server:  [][]byte{pgAuth(7, "")},
+			wantErr: "unsupported authentication method 7",
+		},
+		{
+			name:    "error",
+			server:  [][]byte{pgMessage('E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00"))},
+			wantErr: "password authentication failed",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &scriptedConn{}
+			pg := &pgConn{conn: conn, br: bufio.NewReader(bytes.NewReader(bytes.Join(tt.server, nil)))}
+			err := pg.authenticate("postgres", "secret")
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			if tt.wantErr == "" && !bytes.Equal(conn.written.Bytes(), tt.want) {
+				t.Errorf("sent %q, want %q", conn.written.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+// The test vector of RFC 7677, but with the empty user name PostgreSQL
+// uses, which changes the proof and the server's signature.
+const (
+	scramPassword    = "pencil"
+	scramNonce       = "rOprNGfwEbeRWgbNEkqO"
+	scramServerFirst = "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+	scramClientFinal = "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=qvT2SWdEH5Q06albL+hjSYuUhCG7VndFyzIb7CK4n9k="
+	scramServerFinal = "v=3HO6Qt1M4MKJrmlKaoOqLAI0/0TV0HZe7J9H3MBtSOg="
+)
+
+func TestSCRAM(t *testing.T) {
+	s := &scramClient{password: scramPassword, nonce: scramNonce}
+	if got, want := s.clientFirst(), "n,,n=,r="+scramNonce; got != want {
+		t.Errorf("clientFirst() = %q, want %q", got, want)
+	}
+	final, err := s.clientFinal(scramServerFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if final != scramClientFinal {
+		t.Errorf("clientFinal() = %q, want %q", final, scramClientFinal)
+	}
+	for _, tt := range []struct {
+		serverFinal string
+		want        bool
+	}{
+		{scramServerFinal, true},
+		{"v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=", false}, // of user "user"
+		{"v=", false},
+		{"", false},
+		{"v=not base64", false},
+	} {
+		if got := s.verifyServer(tt.serverFinal); got != tt.want {
+			t.Errorf("verifyServer(%q) = %v, want %v", tt.serverFinal, got, tt.want)
+		}
+	}
+}
+
+func TestSCRAMInvalidServerFirst(t *testing.T) {
+	for _, serverFirst := range []string{
+		"",
+		"r=someoneelses,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+		"r=" + scramNonce + "x,s=W22ZaJ0SNY7soEsUEjb6gQ==",
+		"r=" + scramNonce + "x,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=0",
+		"r=" + scramNonce + "x,s=not base64,i=4096",
+	} {
+		s := &scramClient{password: scramPassword, nonce: scramNonce}
+		if _, err := s.clientFinal(serverFirst); err == nil {
+			t.Errorf("clientFinal(%q) succeeded", serverFirst)
+		}
+	}
+}
+
+func TestPGFirstColumn(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		row     []byte
+		want    string
+		wantErr bool
+	}{
+		{"text", []byte("\x00\x01\x00\x00\x00\x02{}"), "{}", false},
+		{"two columns", []byte("\x00\x02\x00\x00\x00\x01a\x00\x00\x00\x01b"), "a", false},
+		{"null", []byte("\x00\x01\xff\xff\xff\xff"), "", false},
+		{"empty", []byte("\x00\x01\x00\x00\x00\x00"), "", false},
+		{"no columns", []byte("\x00\x00"), "", true},
+		{"truncated count", []byte("\x00"), "", true},
+		{"truncated length", []byte("\x00\x01\x00\x00"), "", true},
+		{"truncated value", []byte("\x00\x01\x00\x00\x00\x05{}"), "", true},
+		{"huge length", []byte("\x00\x01\x7f\xff\xff\xff{}"), "", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pgFirstColumn(tt.row)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("pgFirstColumn(%q) = %q, %v, want %q, error %v", tt.row, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPGQuote(t *testing.T) {
+	for s, want := range map[string]string{
+		"":                    "''",
+		"it's":                "'it''s'",
+		`{"Error":"a\\'b"}`:   `'{"Error":"a\\''b"}'`,
+		"'; DROP TABLE x; --": "'''; DROP TABLE x; --'",
+	} {
+		if got := pgQuote(s); got != want {
+			t.Errorf("pgQuote(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
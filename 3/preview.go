@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+func assertPreview(args []string) error {
+	fs := flag.NewFlagSet("assert-preview", flag.ExitOnError)
+	configFile := addConfigFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: assert-preview [flags] <name or url>\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing health check name or URL")
+	}
+
+	h, err := findCheck(*configFile, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	c, err := h.checker()
+	if err != nil {
+		return err
+	}
+	hc, ok := c.(httpChecker)
+	if !ok {
+		return fmt.Errorf("%s is a %s check, only HTTP checks have assertions", h.ID(), h.checkType())
+	}
+	h = hc.h // e.g. with the transport of a unix:// check
+	resp, body, err := h.fetch(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, u := range redirectChain(resp) {
+		fmt.Printf("GET %s (redirected)\n", u)
+	}
+	fmt.Printf("GET %s\n", resp.Request.URL)
+	fmt.Print(tr("\nStatus: %s\n", resp.Status))
+	fmt.Print(tr("\nHeaders:\n"))
+	names := make([]string, 0, len(resp.Header))
+	for name := range resp.Header {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Printf("  %s: %s\n", name, strings.Join(resp.Header[name], ", "))
+	}
+	fmt.Print(tr("\nBody (%d bytes):\n", len(body)))
+	var v any
+	if json.Unmarshal(body, &v) == nil {
+		for _, f := range flattenJSON("", v) {
+			fmt.Printf("  %s\n", f)
+		}
+	} else {
+		fmt.Printf("  %s\n", strings.ReplaceAll(strings.TrimSpace(string(body)), "\n", "\n  "))
+	}
+
+	fmt.Print(tr("\nAssertions:\n"))
+	failed := 0
+	for _, a := range h.assertions() {
+		if err := a.check(resp, body); err != nil {
+			failed++
+			fmt.Print(tr("  FAIL %s: %v\n", a.desc, err))
+		} else {
+			fmt.Print(tr("  PASS %s\n", a.desc))
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d assertion(s) failed", failed)
+	}
+	return nil
+}
+
+// flattenJSON returns the leaves of a decoded JSON value as path = value
+// lines, e.g. `.items[0].status = "ok"`.
+func flattenJSON(path string, v any) []string {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		var out []string
+		for _, k := range keys {
+			out = append(out, flattenJSON(path+"."+k, v[k])...)
+		}
+		return out
+	case []any:
+		var out []string
+		for i, e := range v {
+			out = append(out, flattenJSON(fmt.Sprintf("%s[%d]", path, i), e)...)
+		}
+		return out
+	default:
+		if path == "" {
+			path = "."
+		}
+		b, _ := json.Marshal(v)
+		return []string{fmt.Sprintf("%s = %s", path, b)}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quorumAlerts alerts on the checks of all sites that are down by their
+// Quorum, once per check rather than once per site.
+type quorumAlerts struct {
+	notifiers map[string]notifier
+	stale     time.Duration // results older than this don't count
+
+	mu      sync.Mutex
+	down    map[string]bool // alerted checks by ID
+	sig     string          // of the config file checks were read from
+	checks  map[string]HealthCheck
+	loadErr error
+}
+
+// check returns the config of the check with id, from the aggregate
+// server's -config if it has one. It must be called with a.alerts.mu
+// held.
+func (a *aggregator) check(id string) HealthCheck {
+	if a.configFile != "" {
+		if fi, err := os.Stat(a.configFile); err == nil {
+			if sig := fmt.Sprint(fi.ModTime(), fi.Size()); sig != a.alerts.sig {
+				a.alerts.sig = sig
+				hs, err := readConfig(a.configFile)
+				if err != nil && err != a.alerts.loadErr {
+					slog.Error("can't read config for quorums", "err", err)
+				}
+				a.alerts.loadErr = err
+				if err == nil {
+					a.alerts.checks = make(map[string]HealthCheck, len(hs))
+					for _, h := range hs {
+						a.alerts.checks[h.ID()] = h
+					}
+				}
+			}
+		}
+	}
+	if h, ok := a.alerts.checks[id]; ok {
+		return h
+	}
+	return HealthCheck{Name: id}
+}
+
+// byQuorum returns the state of the checks across sites.
+func (a *aggregator) byQuorum() []checkSites {
+	a.alerts.mu.Lock()
+	defer a.alerts.mu.Unlock()
+	return a.quorums()
+}
+
+// quorums is byQuorum. It must be called with a.alerts.mu held.
+func (a *aggregator) quorums() []checkSites {
+	return bySite(a.status(), time.Now().Add(-a.alerts.stale), func(id string) int { return a.check(id).Quorum })
+}
+
+// alert triggers alerts of the checks that went down and resolves those
+// of the checks that came back. The notifiers are called after a.alerts.mu
+// is unlocked, not to hold up writes and the dashboard while they post.
+func (a *aggregator) alert() {
+	if len(a.alerts.notifiers) == 0 {
+		return
+	}
+	type change struct {
+		check HealthCheck
+		sites checkSites
+		down  bool
+	}
+	var changes []change
+	a.alerts.mu.Lock()
+	if a.alerts.down == nil {
+		a.alerts.down = make(map[string]bool)
+	}
+	for _, c := range a.quorums() {
+		id := cmp.Or(c.Name, c.URL)
+		down := c.State == "down"
+		if down == a.alerts.down[id] {
+			continue
+		}
+		h := a.check(id)
+		if h.URL == "" {
+			h.URL = c.URL
+		}
+		changes = append(changes, change{h, c, down})
+		a.alerts.down[id] = down
+	}
+	a.alerts.mu.Unlock()
+
+	for _, ch := range changes {
+		c := ch.sites
+		id := cmp.Or(c.Name, c.URL)
+		for _, name := range slices.Sorted(maps.Keys(a.alerts.notifiers)) {
+			var err error
+			if ch.down {
+				err = a.alerts.notifiers[name].trigger(ch.check, fmt.Errorf("down from %d of %d sites: %s", len(c.Unhealthy), len(c.Unhealthy)+len(c.Healthy), strings.Join(c.Unhealthy, ", ")))
+			} else {
+				err = a.alerts.notifiers[name].resolve(ch.check)
+			}
+			if err != nil {
+				slog.Error("can't notify", "channel", name, "name", id, "err", err)
+			}
+		}
+		if ch.down {
+			slog.Warn("down by quorum", "name", id, "sites", c.Unhealthy, "quorum", c.Quorum)
+		} else {
+			slog.Info("up by quorum", "name", id)
+		}
+	}
+}
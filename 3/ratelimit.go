@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// outbound limits the rate of checks, see the -rate and -host-rate flags.
+var outbound rateLimiter
+
+// rateLimiter limits the checks per second overall and per host. A zero
+// rate means no limit.
+type rateLimiter struct {
+	rate, hostRate float64
+
+	mu     sync.Mutex
+	global *tokenBucket
+	hosts  map[string]*tokenBucket
+}
+
+// wait blocks until h may run.
+func (l *rateLimiter) wait(h HealthCheck) {
+	if l.rate <= 0 && l.hostRate <= 0 {
+		return
+	}
+	var host string
+	if u, err := url.Parse(h.URL); err == nil {
+		host = u.Hostname()
+	}
+	l.mu.Lock()
+	var d time.Duration
+	if l.rate > 0 {
+		if l.global == nil {
+			l.global = newTokenBucket(l.rate)
+		}
+		d = l.global.take()
+	}
+	if l.hostRate > 0 && host != "" {
+		b, ok := l.hosts[host]
+		if !ok {
+			if l.hosts == nil {
+				l.hosts = make(map[string]*tokenBucket)
+			}
+			b = newTokenBucket(l.hostRate)
+			l.hosts[host] = b
+		}
+		d = max(d, b.take())
+	}
+	l.mu.Unlock()
+	time.Sleep(d)
+}
+
+// tokenBucket allows rate events per second with bursts of up to rate
+// events, but at least one.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := max(rate, 1)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take takes a token and returns how long to wait until it's available.
+// Tokens can be taken in advance, so concurrent callers queue up.
+func (b *tokenBucket) take() time.Duration {
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
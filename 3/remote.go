@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPending is how many results a remoteWriter keeps while the aggregate
+// server can't be reached. The oldest are dropped first.
+const maxPending = 10000
+
+// remoteWriter sends results to an aggregate server in batches. Failed
+// batches are retried; the server deduplicates results by their ID.
+type remoteWriter struct {
+	url     string
+	client  *http.Client
+	queue   chan record
+	backlog chan []record // to resend, see resume
+	done    chan struct{}
+
+	mu      sync.Mutex
+	lastErr error // of the last send
+	gzip    bool  // the server accepts gzip request bodies, see compress.go
+	closed  bool  // queue is closed, by Close
+}
+
+// newRemoteWriter starts sending results to the aggregate server at addr,
+// e.g. http://central:9100, labeled with site, over transport, nil meaning
+// http.DefaultTransport.
+func newRemoteWriter(addr, site string, transport http.RoundTripper) *remoteWriter {
+	w := &remoteWriter{
+		url:     strings.TrimSuffix(addr, "/") + "/api/write?site=" + url.QueryEscape(site),
+		client:  &http.Client{Timeout: 10 * time.Second, Transport: transport},
+		queue:   make(chan record, maxPending), // not to drop results while a send is slow
+		backlog: make(chan []record, 1),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// add queues r to be sent. It doesn't block. Results added after Close,
+// e.g. by checks still running on shutdown, are dropped.
+func (w *remoteWriter) add(r record) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		slog.Debug("remote writer closed, dropping result", "name", r.id())
+		return
+	}
+	select {
+	case w.queue <- r:
+	default:
+		slog.Warn("remote write queue full, dropping result", "name", r.id())
+	}
+}
+
+func (w *remoteWriter) run() {
+	defer close(w.done)
+	var pending []record
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case r, ok := <-w.queue:
+			if !ok {
+				if len(pending) > 0 {
+					if err := w.send(pending); err != nil {
+						slog.Error("can't send results to aggregate server", "results", len(pending), "err", err)
+					}
+				}
+				return
+			}
+			pending = append(pending, r)
+			if len(pending) > maxPending {
+				pending = pending[len(pending)-maxPending:]
+			}
+		case rs := <-w.backlog:
+			pending = append(rs, pending...)
+			if len(pending) > maxPending {
+				pending = pending[len(pending)-maxPending:]
+			}
+		case <-tick.C:
+			if len(pending) == 0 {
+				continue
+			}
+			if err := w.send(pending); err != nil {
+				slog.Warn("can't send results to aggregate server, will retry", "results", len(pending), "err", err)
+				continue
+			}
+			pending = nil
+		}
+	}
+}
+
+// resume resends the results in hist that the aggregate server is missing,
+// those after its resumePoint of this site, e.g. of checks run while it
+// couldn't be reached and this writer was restarted. Results it already
+// has are dropped by their ResultID.
+func (w *remoteWriter) resume(hist store) error {
+	resp, err := w.client.Get(w.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", w.url, resp.Status)
+	}
+	var p resumePoint
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return err
+	}
+	rs, err := hist.since(p.Time)
+	if err != nil {
+		return err
+	}
+	if i := slices.IndexFunc(rs, func(r record) bool { return r.ResultID == p.ResultID }); p.ResultID != "" && i >= 0 {
+		rs = rs[i+1:]
+	}
+	if len(rs) > maxPending {
+		rs = rs[len(rs)-maxPending:]
+	}
+	if len(rs) == 0 {
+		return nil
+	}
+	slog.Info("resending results the aggregate server is missing", "results", len(rs), "after", p.ResultID)
+	select {
+	case w.backlog <- rs:
+	case <-w.done:
+	}
+	return nil
+}
+
+// err returns the error of the last attempt to send results, nil if it
+// succeeded or there was none yet.
+func (w *remoteWriter) err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastErr
+}
+
+// send posts rs as JSON lines, the format of the history file.
+func (w *remoteWriter) send(rs []record) error {
+	err := w.post(rs)
+	w.mu.Lock()
+	w.lastErr = err
+	w.mu.Unlock()
+	return err
+}
+
+func (w *remoteWriter) post(rs []record) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range rs {
+		enc.Encode(r)
+	}
+	w.mu.Lock()
+	compress := w.gzip
+	w.mu.Unlock()
+	body := buf.Bytes()
+	if compress {
+		body = gzipBytes(body)
+	}
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// A server that was downgraded stops listing gzip, the batch is
+	// retried uncompressed. So is one that declined the gzip body.
+	w.mu.Lock()
+	w.gzip = acceptsGzip(resp.Header) && !(compress && resp.StatusCode == http.StatusUnsupportedMediaType)
+	w.mu.Unlock()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
+	}
+	return nil
+}
+
+// hostname returns the name of this host, the default site.
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+// Close sends the remaining results.
+func (w *remoteWriter) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
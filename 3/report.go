@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// stats summarizes the stored history of a single health check. Checks of
+// records from an aggregate server are prefixed with their site, e.g.
+// prague/api.
+type stats struct {
+	Check       string // ID of the health check
+	Checks      int
+	Uptime      float64 // percent
+	MeanLatency time.Duration
+	P50Latency  time.Duration
+	P95Latency  time.Duration
+	P99Latency  time.Duration
+	Downtime    time.Duration
+}
+
+func report(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	historyFile := fs.String("history", "history.jsonl", "read results from `file` or a postgres:// URL")
+	window := fs.String("window", "24h", "report on the last `period` (e.g. 24h, 7d, 30d)")
+	format := fs.String("format", "table", "output `format`: table, json or csv")
+	rollupPeriod := fs.String("rollup", "", "read the `hourly` or daily rollups of the history file rather than every result, for long windows")
+	version := addOutputVersionFlag(fs)
+	addLangFlag(fs)
+	fs.Parse(args)
+
+	d, err := parseWindow(*window)
+	if err != nil {
+		return err
+	}
+	var ss []stats
+	switch *rollupPeriod {
+	case "":
+		hist, err := openStore(*historyFile, false)
+		if err != nil {
+			return err
+		}
+		defer hist.Close()
+		records, err := hist.since(time.Now().Add(-d))
+		if err != nil {
+			return err
+		}
+		ss = summarize(records)
+	case "hourly", "daily":
+		if !isHistoryFile(*historyFile) {
+			return errors.New("-rollup needs a history file")
+		}
+		rs, err := readRollups(*historyFile+"."+*rollupPeriod, time.Now().Add(-d))
+		if err != nil {
+			return err
+		}
+		ss = mergeRollups(rs)
+	default:
+		return fmt.Errorf("unknown rollup %q", *rollupPeriod)
+	}
+
+	if *format == "table" && *version == 0 {
+		return printTable(ss)
+	}
+	return writeOutput(*format, *version, statsColumns, ss)
+}
+
+// statsColumns are the columns of the report, see outputVersion.
+var statsColumns = []column[stats]{
+	{json: "Check", csv: "check", since: 1, value: func(s stats) any { return s.Check }},
+	{json: "Checks", csv: "checks", since: 1, value: func(s stats) any { return s.Checks }},
+	{json: "Uptime", csv: "uptime", since: 1, value: func(s stats) any { return s.Uptime }},
+	{json: "MeanLatencyMs", csv: "mean_latency_ms", since: 1, value: func(s stats) any { return s.MeanLatency }},
+	{json: "P95LatencyMs", csv: "p95_latency_ms", since: 1, value: func(s stats) any { return s.P95Latency }},
+	{json: "P99LatencyMs", csv: "p99_latency_ms", since: 1, value: func(s stats) any { return s.P99Latency }},
+	{json: "DowntimeMs", csv: "downtime_ms", since: 1, value: func(s stats) any { return s.Downtime }},
+	{json: "P50LatencyMs", csv: "p50_latency_ms", since: 2, value: func(s stats) any { return s.P50Latency }},
+}
+
+// parseWindow is like time.ParseDuration but also understands days, e.g. "7d".
+func parseWindow(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid window %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", s)
+	}
+	return d, nil
+}
+
+func summarize(records []record) []stats {
+	byID := make(map[string][]record)
+	for _, r := range records {
+		id := r.id()
+		if r.Site != "" {
+			id = r.Site + "/" + id
+		}
+		byID[id] = append(byID[id], r)
+	}
+
+	var ss []stats
+	for id, rs := range byID {
+		slices.SortFunc(rs, func(a, b record) int { return a.Time.Compare(b.Time) })
+		s := stats{Check: id}
+		var healthy int
+		var total time.Duration
+		var latencies []time.Duration
+		for i, r := range rs {
+			if r.Maintenance || r.Drill {
+				continue // doesn't count against uptime
+			}
+			latencies = append(latencies, r.Latency)
+			total += r.Latency
+			if r.Healthy {
+				healthy++
+			} else if i+1 < len(rs) {
+				// Unhealthy until the next check says otherwise.
+				s.Downtime += rs[i+1].Time.Sub(r.Time)
+			}
+		}
+		if len(latencies) == 0 {
+			continue
+		}
+		slices.Sort(latencies)
+		s.Checks = len(latencies)
+		s.Uptime = 100 * float64(healthy) / float64(s.Checks)
+		s.MeanLatency = total / time.Duration(s.Checks)
+		s.P50Latency = percentile(latencies, 50)
+		s.P95Latency = percentile(latencies, 95)
+		s.P99Latency = percentile(latencies, 99)
+		ss = append(ss, s)
+	}
+	slices.SortFunc(ss, func(a, b stats) int { return strings.Compare(a.Check, b.Check) })
+	return ss
+}
+
+// percentile returns the pth percentile of sorted using the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func printTable(ss []stats) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, tr("CHECK\tCHECKS\tUPTIME\tMEAN\tP50\tP95\tP99\tDOWNTIME"))
+	for _, s := range ss {
+		fmt.Fprintf(tw, "%s\t%d\t%.2f%%\t%v\t%v\t%v\t%v\t%v\n", s.Check, s.Checks, s.Uptime,
+			s.MeanLatency.Round(time.Millisecond), s.P50Latency.Round(time.Millisecond), s.P95Latency.Round(time.Millisecond),
+			s.P99Latency.Round(time.Millisecond), s.Downtime.Round(time.Second))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// resolving returns h set up to connect to its ResolveOverride address, to
+// resolve names with its DNSServer and to use the address family it
+// Forces, if set.
+func (h HealthCheck) resolving() (HealthCheck, error) {
+	if h.ResolveOverride == "" && h.DNSServer == "" && h.Force == "" {
+		return h, nil
+	}
+	network := "tcp"
+	if h.Force != "" {
+		switch h.checkType() {
+		case "exec", "unix":
+			return h, fmt.Errorf("Force isn't supported by %s checks", h.checkType())
+		}
+		ipNet, err := h.ipNetwork()
+		if err != nil {
+			return h, err
+		}
+		network = "tcp" + ipNet[len("ip"):]
+	}
+	var d net.Dialer
+	if h.DNSServer != "" {
+		server := h.DNSServer
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		h.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+		d.Resolver = h.resolver
+	}
+	dial := func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialHost(ctx, &d, h.DNSServer, network, addr)
+	}
+	if h.ResolveOverride != "" {
+		addr, err := h.overrideAddr()
+		if err != nil {
+			return h, err
+		}
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	return h.withDial(dial), nil
+}
+
+// ipNetwork returns the network to look up addresses in, ip4 or ip6 if
+// the check Forces an address family, else ip.
+func (h HealthCheck) ipNetwork() (string, error) {
+	switch h.Force {
+	case "":
+		return "ip", nil
+	case "ipv4":
+		return "ip4", nil
+	case "ipv6":
+		return "ip6", nil
+	}
+	return "", fmt.Errorf("Force must be ipv4 or ipv6, not %q", h.Force)
+}
+
+// overrideAddr returns the ResolveOverride with the port of the URL if it
+// has none.
+func (h HealthCheck) overrideAddr() (string, error) {
+	switch h.checkType() {
+	case "http", "https", "tcp", "ws", "wss", "websocket":
+	default:
+		return "", fmt.Errorf("ResolveOverride isn't supported by %s checks", h.checkType())
+	}
+	if _, _, err := net.SplitHostPort(h.ResolveOverride); err == nil {
+		return h.ResolveOverride, nil
+	}
+	if net.ParseIP(h.ResolveOverride) == nil {
+		return "", fmt.Errorf("ResolveOverride %q is not an IP address with an optional port", h.ResolveOverride)
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %v", err)
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443", "ws": "80", "wss": "443"}[u.Scheme]
+	}
+	return net.JoinHostPort(h.ResolveOverride, port), nil
+}
+
+// lookup returns the resolver of h.
+func (h HealthCheck) lookup() *net.Resolver {
+	if h.resolver != nil {
+		return h.resolver
+	}
+	return net.DefaultResolver
+}
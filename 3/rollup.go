@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A history file is downsampled into the rollup files path.hourly and
+// path.daily, so long reports don't need to read every result.
+
+// rollup is the stats of a check over an hour or a day, stored as a JSON
+// line in a rollup file.
+type rollup struct {
+	Start time.Time // of the hour or day, in UTC
+	stats
+}
+
+// rollups downsamples the records added to a history file. Records are
+// expected roughly in time order; a late one counts in the current hour.
+type rollups struct {
+	path    string    // of the history file
+	hour    time.Time // start of the current hour
+	records []record  // of the current hour
+	hourly  []rollup  // of the current day
+}
+
+// openRollups continues the rollups of the history file at path with the
+// records of the current hour and the hourly rollups of the current day
+// stored so far, so a restart doesn't lose them. An hour or day that ends
+// while the history isn't open isn't rolled up.
+func openRollups(path string) (*rollups, error) {
+	now := time.Now().UTC()
+	r := &rollups{path: path, hour: now.Truncate(time.Hour)}
+	var err error
+	if r.records, err = readHistory(path, r.hour); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if r.hourly, err = readRollups(path+".hourly", now.Truncate(24*time.Hour)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return r, nil
+}
+
+// add adds rec and writes the rollups of the hour and day it ends.
+func (r *rollups) add(rec record) error {
+	hour := rec.Time.UTC().Truncate(time.Hour)
+	if hour.After(r.hour) {
+		var hourly []rollup
+		for _, s := range summarize(r.records) {
+			hourly = append(hourly, rollup{Start: r.hour, stats: s})
+		}
+		if err := appendRollups(r.path+".hourly", hourly); err != nil {
+			return err
+		}
+		r.hourly = append(r.hourly, hourly...)
+		r.records = nil
+
+		day := r.hour.Truncate(24 * time.Hour)
+		if hour.Truncate(24 * time.Hour).After(day) {
+			var daily []rollup
+			for _, s := range mergeRollups(r.hourly) {
+				daily = append(daily, rollup{Start: day, stats: s})
+			}
+			if err := appendRollups(r.path+".daily", daily); err != nil {
+				return err
+			}
+			r.hourly = nil
+		}
+		r.hour = hour
+	}
+	r.records = append(r.records, rec)
+	return nil
+}
+
+func appendRollups(path string, rs []rollup) error {
+	if len(rs) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, r := range rs {
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// readRollups returns the rollups stored in path that don't start before
+// since.
+func readRollups(path string, since time.Time) ([]rollup, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rs []rollup
+	dec := json.NewDecoder(f)
+	for {
+		var r rollup
+		err := dec.Decode(&r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if r.Start.Before(since) {
+			continue
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
+
+// mergeRollups combines the rollups of each check. Uptime and mean latency
+// are exact; the latency percentiles are the worst of the periods, as
+// percentiles can't be merged.
+func mergeRollups(rs []rollup) []stats {
+	byCheck := make(map[string]*stats)
+	healthy := make(map[string]float64)
+	var ss []stats
+	for _, r := range rs {
+		s, ok := byCheck[r.Check]
+		if !ok {
+			s = &stats{Check: r.Check}
+			byCheck[r.Check] = s
+		}
+		total := s.MeanLatency*time.Duration(s.Checks) + r.MeanLatency*time.Duration(r.Checks)
+		s.Checks += r.Checks
+		s.MeanLatency = total / time.Duration(max(s.Checks, 1))
+		s.P50Latency = max(s.P50Latency, r.P50Latency)
+		s.P95Latency = max(s.P95Latency, r.P95Latency)
+		s.P99Latency = max(s.P99Latency, r.P99Latency)
+		s.Downtime += r.Downtime
+		healthy[r.Check] += r.Uptime / 100 * float64(r.Checks)
+	}
+	for id, s := range byCheck {
+		s.Uptime = 100 * healthy[id] / float64(max(s.Checks, 1))
+		ss = append(ss, *s)
+	}
+	slices.SortFunc(ss, func(a, b stats) int { return strings.Compare(a.Check, b.Check) })
+	return ss
+}
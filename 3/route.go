@@ -0,0 +1,137 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// notifications route the alerts of checks to channels by their Tags and
+// Severity, e.g. failures of checks tagged prod and db to PagerDuty and all
+// others to Slack. Without them, alerts go to the notifiers of the
+// -pagerduty-key and -opsgenie-key flags.
+type notifications struct {
+	Channels map[string]channelConfig
+	Routes   []route // the first matching route is used
+}
+
+// channelConfig is a notifier. URL defaults to the service's API, except
+// for Slack where it's the incoming webhook. Key defaults to the check's
+// Notify keys.
+type channelConfig struct {
+	Type string // pagerduty, opsgenie or slack
+	URL  string `json:",omitempty"`
+	Key  string `json:",omitempty"` // better set as ${VAR}, see -secrets
+	Team string `json:",omitempty"` // Opsgenie responder, unless the check has its own
+}
+
+// route sends the alerts of matching checks to Channels, and to the
+// channels of each escalation once the outage lasted longer than its After.
+type route struct {
+	Tags     []string     `json:",omitempty"` // checks must have all of them
+	Severity []string     `json:",omitempty"` // checks must have one of them
+	Channels []string     `json:",omitempty"`
+	Escalate []escalation `json:",omitempty"`
+}
+
+type escalation struct {
+	After    duration
+	Channels []string
+}
+
+// notifyRoute is the route of a check with the notifiers of its channels.
+type notifyRoute struct {
+	route
+	channels map[string]notifier
+}
+
+func (c channelConfig) notifier() (notifier, error) {
+	switch c.Type {
+	case "pagerduty":
+		return pagerDuty{url: cmp.Or(c.URL, pagerDutyURL), key: c.Key, client: notifyClient}, nil
+	case "opsgenie":
+		return opsgenie{url: cmp.Or(c.URL, opsgenieURL), key: c.Key, team: c.Team, client: notifyClient}, nil
+	case "slack":
+		if c.URL == "" {
+			return nil, errors.New("missing URL of the Slack incoming webhook")
+		}
+		return slack{url: c.URL, client: notifyClient}, nil
+	}
+	return nil, fmt.Errorf("unknown Type %q, want pagerduty, opsgenie or slack", c.Type)
+}
+
+// routes returns a function that returns the route of a check, or nil if
+// none matches.
+func (n *notifications) routes() (func(HealthCheck) *notifyRoute, error) {
+	if n == nil {
+		return func(HealthCheck) *notifyRoute { return nil }, nil
+	}
+	var errs []error
+	channels := make(map[string]notifier)
+	for name, c := range n.Channels {
+		nf, err := c.notifier()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Channels: %s: %v", name, err))
+		}
+		channels[name] = nf
+	}
+	routes := make([]*notifyRoute, len(n.Routes))
+	for i, r := range n.Routes {
+		nr := &notifyRoute{route: r, channels: make(map[string]notifier)}
+		names := slices.Clone(r.Channels)
+		for _, e := range r.Escalate {
+			if e.After <= 0 {
+				errs = append(errs, fmt.Errorf("Routes[%d]: Escalate: After must be positive", i))
+			}
+			names = append(names, e.Channels...)
+		}
+		for _, name := range names {
+			nf, ok := channels[name]
+			if !ok {
+				errs = append(errs, fmt.Errorf("Routes[%d]: unknown channel %q", i, name))
+			}
+			nr.channels[name] = nf
+		}
+		routes[i] = nr
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return func(h HealthCheck) *notifyRoute {
+		for _, r := range routes {
+			if r.matches(h) {
+				return r
+			}
+		}
+		return nil
+	}, nil
+}
+
+func (r route) matches(h HealthCheck) bool {
+	for _, tag := range r.Tags {
+		if !slices.Contains(h.Tags, tag) {
+			return false
+		}
+	}
+	return len(r.Severity) == 0 || slices.ContainsFunc(r.Severity, func(s string) bool { return strings.EqualFold(s, h.Severity) })
+}
+
+// notifiers returns the notifiers of the channels of r after an outage of
+// the given length, by channel name.
+func (r *notifyRoute) notifiers(outage time.Duration) map[string]notifier {
+	out := make(map[string]notifier)
+	for _, name := range r.Channels {
+		out[name] = r.channels[name]
+	}
+	for _, e := range r.Escalate {
+		if outage >= time.Duration(e.After) {
+			for _, name := range e.Channels {
+				out[name] = r.channels[name]
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// sandboxReadable are the system paths a sandboxed daemon may read: name
+// resolution and CA certificates in /etc, CA bundles linked from there
+// and time zones.
+var sandboxReadable = []string{
+	"/etc",
+	"/usr/share/ca-certificates",
+	"/usr/local/share/ca-certificates",
+	"/usr/share/zoneinfo",
+}
+
+// enterSandbox switches to the user named username, if not empty, and then
+// limits the file system access of the daemon to reading the configs and
+// the system files checks need, and writing the history. It's called after
+// the files and sockets that need privileges have been opened.
+func enterSandbox(username string, configFiles []string, historyFile string, persist bool) error {
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			return err
+		}
+		uid, _ := strconv.Atoi(u.Uid)
+		gid, _ := strconv.Atoi(u.Gid)
+		if err := dropPrivileges(uid, gid); err != nil {
+			return fmt.Errorf("switching to user %s: %v", username, err)
+		}
+	}
+	var read []string
+	for _, f := range configFiles {
+		read = append(read, filepath.Dir(f))
+	}
+	read = append(read, sandboxReadable...)
+	if secretsFile != "" {
+		read = append(read, secretsFile)
+	}
+	var write []string
+	if historyFile != "" {
+		write = append(write, filepath.Dir(historyFile))
+	}
+	if persist {
+		// The config is replaced by renaming a temporary file next to it.
+		write = append(write, filepath.Dir(configFiles[0]))
+	}
+	return sandbox(read, write)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func sandbox(read, write []string) error {
+	return errors.New("-sandbox is only supported on Linux")
+}
+
+func dropPrivileges(uid, gid int) error {
+	return errors.New("-sandbox-user is only supported on Linux")
+}
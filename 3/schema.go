@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// configSchema returns a JSON Schema of the config file format. It's
+// generated from the Go types so it always matches this binary.
+func configSchema() map[string]any {
+	check := typeSchema(reflect.TypeFor[HealthCheck]())
+	// Env overrides are partial checks.
+	check["properties"].(map[string]any)["Env"] = map[string]any{
+		"type":                 "object",
+		"additionalProperties": map[string]any{"$ref": "#/$defs/check"},
+	}
+	checks := map[string]any{
+		"type":  "array",
+		"items": map[string]any{"$ref": "#/$defs/check"},
+	}
+	cfg := typeSchema(reflect.TypeFor[config]())
+	cfg["properties"].(map[string]any)["Checks"] = checks
+	cfg["properties"].(map[string]any)["$schema"] = map[string]any{"type": "string"}
+	return map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "Health checks config",
+		"oneOf":   []any{checks, cfg},
+		"$defs":   map[string]any{"check": check},
+	}
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	switch t {
+	case reflect.TypeFor[duration]():
+		return map[string]any{
+			"description": `nanoseconds, a duration like "2s", or "none"`,
+			"type":        []string{"integer", "string"},
+		}
+	case reflect.TypeFor[json.RawMessage]():
+		return map[string]any{}
+	case reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		props := make(map[string]any)
+		for i := range t.NumField() {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = typeSchema(f.Type)
+		}
+		return map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	}
+	return map[string]any{}
+}
+
+func schema(args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	fs.Parse(args)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(configSchema())
+}
+
+func (d *daemon) handleSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	json.NewEncoder(w).Encode(configSchema())
+}
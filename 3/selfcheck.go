@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// minFreeSpace is how much free disk space the history needs, below this
+// its self check fails.
+const minFreeSpace = 100 << 20
+
+// selfStatus returns the health of the daemon's own dependencies as checks
+// named self/..., so the monitoring is monitored too. Failed writes are
+// remembered from the last attempt, the rest is checked now.
+func (d *daemon) selfStatus() []checkStatus {
+	now := time.Now()
+	var out []checkStatus
+	add := func(name string, err error) {
+		cs := checkStatus{Name: "self/" + name, Tags: []string{"self"}, State: "healthy", LastCheck: now}
+		if err != nil {
+			cs.State, cs.Error = "unhealthy", err.Error()
+		}
+		out = append(out, cs)
+	}
+
+	files, err := configFiles(d.configFile)
+	for _, file := range files {
+		f, ferr := os.Open(file)
+		if ferr != nil {
+			err = errors.Join(err, ferr)
+			continue
+		}
+		f.Close()
+	}
+	add("config", err)
+
+	d.mu.Lock()
+	errs := make(map[string]error, len(d.selfErrs))
+	for name, err := range d.selfErrs {
+		errs[name] = err
+	}
+	d.mu.Unlock()
+	if d.coordinator != "" {
+		add("coordinator", errs["coordinator"])
+	}
+	if d.hist != nil {
+		add("history", errs["history"])
+	}
+	if d.historyFile != "" {
+		add("disk-space", checkFreeSpace(filepath.Dir(d.historyFile)))
+	}
+	if d.remote != nil {
+		add("remote-write", d.remote.err())
+	}
+	for _, src := range d.discoverers {
+		add(src.name(), errs[src.name()])
+	}
+	for _, s := range d.sinks {
+		add(s.name, s.err())
+	}
+	if d.kv != nil {
+		add("kv-export", errs["kv-export"])
+	}
+	if d.consul != nil {
+		add("consul", errs["consul"])
+	}
+	if err, ok := errs["notify"]; ok || len(d.notifiers) > 0 {
+		add("notify", err)
+	}
+	if d.chaos > 0 {
+		add("chaos", errs["chaos"])
+	}
+	return out
+}
+
+// report remembers the outcome of the last write to one of the daemon's
+// dependencies for its self check.
+func (d *daemon) report(name string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.selfErrs == nil {
+		d.selfErrs = make(map[string]error)
+	}
+	d.selfErrs[name] = err
+}
+
+func checkFreeSpace(dir string) error {
+	free, err := freeSpace(dir)
+	if err != nil {
+		return err
+	}
+	if free < minFreeSpace {
+		return fmt.Errorf("only %d MiB free in %s, want at least %d MiB", free>>20, dir, minFreeSpace>>20)
+	}
+	return nil
+}
+
+// handleHealthz reports whether the daemon itself is healthy, in the
+// format of Kubernetes' /healthz?verbose. Reasons are withheld here, the
+// status page shows them.
+func (d *daemon) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	var out strings.Builder
+	failed := false
+	for _, cs := range d.selfStatus() {
+		if cs.State != "healthy" {
+			failed = true
+			fmt.Fprintf(&out, "[-]%s failed: reason withheld\n", cs.Name)
+		} else {
+			fmt.Fprintf(&out, "[+]%s ok\n", cs.Name)
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if failed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "%shealthz check failed\n", out.String())
+		return
+	}
+	fmt.Fprintf(w, "%shealthz check passed\n", out.String())
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// alertPolicy decides when failures of a health check alert. The daemon
+// alerts on every transition to unhealthy, which is alertPolicy{1, 1}.
+type alertPolicy struct {
+	failures   int           // consecutive failures before alerting
+	recoveries int           // consecutive successes before the alert resolves
+	flapWindow time.Duration // see flapMax, zero means no flap detection
+	flapMax    int           // state changes within flapWindow above which alerts are suppressed
+	silences   []string      // glob patterns of check IDs whose alerts are silenced
+}
+
+// simulation is what an alertPolicy would have done with the stored
+// history of a single health check.
+type simulation struct {
+	Check     string
+	Baseline  int           // alerts of the daemon's policy
+	Alerts    int           // alerts of the simulated policy
+	Short     int           // failure streaks too short to alert
+	Flapping  int           // alerts suppressed as flapping
+	Silenced  int           // alerts suppressed by a silence
+	AlertTime time.Duration // time spent alerting
+}
+
+func simulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	historyFile := fs.String("history", "history.jsonl", "read results from `file` or a postgres:// URL")
+	window := fs.String("window", "7d", "replay the last `period` (e.g. 24h, 7d, 30d)")
+	format := fs.String("format", "table", "output `format`: table, json or csv")
+	var p alertPolicy
+	fs.IntVar(&p.failures, "failures", 1, "alert after `n` consecutive failures")
+	fs.IntVar(&p.recoveries, "recoveries", 1, "resolve alerts after `n` consecutive successes")
+	fs.DurationVar(&p.flapWindow, "flap-window", 0, "suppress alerts of checks that changed state more than -flap-max times within this `duration`")
+	fs.IntVar(&p.flapMax, "flap-max", 4, "most state changes within -flap-window that aren't flapping")
+	silence := fs.String("silence", "", "comma separated glob `patterns` of checks whose alerts are silenced")
+	version := addOutputVersionFlag(fs)
+	addLangFlag(fs)
+	fs.Parse(args)
+
+	if p.failures < 1 || p.recoveries < 1 {
+		return fmt.Errorf("-failures and -recoveries must be at least 1")
+	}
+	for _, s := range strings.Split(*silence, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			if _, err := path.Match(s, ""); err != nil {
+				return fmt.Errorf("-silence %q: %v", s, err)
+			}
+			p.silences = append(p.silences, s)
+		}
+	}
+	d, err := parseWindow(*window)
+	if err != nil {
+		return err
+	}
+	hist, err := openStore(*historyFile, false)
+	if err != nil {
+		return err
+	}
+	defer hist.Close()
+	records, err := hist.since(time.Now().Add(-d))
+	if err != nil {
+		return err
+	}
+	sims := simulateAll(records, p)
+
+	if *format != "table" || *version != 0 {
+		return writeOutput(*format, *version, simulationColumns, sims)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, tr("CHECK\tBASELINE\tALERTS\tSHORT\tFLAPPING\tSILENCED\tALERT TIME"))
+	var total simulation
+	for _, s := range sims {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%v\n", s.Check, s.Baseline, s.Alerts, s.Short, s.Flapping, s.Silenced, s.AlertTime.Round(time.Second))
+		total.Baseline += s.Baseline
+		total.Alerts += s.Alerts
+		total.Short += s.Short
+		total.Flapping += s.Flapping
+		total.Silenced += s.Silenced
+		total.AlertTime += s.AlertTime
+	}
+	fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%v\n", tr("TOTAL"), total.Baseline, total.Alerts, total.Short, total.Flapping, total.Silenced, total.AlertTime.Round(time.Second))
+	return tw.Flush()
+}
+
+// simulationColumns are the columns of simulate, see outputVersion.
+var simulationColumns = []column[simulation]{
+	{json: "Check", csv: "check", since: 1, value: func(s simulation) any { return s.Check }},
+	{json: "Baseline", csv: "baseline", since: 1, value: func(s simulation) any { return s.Baseline }},
+	{json: "Alerts", csv: "alerts", since: 1, value: func(s simulation) any { return s.Alerts }},
+	{json: "Short", csv: "short", since: 1, value: func(s simulation) any { return s.Short }},
+	{json: "Flapping", csv: "flapping", since: 1, value: func(s simulation) any { return s.Flapping }},
+	{json: "Silenced", csv: "silenced", since: 1, value: func(s simulation) any { return s.Silenced }},
+	{json: "AlertTimeMs", csv: "alert_time_ms", since: 1, value: func(s simulation) any { return s.AlertTime }},
+}
+
+// simulateAll replays records through p per health check. Results during
+// maintenance are skipped like the daemon does, and so are drills.
+func simulateAll(records []record, p alertPolicy) []simulation {
+	byID := make(map[string][]record)
+	for _, r := range records {
+		id := r.id()
+		if r.Site != "" {
+			id = r.Site + "/" + id
+		}
+		byID[id] = append(byID[id], r)
+	}
+	var sims []simulation
+	for id, rs := range byID {
+		slices.SortFunc(rs, func(a, b record) int { return a.Time.Compare(b.Time) })
+		rs = slices.DeleteFunc(rs, func(r record) bool { return r.Maintenance || r.Drill })
+		if len(rs) == 0 {
+			continue
+		}
+		s := p.replay(id, rs)
+		s.Baseline = alertPolicy{failures: 1, recoveries: 1}.replay(id, rs).Alerts
+		sims = append(sims, s)
+	}
+	slices.SortFunc(sims, func(a, b simulation) int { return strings.Compare(a.Check, b.Check) })
+	return sims
+}
+
+// replay returns what p would have done with the sorted results rs of the
+// health check id.
+func (p alertPolicy) replay(id string, rs []record) simulation {
+	s := simulation{Check: id}
+	silenced := slices.ContainsFunc(p.silences, func(pattern string) bool {
+		ok, _ := path.Match(pattern, id)
+		return ok
+	})
+	var changes []time.Time // of the state, for flap detection
+	var fails, oks int
+	var open, firing bool // an alert is open, and was sent rather than suppressed
+	var since time.Time
+	for i, r := range rs {
+		if i > 0 && r.Healthy != rs[i-1].Healthy {
+			changes = append(changes, r.Time)
+		}
+		if !r.Healthy {
+			oks = 0
+			fails++
+			if open || fails < p.failures {
+				continue
+			}
+			open, firing, since = true, false, r.Time
+			switch {
+			case silenced:
+				s.Silenced++
+			case p.flapping(changes, r.Time):
+				s.Flapping++
+			default:
+				s.Alerts++
+				firing = true
+			}
+			continue
+		}
+		if !open && fails > 0 {
+			s.Short++
+		}
+		fails = 0
+		if open {
+			if oks++; oks >= p.recoveries {
+				if firing {
+					s.AlertTime += r.Time.Sub(since)
+				}
+				open, oks = false, 0
+			}
+		}
+	}
+	if open && firing {
+		s.AlertTime += rs[len(rs)-1].Time.Sub(since)
+	}
+	return s
+}
+
+// flapping reports whether there were more than p.flapMax state changes
+// within p.flapWindow before now.
+func (p alertPolicy) flapping(changes []time.Time, now time.Time) bool {
+	if p.flapWindow <= 0 {
+		return false
+	}
+	n := 0
+	for _, t := range changes {
+		if now.Sub(t) <= p.flapWindow {
+			n++
+		}
+	}
+	return n > p.flapMax
+}
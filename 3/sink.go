@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sink pushes results as time-series points to a database, InfluxDB or
+// Graphite, for teams that don't scrape /metrics. Points are written in
+// batches; a failed batch is retried with the next flush up to retries
+// times, then dropped.
+type sink struct {
+	name    string // influxdb or graphite, also of its self check
+	write   func([]record) error
+	batch   int
+	flush   time.Duration
+	retries int
+	queue   chan record
+	done    chan struct{}
+
+	mu      sync.Mutex
+	lastErr error // of the last write
+}
+
+// sinkOptions are the batching and retry settings of the sinks.
+type sinkOptions struct {
+	batch   int           // most points per write
+	flush   time.Duration // how often to write
+	retries int           // of a failed batch before it's dropped
+}
+
+func newSink(name string, write func([]record) error, o sinkOptions) *sink {
+	s := &sink{
+		name:    name,
+		write:   write,
+		batch:   max(o.batch, 1),
+		flush:   o.flush,
+		retries: o.retries,
+		queue:   make(chan record, maxPending), // as many as wait for a slow write
+		done:    make(chan struct{}),
+	}
+	if s.flush <= 0 {
+		s.flush = 10 * time.Second
+	}
+	go s.run()
+	return s
+}
+
+// add queues r to be written. It doesn't block.
+func (s *sink) add(r record) {
+	select {
+	case s.queue <- r:
+	default:
+		slog.Warn("sink queue full, dropping result", "sink", s.name, "name", r.id())
+	}
+}
+
+func (s *sink) run() {
+	defer close(s.done)
+	var pending []record
+	attempts := 0 // of the first batch of pending
+	// flushBatches writes pending a batch at a time, stopping at the first
+	// error.
+	flushBatches := func() {
+		for len(pending) > 0 {
+			n := min(len(pending), s.batch)
+			err := s.write(pending[:n])
+			s.mu.Lock()
+			s.lastErr = err
+			s.mu.Unlock()
+			if err != nil {
+				attempts++
+				if attempts <= s.retries {
+					slog.Warn("can't write results, will retry", "sink", s.name, "results", n, "attempt", attempts, "err", err)
+					return
+				}
+				slog.Error("can't write results, dropping them", "sink", s.name, "results", n, "err", err)
+			}
+			pending, attempts = pending[n:], 0
+		}
+	}
+	tick := time.NewTicker(s.flush)
+	defer tick.Stop()
+	for {
+		select {
+		case r, ok := <-s.queue:
+			if !ok {
+				s.retries = 0 // no time to retry when shutting down
+				flushBatches()
+				return
+			}
+			pending = append(pending, r)
+			if len(pending) > maxPending {
+				pending, attempts = pending[len(pending)-maxPending:], 0
+			}
+			if len(pending) >= s.batch && attempts == 0 {
+				flushBatches()
+			}
+		case <-tick.C:
+			flushBatches()
+		}
+	}
+}
+
+// err returns the error of the last write, nil if it succeeded or there
+// was none yet.
+func (s *sink) err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// Close writes the remaining results.
+func (s *sink) Close() {
+	close(s.queue)
+	<-s.done
+}
+
+// influxWriter writes results to the InfluxDB write API at url, e.g.
+// http://influx:8086/api/v2/write?org=ops&bucket=checks or, for InfluxDB
+// 1.x, http://influx:8086/write?db=checks, in line protocol:
+//
+//	healthcheck,check=api,site=prague healthy=true,latency_ms=12.3 1700000000000000000
+func influxWriter(url, token, site string) func([]record) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(rs []record) error {
+		var buf bytes.Buffer
+		for _, r := range rs {
+			writeInfluxLine(&buf, r, site)
+		}
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(gzipBytes(buf.Bytes())))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		req.Header.Set("Content-Encoding", "gzip") // both InfluxDB 1.x and 2.x accept it
+		if token != "" {
+			req.Header.Set("Authorization", "Token "+token)
+		}
+		return doAccepted(client, req)
+	}
+}
+
+var (
+	influxTagEscaper    = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	influxStringEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+func writeInfluxLine(buf *bytes.Buffer, r record, site string) {
+	buf.WriteString("healthcheck,check=")
+	buf.WriteString(influxTagEscaper.Replace(r.id()))
+	if site != "" {
+		buf.WriteString(",site=")
+		buf.WriteString(influxTagEscaper.Replace(site))
+	}
+	fmt.Fprintf(buf, " healthy=%t,latency_ms=%s", r.Healthy, strconv.FormatFloat(ms(r.Latency), 'f', -1, 64))
+	if r.Maintenance {
+		buf.WriteString(",maintenance=true")
+	}
+	if r.Drill {
+		buf.WriteString(",drill=true")
+	}
+	if r.Timing != nil {
+		for _, p := range r.Timing.phases() {
+			if p.d > 0 {
+				fmt.Fprintf(buf, ",%s_ms=%s", p.name, strconv.FormatFloat(ms(p.d), 'f', -1, 64))
+			}
+		}
+	}
+	if r.Error != "" {
+		fmt.Fprintf(buf, `,error="%s"`, influxStringEscaper.Replace(r.Error))
+	}
+	fmt.Fprintf(buf, " %d\n", r.Time.UnixNano())
+}
+
+// graphiteWriter writes results to the Graphite plaintext protocol at
+// addr, e.g. graphite:2003, as metrics like
+//
+//	healthcheck.prague.api.healthy 1 1700000000
+//
+// With compress, each batch is sent as a gzip stream, for relays that
+// listen with gzip transport, e.g. carbon-c-relay. Carbon itself doesn't
+// decompress.
+func graphiteWriter(addr, prefix, site string, compress bool) func([]record) error {
+	return func(rs []record) error {
+		var buf bytes.Buffer
+		for _, r := range rs {
+			path := []string{graphiteName(r.id())}
+			if site != "" {
+				path = append([]string{graphiteName(site)}, path...)
+			}
+			if prefix != "" {
+				path = append([]string{prefix}, path...)
+			}
+			name := strings.Join(path, ".")
+			healthy := 0
+			if r.Healthy {
+				healthy = 1
+			}
+			t := r.Time.Unix()
+			fmt.Fprintf(&buf, "%s.healthy %d %d\n", name, healthy, t)
+			fmt.Fprintf(&buf, "%s.latency_ms %s %d\n", name, strconv.FormatFloat(ms(r.Latency), 'f', -1, 64), t)
+			if r.Timing != nil {
+				for _, p := range r.Timing.phases() {
+					if p.d > 0 {
+						fmt.Fprintf(&buf, "%s.%s_ms %s %d\n", name, p.name, strconv.FormatFloat(ms(p.d), 'f', -1, 64), t)
+					}
+				}
+			}
+		}
+		conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		body := buf.Bytes()
+		if compress {
+			body = gzipBytes(body)
+		}
+		if _, err := conn.Write(body); err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// graphiteName replaces the characters of s that Graphite treats
+// specially, e.g. the dots of a check named after its URL.
+func graphiteName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptrace"
+	"sync"
+)
+
+// source records the local end of the connections a check makes, so
+// results of multi-homed hosts tell which egress path was used.
+type source struct {
+	mu   sync.Mutex
+	addr net.Addr // of the last connection
+}
+
+type sourceKey struct{}
+
+// withSource returns ctx with a source that connections are recorded in.
+func withSource(ctx context.Context) (context.Context, *source) {
+	s := &source{}
+	return context.WithValue(ctx, sourceKey{}, s), s
+}
+
+// recordSource records the local address of conn in the source of ctx, if
+// it has one.
+func recordSource(ctx context.Context, conn net.Conn) {
+	if s, ok := ctx.Value(sourceKey{}).(*source); ok {
+		s.mu.Lock()
+		s.addr = conn.LocalAddr()
+		s.mu.Unlock()
+	}
+}
+
+// traceSource returns ctx with a trace that records the connections of
+// HTTP requests in the source of ctx.
+func traceSource(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { recordSource(ctx, info.Conn) },
+	})
+}
+
+// set sets the Source, Interface and Family of r from the last connection.
+// If connecting failed only the Family of the address dialed is known.
+func (s *source) set(r *Result) {
+	s.mu.Lock()
+	addr := s.addr
+	s.mu.Unlock()
+	if addr == nil {
+		var op *net.OpError
+		if errors.As(r.Err, &op) && op.Op == "dial" {
+			if ip := addrIP(op.Addr); ip != nil {
+				r.Family = family(ip)
+			}
+		}
+		return
+	}
+	ip := addrIP(addr)
+	if ip == nil {
+		return // e.g. a unix socket
+	}
+	r.Source = ip.String()
+	r.Family = family(ip)
+	r.Interface = interfaceOf(ip)
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+func family(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// interfaceOf returns the name of the network interface that has ip, or ""
+// if it can't be found.
+func interfaceOf(ip net.IP) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+				return iface.Name
+			}
+		}
+	}
+	return ""
+}
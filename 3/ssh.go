@@ -0,0 +1,625 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"slices"
+	"strings"
+)
+
+// SSH message numbers (RFC 4250).
+const (
+	sshMsgDisconnect      = 1
+	sshMsgIgnore          = 2
+	sshMsgDebug           = 4
+	sshMsgServiceRequest  = 5
+	sshMsgServiceAccept   = 6
+	sshMsgKexInit         = 20
+	sshMsgNewKeys         = 21
+	sshMsgKexECDHInit     = 30
+	sshMsgKexECDHReply    = 31
+	sshMsgUserauthRequest = 50
+	sshMsgUserauthFailure = 51
+	sshMsgUserauthSuccess = 52
+	sshMsgUserauthBanner  = 53
+)
+
+// The algorithms the SSH checks support, in order of preference.
+const (
+	sshMaxPacket             = 256 << 10
+	sshClientVersion         = "SSH-2.0-healthcheck"
+	sshKexAlgorithms         = "curve25519-sha256,curve25519-sha256@libssh.org"
+	sshHostKeyAlgorithms     = "ssh-ed25519,rsa-sha2-512,rsa-sha2-256"
+	sshCiphers               = "aes128-gcm@openssh.com,aes256-gcm@openssh.com"
+	sshMACs                  = "hmac-sha2-256,hmac-sha2-512" // unused with GCM but must be offered
+	sshCompressionAlgorithms = "none"
+)
+
+// sshChecker completes the SSH handshake with the server of e.g.
+// ssh://bastion.example.com and, with SSHKey, logs in as the user of the
+// URL. The Output is the server's version and host key fingerprint.
+type sshChecker struct {
+	h    HealthCheck
+	user string
+	addr string
+	key  crypto.Signer // nil means don't log in
+}
+
+func init() {
+	registerChecker("ssh", newSSHChecker)
+}
+
+func newSSHChecker(h HealthCheck) (Checker, error) {
+	u, err := parseURL(h, "ssh")
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	c := sshChecker{h: h, user: u.User.Username(), addr: net.JoinHostPort(u.Hostname(), port)}
+	if h.SSHKey != "" {
+		if c.user == "" {
+			return nil, errors.New("SSHKey needs a user in the URL, like ssh://user@host")
+		}
+		if c.key, err = readSSHKey(h.SSHKey); err != nil {
+			return nil, fmt.Errorf("SSHKey: %v", err)
+		}
+	}
+	return c, nil
+}
+
+func (c sshChecker) Check(ctx context.Context) Result {
+	conn, err := c.h.dialContext(ctx, c.addr)
+	if err != nil {
+		return Result{Err: err}
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	out, err := c.run(&sshConn{conn: conn, br: bufio.NewReader(conn)})
+	if ctx.Err() != nil && errors.Is(err, net.ErrClosed) {
+		err = fmt.Errorf("no answer: %v", ctx.Err())
+	}
+	return Result{Healthy: err == nil, Err: err, Output: out}
+}
+
+func (c sshChecker) run(s *sshConn) (string, error) {
+	version, err := s.exchangeVersions()
+	if err != nil {
+		return version, err
+	}
+	hostKey, err := s.handshake()
+	if err != nil {
+		return version, err
+	}
+	fingerprint := sha256.Sum256(hostKey)
+	out := fmt.Sprintf("%s, host key SHA256:%s", version, base64.RawStdEncoding.EncodeToString(fingerprint[:]))
+	if c.key != nil {
+		if err := s.login(c.user, c.key); err != nil {
+			return out, err
+		}
+		out += ", logged in as " + c.user
+	}
+	return out, nil
+}
+
+// sshConn speaks just enough of the SSH transport and user authentication
+// protocols (RFC 4253, RFC 4252) to complete the key exchange and log in
+// with a public key.
+type sshConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	clientVersion, serverVersion string
+	sessionID                    []byte
+
+	// After NEWKEYS packets are sealed with AES-GCM (RFC 5647).
+	seal, open           cipher.AEAD
+	sealNonce, openNonce []byte
+}
+
+// exchangeVersions sends the client's version and returns the server's.
+// Servers may send other lines before their version.
+func (s *sshConn) exchangeVersions() (string, error) {
+	s.clientVersion = sshClientVersion
+	if _, err := io.WriteString(s.conn, s.clientVersion+"\r\n"); err != nil {
+		return "", err
+	}
+	for range 32 {
+		line, err := s.br.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("reading the server's version: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if !strings.HasPrefix(line, "SSH-") {
+			continue
+		}
+		if !strings.HasPrefix(line, "SSH-2.0-") && !strings.HasPrefix(line, "SSH-1.99-") {
+			return line, fmt.Errorf("unsupported protocol version %q", line)
+		}
+		s.serverVersion = line
+		return line, nil
+	}
+	return "", errors.New("the server sent no version, is this SSH?")
+}
+
+// handshake exchanges keys with curve25519-sha256 and verifies the server's
+// signature of the exchange hash. It returns the server's host key.
+func (s *sshConn) handshake() ([]byte, error) {
+	cookie := make([]byte, 16)
+	rand.Read(cookie)
+	clientKexInit := append([]byte{sshMsgKexInit}, cookie...)
+	for _, list := range []string{sshKexAlgorithms, sshHostKeyAlgorithms, sshCiphers, sshCiphers, sshMACs, sshMACs, sshCompressionAlgorithms, sshCompressionAlgorithms, "", ""} {
+		clientKexInit = sshAppendString(clientKexInit, []byte(list))
+	}
+	clientKexInit = append(clientKexInit, 0, 0, 0, 0, 0) // first_kex_packet_follows, reserved
+	if err := s.writePacket(clientKexInit); err != nil {
+		return nil, err
+	}
+	serverKexInit, err := s.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	if serverKexInit[0] != sshMsgKexInit {
+		return nil, fmt.Errorf("expected KEXINIT, got message %d", serverKexInit[0])
+	}
+	if len(serverKexInit) < 1+16 {
+		return nil, errors.New("invalid KEXINIT: no cookie")
+	}
+	r := sshReader{b: serverKexInit[1+16:]}
+	var offered [10]string
+	for i := range offered {
+		offered[i] = string(r.string())
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid KEXINIT: %v", r.err)
+	}
+	var chosen [4]string
+	for i, a := range []struct{ what, ours string }{
+		{"key exchange", sshKexAlgorithms},
+		{"host key", sshHostKeyAlgorithms},
+		{"client to server cipher", sshCiphers},
+		{"server to client cipher", sshCiphers},
+	} {
+		if chosen[i] = sshNegotiate(a.ours, offered[i]); chosen[i] == "" {
+			return nil, fmt.Errorf("no common %s algorithm, the server offers %s", a.what, offered[i])
+		}
+	}
+	if sshNegotiate(sshCompressionAlgorithms, offered[6]) == "" {
+		return nil, fmt.Errorf("the server requires compression %s", offered[6])
+	}
+
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.writePacket(sshAppendString([]byte{sshMsgKexECDHInit}, priv.PublicKey().Bytes())); err != nil {
+		return nil, err
+	}
+	reply, err := s.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	if reply[0] != sshMsgKexECDHReply {
+		return nil, fmt.Errorf("expected KEX_ECDH_REPLY, got message %d", reply[0])
+	}
+	r = sshReader{b: reply[1:]}
+	hostKey, serverPublic, signature := r.string(), r.string(), r.string()
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid KEX_ECDH_REPLY: %v", r.err)
+	}
+	peer, err := ecdh.X25519().NewPublicKey(serverPublic)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	k := sshAppendMpint(nil, secret)
+	var exchange []byte
+	for _, b := range [][]byte{[]byte(s.clientVersion), []byte(s.serverVersion), clientKexInit, serverKexInit, hostKey, priv.PublicKey().Bytes(), serverPublic} {
+		exchange = sshAppendString(exchange, b)
+	}
+	sum := sha256.Sum256(append(exchange, k...))
+	h := sum[:]
+	if err := sshVerify(chosen[1], hostKey, h, signature); err != nil {
+		return hostKey, fmt.Errorf("host key signature: %v", err)
+	}
+	s.sessionID = h
+
+	if err := s.writePacket([]byte{sshMsgNewKeys}); err != nil {
+		return hostKey, err
+	}
+	p, err := s.readPacket()
+	if err != nil {
+		return hostKey, err
+	}
+	if p[0] != sshMsgNewKeys {
+		return hostKey, fmt.Errorf("expected NEWKEYS, got message %d", p[0])
+	}
+	derive := func(letter byte, n int) []byte {
+		sum := sha256.Sum256(slices.Concat(k, h, []byte{letter}, s.sessionID))
+		return sum[:n]
+	}
+	keyLen := func(cipher string) int {
+		if strings.HasPrefix(cipher, "aes256") {
+			return 32
+		}
+		return 16
+	}
+	if s.seal, err = newGCM(derive('C', keyLen(chosen[2]))); err != nil {
+		return hostKey, err
+	}
+	if s.open, err = newGCM(derive('D', keyLen(chosen[3]))); err != nil {
+		return hostKey, err
+	}
+	s.sealNonce, s.openNonce = derive('A', 12), derive('B', 12)
+	return hostKey, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// login authenticates as user with key, the "publickey" method.
+func (s *sshConn) login(user string, key crypto.Signer) error {
+	if err := s.writePacket(sshAppendString([]byte{sshMsgServiceRequest}, []byte("ssh-userauth"))); err != nil {
+		return err
+	}
+	p, err := s.readPacket()
+	if err != nil {
+		return err
+	}
+	if p[0] != sshMsgServiceAccept {
+		return fmt.Errorf("expected SERVICE_ACCEPT, got message %d", p[0])
+	}
+
+	algorithm, blob := sshPublicKey(key)
+	req := []byte{sshMsgUserauthRequest}
+	req = sshAppendString(req, []byte(user))
+	req = sshAppendString(req, []byte("ssh-connection"))
+	req = sshAppendString(req, []byte("publickey"))
+	req = append(req, 1)
+	req = sshAppendString(req, []byte(algorithm))
+	req = sshAppendString(req, blob)
+	signed := append(sshAppendString(nil, s.sessionID), req...)
+	var sig []byte
+	if algorithm == "ssh-ed25519" {
+		sig, err = key.Sign(rand.Reader, signed, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(signed)
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return err
+	}
+	req = sshAppendString(req, sshAppendString(sshAppendString(nil, []byte(algorithm)), sig))
+	if err := s.writePacket(req); err != nil {
+		return err
+	}
+	for {
+		p, err := s.readPacket()
+		if err != nil {
+			return err
+		}
+		switch p[0] {
+		case sshMsgUserauthSuccess:
+			return nil
+		case sshMsgUserauthFailure:
+			r := sshReader{b: p[1:]}
+			return fmt.Errorf("the server rejected the key for %s, it allows %s", user, r.string())
+		case sshMsgUserauthBanner:
+		default:
+			return fmt.Errorf("unexpected message %d during authentication", p[0])
+		}
+	}
+}
+
+// writePacket sends payload as a binary packet, sealed once keys are
+// exchanged.
+func (s *sshConn) writePacket(payload []byte) error {
+	block := 8
+	if s.seal != nil {
+		block = 16
+	}
+	// The packet length isn't encrypted with GCM, so it doesn't count.
+	n := 1 + len(payload)
+	if s.seal == nil {
+		n += 4
+	}
+	padding := block - n%block
+	if padding < 4 {
+		padding += block
+	}
+	packet := binary.BigEndian.AppendUint32(nil, uint32(1+len(payload)+padding))
+	packet = append(packet, byte(padding))
+	packet = append(packet, payload...)
+	pad := make([]byte, padding)
+	rand.Read(pad)
+	packet = append(packet, pad...)
+	if s.seal != nil {
+		packet = s.seal.Seal(packet[:4], s.sealNonce, packet[4:], packet[:4])
+		sshIncrementNonce(s.sealNonce)
+	}
+	_, err := s.conn.Write(packet)
+	return err
+}
+
+// readPacket returns the payload of the next packet, skipping IGNORE and
+// DEBUG messages.
+func (s *sshConn) readPacket() ([]byte, error) {
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(s.br, hdr[:]); err != nil {
+			return nil, err
+		}
+		n := binary.BigEndian.Uint32(hdr[:])
+		if n < 5 || n > sshMaxPacket {
+			return nil, fmt.Errorf("invalid packet length %d", n)
+		}
+		extra := 0
+		if s.open != nil {
+			extra = s.open.Overhead()
+		}
+		packet := make([]byte, int(n)+extra)
+		if _, err := io.ReadFull(s.br, packet); err != nil {
+			return nil, err
+		}
+		if s.open != nil {
+			var err error
+			if packet, err = s.open.Open(packet[:0], s.openNonce, packet, hdr[:]); err != nil {
+				return nil, errors.New("invalid packet authentication tag")
+			}
+			sshIncrementNonce(s.openNonce)
+		}
+		padding := int(packet[0])
+		if 1+padding >= len(packet) {
+			return nil, errors.New("invalid packet padding")
+		}
+		payload := packet[1 : len(packet)-padding]
+		switch payload[0] {
+		case sshMsgIgnore, sshMsgDebug:
+			continue
+		case sshMsgDisconnect:
+			r := sshReader{b: payload[1:]}
+			code := r.uint32()
+			return nil, fmt.Errorf("the server disconnected: %s (%d)", r.string(), code)
+		}
+		return payload, nil
+	}
+}
+
+// sshIncrementNonce increments the invocation counter, the last 8 bytes of
+// a GCM nonce.
+func sshIncrementNonce(nonce []byte) {
+	binary.BigEndian.PutUint64(nonce[4:], binary.BigEndian.Uint64(nonce[4:])+1)
+}
+
+// sshNegotiate returns the first of our comma separated algorithms that
+// the server offers too, or "" if there is none.
+func sshNegotiate(ours, theirs string) string {
+	offered := strings.Split(theirs, ",")
+	for _, a := range strings.Split(ours, ",") {
+		if slices.Contains(offered, a) {
+			return a
+		}
+	}
+	return ""
+}
+
+// sshVerify verifies the server's signature of the exchange hash h with
+// its host key.
+func sshVerify(algorithm string, hostKey, h, signature []byte) error {
+	r := sshReader{b: hostKey}
+	keyType := string(r.string())
+	sr := sshReader{b: signature}
+	sigType, sig := string(sr.string()), sr.string()
+	if sr.err != nil {
+		return sr.err
+	}
+	if sigType != algorithm {
+		return fmt.Errorf("got a %s signature, want %s", sigType, algorithm)
+	}
+	switch algorithm {
+	case "ssh-ed25519":
+		pub := r.string()
+		if r.err != nil || keyType != algorithm || len(pub) != ed25519.PublicKeySize {
+			return errors.New("invalid ssh-ed25519 host key")
+		}
+		if !ed25519.Verify(pub, h, sig) {
+			return errors.New("verification failed")
+		}
+		return nil
+	case "rsa-sha2-256", "rsa-sha2-512":
+		e, n := r.mpint(), r.mpint()
+		if r.err != nil || keyType != "ssh-rsa" || !e.IsInt64() || e.Int64() > 1<<31 {
+			return errors.New("invalid ssh-rsa host key")
+		}
+		pub := &rsa.PublicKey{N: n, E: int(e.Int64())}
+		if algorithm == "rsa-sha2-256" {
+			digest := sha256.Sum256(h)
+			return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+		}
+		digest := sha512.Sum512(h)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA512, digest[:], sig)
+	}
+	return fmt.Errorf("unsupported host key algorithm %s", algorithm)
+}
+
+// sshPublicKey returns the algorithm with which key signs and its public
+// key in SSH's wire format.
+func sshPublicKey(key crypto.Signer) (string, []byte) {
+	switch pub := key.Public().(type) {
+	case ed25519.PublicKey:
+		return "ssh-ed25519", sshAppendString(sshAppendString(nil, []byte("ssh-ed25519")), pub)
+	case *rsa.PublicKey:
+		blob := sshAppendString(nil, []byte("ssh-rsa"))
+		blob = sshAppendMpint(blob, big.NewInt(int64(pub.E)).Bytes())
+		return "rsa-sha2-256", sshAppendMpint(blob, pub.N.Bytes())
+	}
+	panic("unreachable")
+}
+
+// readSSHKey reads an unencrypted Ed25519 or RSA private key from an
+// OpenSSH or PEM (PKCS #1, PKCS #8) file.
+func readSSHKey(name string) (crypto.Signer, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var key any
+	switch block.Type {
+	case "OPENSSH PRIVATE KEY":
+		key, err = parseOpenSSHKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		return key, nil
+	case *rsa.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key %T, use an Ed25519 or RSA key", key)
+}
+
+// parseOpenSSHKey parses the openssh-key-v1 format of ssh-keygen.
+func parseOpenSSHKey(b []byte) (any, error) {
+	const magic = "openssh-key-v1\x00"
+	if !bytes.HasPrefix(b, []byte(magic)) {
+		return nil, errors.New("invalid OpenSSH key")
+	}
+	r := sshReader{b: b[len(magic):]}
+	cipherName := string(r.string())
+	r.string() // KDF name
+	r.string() // KDF options
+	if r.uint32() != 1 {
+		return nil, errors.New("OpenSSH key files with several keys aren't supported")
+	}
+	r.string() // public key
+	private := sshReader{b: r.string()}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid OpenSSH key: %v", r.err)
+	}
+	if cipherName != "none" {
+		return nil, errors.New("the key is encrypted with a passphrase, which isn't supported")
+	}
+	if private.uint32() != private.uint32() {
+		return nil, errors.New("invalid OpenSSH key check bytes")
+	}
+	var key any
+	switch keyType := string(private.string()); keyType {
+	case "ssh-ed25519":
+		private.string() // public key
+		priv := private.string()
+		if len(priv) != ed25519.PrivateKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		key = ed25519.PrivateKey(priv)
+	case "ssh-rsa":
+		n, e, d, _, p, q := private.mpint(), private.mpint(), private.mpint(), private.mpint(), private.mpint(), private.mpint()
+		if private.err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid RSA key")
+		}
+		rk := &rsa.PrivateKey{PublicKey: rsa.PublicKey{N: n, E: int(e.Int64())}, D: d, Primes: []*big.Int{p, q}}
+		if err := rk.Validate(); err != nil {
+			return nil, err
+		}
+		rk.Precompute()
+		key = rk
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", keyType)
+	}
+	if private.err != nil {
+		return nil, fmt.Errorf("invalid OpenSSH key: %v", private.err)
+	}
+	return key, nil
+}
+
+// sshReader reads SSH's data types (RFC 4251), remembering the first
+// error.
+type sshReader struct {
+	b   []byte
+	err error
+}
+
+func (r *sshReader) uint32() uint32 {
+	if len(r.b) < 4 {
+		r.err = cmp.Or(r.err, io.ErrUnexpectedEOF)
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *sshReader) string() []byte {
+	n := r.uint32()
+	if r.err != nil || uint32(len(r.b)) < n {
+		r.err = cmp.Or(r.err, io.ErrUnexpectedEOF)
+		return nil
+	}
+	s := r.b[:n]
+	r.b = r.b[n:]
+	return s
+}
+
+func (r *sshReader) mpint() *big.Int {
+	return new(big.Int).SetBytes(r.string())
+}
+
+func sshAppendString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// sshAppendMpint appends the unsigned big-endian number n as an mpint.
+func sshAppendMpint(b, n []byte) []byte {
+	for len(n) > 0 && n[0] == 0 {
+		n = n[1:]
+	}
+	if len(n) > 0 && n[0]&0x80 != 0 {
+		n = append([]byte{0}, n...)
+	}
+	return sshAppendString(b, n)
+}
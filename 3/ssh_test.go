@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The test server is written from RFC 4253, RFC 5656, RFC 8731 and
+// RFC 5647 rather than with the helpers of ssh.go, so a mistake in those
+// doesn't cancel out.
+
+func sshTestString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// sshTestPacket frames payload without encryption.
+func sshTestPacket(payload []byte) []byte {
+	padding := 8 - (4+1+len(payload))%8
+	if padding < 4 {
+		padding += 8
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(1+len(payload)+padding))
+	b = append(b, byte(padding))
+	b = append(b, payload...)
+	return append(b, make([]byte, padding)...)
+}
+
+func sshTestReadPacket(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	p := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+	if _, err := io.ReadFull(r, p); err != nil {
+		return nil, err
+	}
+	return p[1 : len(p)-int(p[0])], nil
+}
+
+// sshTestKexInit returns a server's KEXINIT offering kex, host key and
+// cipher algorithms.
+func sshTestKexInit(kex, hostKey, cipher string) []byte {
+	b := append([]byte{sshMsgKexInit}, make([]byte, 16)...)
+	for _, list := range []string{kex, hostKey, cipher, cipher, "hmac-sha2-256", "hmac-sha2-256", "none", "none", "", ""} {
+		b = sshTestString(b, list)
+	}
+	return append(b, 0, 0, 0, 0, 0)
+}
+
+// sshTestServer does the key exchange with the client on conn as an
+// OpenSSH server with hostKey would and returns the exchange hash, the
+// host key blob and the payload of the client's first encrypted packet.
+func sshTestServer(conn net.Conn, hostKey ed25519.PrivateKey) (h, blob, first []byte, err error) {
+	br := bufio.NewReader(conn)
+	clientVersion, err := br.ReadString('\n')
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	clientVersion = strings.TrimSuffix(clientVersion, "\r\n")
+	const serverVersion = "SSH-2.0-OpenSSH_9.6"
+	io.WriteString(conn, "a banner line\r\n"+serverVersion+"\r\n")
+	clientKexInit, err := sshTestReadPacket(br)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	serverKexInit := sshTestKexInit("curve25519-sha256@libssh.org", "ssh-ed25519", "aes256-gcm@openssh.com")
+	conn.Write(sshTestPacket(serverKexInit))
+
+	ecdhInit, err := sshTestReadPacket(br)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(ecdhInit) != 1+4+32 || ecdhInit[0] != sshMsgKexECDHInit {
+		return nil, nil, nil, errors.New("invalid KEX_ECDH_INIT")
+	}
+	clientPublic := ecdhInit[1+4:]
+	peer, err := ecdh.X25519().NewPublicKey(clientPublic)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	priv, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	secret, _ := priv.ECDH(peer)
+	// The shared secret as an mpint: without leading zeros, with a zero
+	// byte before a set high bit.
+	k := bytes.TrimLeft(secret, "\x00")
+	if len(k) > 0 && k[0] >= 0x80 {
+		k = append([]byte{0}, k...)
+	}
+	k = sshTestString(nil, string(k))
+
+	blob = sshTestString(sshTestString(nil, "ssh-ed25519"), string(hostKey.Public().(ed25519.PublicKey)))
+	hash := sha256.New()
+	for _, s := range [][]byte{[]byte(clientVersion), []byte(serverVersion), clientKexInit, serverKexInit, blob, clientPublic, priv.PublicKey().Bytes()} {
+		hash.Write(sshTestString(nil, string(s)))
+	}
+	hash.Write(k)
+	h = hash.Sum(nil)
+	signature := sshTestString(sshTestString(nil, "ssh-ed25519"), string(ed25519.Sign(hostKey, h)))
+	reply := []byte{sshMsgKexECDHReply}
+	for _, s := range [][]byte{blob, priv.PublicKey().Bytes(), signature} {
+		reply = sshTestString(reply, string(s))
+	}
+	conn.Write(sshTestPacket(reply))
+
+	if p, err := sshTestReadPacket(br); err != nil || !bytes.Equal(p, []byte{sshMsgNewKeys}) {
+		return nil, nil, nil, errors.New("no NEWKEYS")
+	}
+	conn.Write(sshTestPacket([]byte{sshMsgNewKeys}))
+
+	// The client to server key and IV, the session ID being h.
+	derive := func(letter byte, n int) []byte {
+		sum := sha256.Sum256(bytes.Join([][]byte{k, h, {letter}, h}, nil))
+		return sum[:n]
+	}
+	block, _ := aes.NewCipher(derive('C', 32))
+	gcm, _ := cipher.NewGCM(block)
+	var hdr [4]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, nil, nil, err
+	}
+	sealed := make([]byte, binary.BigEndian.Uint32(hdr[:])+uint32(gcm.Overhead()))
+	if _, err := io.ReadFull(br, sealed); err != nil {
+		return nil, nil, nil, err
+	}
+	p, err := gcm.Open(nil, derive('A', 12), sealed, hdr[:])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return h, blob, p[1 : len(p)-int(p[0])], nil
+}
+
+func TestSSHHandshake(t *testing.T) {
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	client, server := net.Pipe()
+	defer client.Close()
+	type result struct {
+		h, blob, first []byte
+		err            error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer server.Close()
+		h, blob, first, err := sshTestServer(server, hostKey)
+		done <- result{h, blob, first, err}
+	}()
+
+	s := &sshConn{conn: client, br: bufio.NewReader(client)}
+	version, err := s.exchangeVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "SSH-2.0-OpenSSH_9.6" {
+		t.Errorf("version %q", version)
+	}
+	blob, err := s.handshake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := sshAppendString([]byte{sshMsgServiceRequest}, []byte("ssh-userauth"))
+	if err := s.writePacket(request); err != nil {
+		t.Fatal(err)
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("server: %v", r.err)
+	}
+	if !bytes.Equal(blob, r.blob) {
+		t.Errorf("host key %x, want %x", blob, r.blob)
+	}
+	if !bytes.Equal(s.sessionID, r.h) {
+		t.Errorf("session ID %x, want the exchange hash %x", s.sessionID, r.h)
+	}
+	if !bytes.Equal(r.first, request) {
+		t.Errorf("the server decrypted %q, want %q", r.first, request)
+	}
+}
+
+func TestSSHHandshakeErrors(t *testing.T) {
+	serverPublic := make([]byte, 32)
+	serverPublic[0] = 9 // the base point, a valid X25519 public key
+	for _, tt := range []struct {
+		name    string
+		server  []byte // after the version
+		wantErr string
+	}{
+		{
+			name:    "kexinit without cookie",
+			server:  sshTestPacket([]byte{sshMsgKexInit, 1, 2, 3}),
+			wantErr: "invalid KEXINIT: no cookie",
+		},
+		{
+			name:    "kexinit without lists",
+			server:  sshTestPacket(append([]byte{sshMsgKexInit}, make([]byte, 16)...)),
+			wantErr: "invalid KEXINIT",
+		},
+		{
+			name:    "kexinit with a list beyond its end",
+			server:  sshTestPacket(append(append([]byte{sshMsgKexInit}, make([]byte, 16)...), 0, 0, 1, 0, 'x')),
+			wantErr: "invalid KEXINIT",
+		},
+		{
+			name:    "not kexinit",
+			server:  sshTestPacket([]byte{sshMsgNewKeys}),
+			wantErr: "expected KEXINIT, got message 21",
+		},
+		{
+			name:    "no common key exchange",
+			server:  sshTestPacket(sshTestKexInit("diffie-hellman-group14-sha256", "ssh-ed25519", "aes128-gcm@openssh.com")),
+			wantErr: "no common key exchange algorithm",
+		},
+		{
+			name:    "no common cipher",
+			server:  sshTestPacket(sshTestKexInit("curve25519-sha256", "ssh-ed25519", "aes128-ctr")),
+			wantErr: "no common client to server cipher algorithm",
+		},
+		{
+			name:    "packet length too small",
+			server:  []byte{0, 0, 0, 1, 0},
+			wantErr: "invalid packet length 1",
+		},
+		{
+			name:    "packet length too large",
+			server:  []byte{0xff, 0xff, 0xff, 0xff},
+			wantErr: "invalid packet length",
+		},
+		{
+			name:    "padding beyond the packet",
+			server:  []byte{0, 0, 0, 5, 4, sshMsgKexInit, 0, 0, 0},
+			wantErr: "invalid packet padding",
+		},
+		{
+			name:    "truncated packet",
+			server:  sshTestPacket(sshTestKexInit("curve25519-sha256", "ssh-ed25519", "aes128-gcm@openssh.com"))[:40],
+			wantErr: "EOF",
+		},
+		{
+			name:    "disconnect",
+			server:  sshTestPacket(sshTestString(binary.BigEndian.AppendUint32([]byte{sshMsgDisconnect}, 2), "bye")),
+			wantErr: "the server disconnected: bye (2)",
+		},
+		{
+			name:    "truncated disconnect",
+			server:  sshTestPacket([]byte{sshMsgDisconnect, 0}),
+			wantErr: "the server disconnected",
+		},
+		{
+			name: "truncated ecdh reply",
+			server: slices.Concat(
+				sshTestPacket(sshTestKexInit("curve25519-sha256", "ssh-ed25519", "aes128-gcm@openssh.com")),
+				sshTestPacket([]byte{sshMsgKexECDHReply, 0, 0, 0, 9}),
+			),
+			wantErr: "invalid KEX_ECDH_REPLY",
+		},
+		{
+			name: "ecdh reply with an invalid signature",
+			server: slices.Concat(
+				sshTestPacket(sshTestKexInit("curve25519-sha256", "ssh-ed25519", "aes128-gcm@openssh.com")),
+				sshTestPacket(sshTestString(sshTestString(sshTestString([]byte{sshMsgKexECDHReply},
+					string(sshTestString(sshTestString(nil, "ssh-ed25519"), strings.Repeat("k", 32)))),
+					string(serverPublic)),
+					string(sshTestString(sshTestString(nil, "ssh-ed25519"), strings.Repeat("s", 64))))),
+			),
+			wantErr: "host key signature: verification failed",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(append([]byte("SSH-2.0-test\r\n"), tt.server...))
+			s := &sshConn{conn: &scriptedConn{r: r}, br: bufio.NewReader(r)}
+			if _, err := s.exchangeVersions(); err != nil {
+				t.Fatal(err)
+			}
+			_, err := s.handshake()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestSSHVerify uses test 1 of RFC 8032, the signature of the empty
+// message.
+func TestSSHVerify(t *testing.T) {
+	pub, _ := hex.DecodeString("d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a")
+	sig, _ := hex.DecodeString("e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b")
+	hostKey := sshTestString(sshTestString(nil, "ssh-ed25519"), string(pub))
+	signature := sshTestString(sshTestString(nil, "ssh-ed25519"), string(sig))
+	tampered := bytes.Clone(signature)
+	tampered[len(tampered)-1] ^= 1
+	for _, tt := range []struct {
+		name               string
+		algorithm          string
+		hostKey, signature []byte
+		h                  []byte
+		wantErr            bool
+	}{
+		{"valid", "ssh-ed25519", hostKey, signature, nil, false},
+		{"other message", "ssh-ed25519", hostKey, signature, []byte("h"), true},
+		{"tampered", "ssh-ed25519", hostKey, tampered, nil, true},
+		{"other algorithm", "rsa-sha2-256", hostKey, signature, nil, true},
+		{"truncated host key", "ssh-ed25519", hostKey[:len(hostKey)-1], signature, nil, true},
+		{"truncated signature", "ssh-ed25519", hostKey, signature[:len(signature)-1], nil, true},
+		{"empty signature", "ssh-ed25519", hostKey, nil, nil, true},
+		{"empty host key", "ssh-ed25519", nil, signature, nil, true},
+		{"rsa host key of ed25519", "rsa-sha2-256", hostKey, sshTestString(sshTestString(nil, "rsa-sha2-256"), "s"), nil, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := sshVerify(tt.algorithm, tt.hostKey, tt.h, tt.signature)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestSSHAppendMpint uses the examples of RFC 4251, section 5.
+func TestSSHAppendMpint(t *testing.T) {
+	for _, tt := range []struct{ n, want string }{
+		{"", "00000000"},
+		{"00", "00000000"},
+		{"09a378f9b2e332a7", "0000000809a378f9b2e332a7"},
+		{"80", "000000020080"},
+		{"0080", "000000020080"},
+	} {
+		n, _ := hex.DecodeString(tt.n)
+		if got := hex.EncodeToString(sshAppendMpint(nil, n)); got != tt.want {
+			t.Errorf("sshAppendMpint(%s) = %s, want %s", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestSSHNegotiate(t *testing.T) {
+	for _, tt := range []struct{ ours, theirs, want string }{
+		{sshKexAlgorithms, "curve25519-sha256@libssh.org,curve25519-sha256", "curve25519-sha256"},
+		{sshKexAlgorithms, "ecdh-sha2-nistp256,curve25519-sha256@libssh.org", "curve25519-sha256@libssh.org"},
+		{sshHostKeyAlgorithms, "rsa-sha2-256,rsa-sha2-512", "rsa-sha2-512"},
+		{sshCiphers, "chacha20-poly1305@openssh.com", ""},
+		{sshCiphers, "", ""},
+		{sshCompressionAlgorithms, "zlib@openssh.com,none", "none"},
+	} {
+		if got := sshNegotiate(tt.ours, tt.theirs); got != tt.want {
+			t.Errorf("sshNegotiate(%q, %q) = %q, want %q", tt.ours, tt.theirs, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// checkStatus is the current state of a health check as shown on the
+// status page and returned by /api/status.
+type checkStatus struct {
+	Name            string   `json:",omitempty"`
+	Tags            []string `json:",omitempty"`
+	URL             string
+	State           string    // unknown, paused, skipped, healthy or unhealthy
+	Shadow          bool      // added or changed recently, failures aren't alerted on
+	Maintenance     bool      `json:",omitempty"` // in a maintenance window
+	SnoozedUntil    time.Time `json:",omitzero"`  // failures aren't alerted on until then
+	LastCheck       time.Time
+	Error           string `json:",omitempty"`
+	RecentLatencyMs []float64
+	Uptime          float64 // percent of healthy results since the daemon started
+
+	// Percentiles of the latency of the last -latency-window results.
+	P50LatencyMs float64 `json:",omitempty"`
+	P95LatencyMs float64 `json:",omitempty"`
+	P99LatencyMs float64 `json:",omitempty"`
+}
+
+func (d *daemon) status() []checkStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []checkStatus
+	for _, e := range d.entries {
+		if !d.filter.match(e.check) {
+			continue
+		}
+		s := *e.state
+		_, maintenance := e.check.maintenance(time.Now())
+		cs := checkStatus{
+			Name:        e.check.Name,
+			Tags:        e.check.Tags,
+			URL:         redactURL(e.check.URL),
+			State:       "unknown",
+			Shadow:      time.Now().Before(e.shadowUntil),
+			Maintenance: maintenance,
+			LastCheck:   s.lastCheck,
+		}
+		if time.Now().Before(s.snoozeUntil) {
+			cs.SnoozedUntil = s.snoozeUntil
+		}
+		switch {
+		case e.check.Paused || time.Now().Before(s.pausedUntil):
+			cs.State = "paused"
+		case s.skippedFor != "":
+			cs.State = "skipped"
+			cs.Error = "dependency " + s.skippedFor + " is down"
+		case s.checked && s.healthy:
+			cs.State = "healthy"
+		case s.checked:
+			cs.State = "unhealthy"
+		}
+		if s.lastErr != nil && cs.Error == "" {
+			cs.Error = s.lastErr.Error()
+		}
+		if s.runs > 0 {
+			cs.Uptime = 100 * float64(s.upRuns) / float64(s.runs)
+		}
+		for _, l := range s.latencies {
+			cs.RecentLatencyMs = append(cs.RecentLatencyMs, ms(l))
+		}
+		if s.latency != nil {
+			cs.P50LatencyMs = ms(s.latency.percentile(50))
+			cs.P95LatencyMs = ms(s.latency.percentile(95))
+			cs.P99LatencyMs = ms(s.latency.percentile(99))
+		}
+		out = append(out, cs)
+	}
+	return out
+}
+
+// schedulerStatus tells whether the daemon keeps up with its interval.
+type schedulerStatus struct {
+	Degraded bool    // non-critical checks run less often
+	LagMs    float64 // how much longer than the interval the last run took
+	Stretch  int     // non-critical checks run every Stretch intervals
+}
+
+func (d *daemon) schedulerStatus() schedulerStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return schedulerStatus{Degraded: d.stretch > 1, LagMs: ms(d.lag), Stretch: d.stretch}
+}
+
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", d.handleStatusPage)
+	mux.HandleFunc("GET /api/status", d.handleStatusAPI)
+	mux.HandleFunc("GET /healthz", d.handleHealthz)
+	mux.Handle("GET /metrics", &d.phases)
+	mux.HandleFunc("GET /api/scheduler", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, d.schedulerStatus())
+	})
+	mux.HandleFunc("GET /api/config/diff", d.handleConfigDiff)
+	mux.HandleFunc("GET /api/schema", d.handleSchema)
+	mux.HandleFunc("GET /api/checks", d.handleListChecks)
+	mux.HandleFunc("POST /api/checks", d.mutating(d.handleAddCheck))
+	mux.HandleFunc("DELETE /api/checks", d.mutating(d.handleDeleteCheck))
+	mux.HandleFunc("POST /api/checks/pause", d.mutating(d.handlePauseCheck(true)))
+	mux.HandleFunc("POST /api/checks/resume", d.mutating(d.handlePauseCheck(false)))
+	mux.HandleFunc("POST /api/checks/drill", d.mutating(d.handleTimed("drill")))
+	mux.HandleFunc("POST /api/checks/snooze", d.mutating(d.handleTimed("snooze")))
+	mux.HandleFunc("POST /api/checks/bulk", d.mutating(d.handleBulk))
+	return mux
+}
+
+// mutating wraps a handler that changes the daemon, refusing the request
+// in read-only mode, without an -api-token and unless it bears that token.
+func (d *daemon) mutating(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if d.readOnly {
+			http.Error(w, "read-only mode", http.StatusForbidden)
+			return
+		}
+		if d.apiToken == "" {
+			http.Error(w, "changes via the API need -api-token", http.StatusForbidden)
+			return
+		}
+		if token, ok := bearerToken(r); !ok || !sameToken(token, d.apiToken) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing or wrong bearer token", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (d *daemon) handleStatusAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, append(d.status(), d.selfStatus()...))
+}
+
+// handleConfigDiff returns what the last reload changed, or null if there
+// was no reload yet.
+func (d *daemon) handleConfigDiff(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	diff := d.lastDiff
+	d.mu.Unlock()
+	writeJSON(w, http.StatusOK, diff)
+}
+
+// pageFuncs are the template functions of the HTML pages.
+var pageFuncs = template.FuncMap{
+	"ago": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return time.Since(t).Round(time.Second).String() + " ago"
+	},
+	"join": strings.Join,
+}
+
+var statusPage = template.Must(template.New("status").Funcs(pageFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>Health checks</title>
+<style>
+body { font-family: sans-serif; }
+td, th { padding: 4px 12px; text-align: left; }
+.healthy { color: green; }
+.unhealthy { color: red; }
+.unknown, .paused, .skipped { color: gray; }
+.banner { background: #fff3cd; padding: 8px 12px; }
+</style>
+</head>
+<body>
+<h1>Health checks</h1>
+{{with .Scheduler}}{{if .Degraded}}<p class="banner" role="alert">The checker is falling behind: non-critical checks run only every {{.Stretch}} intervals.</p>{{end}}{{end}}
+<table>
+<tr><th>Name</th><th>URL</th><th>State</th><th>Last check</th><th>Recent latency (ms)</th><th>p50 / p95 / p99 (ms)</th><th>Error</th></tr>
+{{range .Checks}}<tr>
+<td>{{.Name}}</td>
+<td>{{.URL}}</td>
+<td class="{{.State}}">{{.State}}{{if .Shadow}} (shadow){{end}}{{if .Maintenance}} (maintenance){{end}}{{if not .SnoozedUntil.IsZero}} (snoozed until {{.SnoozedUntil.Format "15:04"}}){{end}}</td>
+<td>{{ago .LastCheck}}</td>
+<td>{{range .RecentLatencyMs}}{{printf "%.1f" .}} {{end}}</td>
+<td>{{if .P50LatencyMs}}{{printf "%.1f / %.1f / %.1f" .P50LatencyMs .P95LatencyMs .P99LatencyMs}}{{end}}</td>
+<td>{{.Error}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (d *daemon) handleStatusPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	statusPage.Execute(w, struct {
+		Checks    []checkStatus
+		Scheduler schedulerStatus
+	}{append(d.status(), d.selfStatus()...), d.schedulerStatus()})
+}
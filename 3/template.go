@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are the helpers available in config templates.
+var templateFuncs = template.FuncMap{
+	"env": os.Getenv,
+	"file": func(path string) (string, error) {
+		b, err := os.ReadFile(path)
+		return string(b), err
+	},
+	// default returns def if v is empty, e.g. {{env "PORT" | default "8080"}}.
+	"default": func(def string, v string) string {
+		if v == "" {
+			return def
+		}
+		return v
+	},
+	"list":  func(vs ...any) []any { return vs },
+	"split": func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":  func(sep string, vs []string) string { return strings.Join(vs, sep) },
+	"trim":  strings.TrimSpace,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	// json encodes v, e.g. to quote strings: {{json .}}.
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// last reports whether i is the last index of a list of length n, to
+	// avoid trailing commas: {{range $i, $e := $l}}...{{if not (last $i (len $l))}},{{end}}{{end}}.
+	"last": func(i, n int) bool { return i == n-1 },
+}
+
+// executeTemplate runs the config through text/template.
+func executeTemplate(filepath string, data []byte) ([]byte, error) {
+	t, err := template.New(filepath).Funcs(templateFuncs).Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// The drill and snooze subcommands ask a running daemon to treat a check
+// differently for a while:
+//
+//   - a drill fails the check so teams can rehearse paging and escalation
+//     end to end. The failures say DRILL and don't count in reports.
+//   - a snooze suppresses the check's alerts. When it expires while the
+//     check is still unhealthy the daemon alerts again as a reminder, so
+//     muted checks aren't forgotten.
+var timedActions = map[string]struct {
+	usage string
+	field func(*entry) *time.Time
+}{
+	"drill":  {"fail the check", func(e *entry) *time.Time { return &e.drillUntil }},
+	"snooze": {"suppress the check's alerts", func(e *entry) *time.Time { return &e.snoozeUntil }},
+}
+
+// timedCommand returns the subcommand of the timed action.
+func timedCommand(action string) func(args []string) error {
+	return func(args []string) error {
+		fs := flag.NewFlagSet(action, flag.ExitOnError)
+		addr := fs.String("addr", "http://127.0.0.1:9090", "`URL` of the daemon's -listen address, whose -api-token is taken from $HEALTHCHECK_API_TOKEN")
+		duration := fs.Duration("for", 10*time.Minute, timedActions[action].usage+" for `duration`, 0 ends the "+action)
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "usage: %s [flags] <name or url>\n", action)
+			fs.PrintDefaults()
+		}
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return errors.New("missing health check name or URL")
+		}
+
+		var s timedStatus
+		q := url.Values{"id": {fs.Arg(0)}, "for": {duration.String()}}
+		if err := postAPI(*addr, "/api/checks/"+action, q, &s); err != nil {
+			return err
+		}
+		if s.Until.IsZero() {
+			fmt.Printf("%s of %s ended\n", action, s.ID)
+		} else {
+			fmt.Printf("%s of %s until %s\n", action, s.ID, s.Until.Local().Format(time.DateTime))
+		}
+		return nil
+	}
+}
+
+// postAPI posts to the path of the daemon's API at addr, authenticated by
+// the token in $HEALTHCHECK_API_TOKEN, and decodes the JSON response into v.
+func postAPI(addr, path string, q url.Values, v any) error {
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(addr, "/")+path+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if token := os.Getenv("HEALTHCHECK_API_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type timedStatus struct {
+	ID    string
+	Until time.Time `json:",omitzero"`
+}
+
+// handleTimed starts the timed action for the check with the id query
+// parameter for the duration of the for parameter, or ends it if that's 0.
+func (d *daemon) handleTimed(action string) http.HandlerFunc {
+	field := timedActions[action].field
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		duration, err := time.ParseDuration(r.URL.Query().Get("for"))
+		if err != nil || duration < 0 {
+			http.Error(w, "for must be a duration like 10m", http.StatusBadRequest)
+			return
+		}
+		var until time.Time
+		if duration > 0 {
+			until = time.Now().Add(duration).Round(time.Second)
+		}
+		d.mu.Lock()
+		var e *entry
+		for _, o := range d.entries {
+			if o.check.ID() == id {
+				e = o
+			}
+		}
+		if e != nil {
+			*field(e) = until
+		}
+		d.mu.Unlock()
+		if e == nil {
+			http.Error(w, fmt.Sprintf("no check %q", id), http.StatusNotFound)
+			return
+		}
+
+		msg := "ended"
+		if until.IsZero() {
+			slog.Info(action+" ended", checkAttrs(e.check)...)
+		} else {
+			slog.Warn(action+" started", append(checkAttrs(e.check), "until", until)...)
+			msg = "until " + until.Format(time.RFC3339)
+		}
+		if d.audit != nil {
+			actor, _, _ := net.SplitHostPort(r.RemoteAddr)
+			d.audit.log(auditEvent{Time: time.Now(), Action: action, Check: id, Actor: actor, Message: msg})
+		}
+		writeJSON(w, http.StatusOK, timedStatus{ID: id, Until: until})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// httpTiming breaks down where the time of HTTP checks went. Phases of
+// several requests, e.g. redirects or transaction steps, are summed.
+type httpTiming struct {
+	DNS      time.Duration `json:",omitempty"` // resolving the host name
+	Connect  time.Duration `json:",omitempty"` // establishing TCP connections
+	TLS      time.Duration `json:",omitempty"` // TLS handshakes
+	TTFB     time.Duration `json:",omitempty"` // from writing requests to the first byte of their responses
+	Transfer time.Duration `json:",omitempty"` // from the first to the last byte of responses
+}
+
+// phases returns the phases of t by name, as used in metrics.
+func (t httpTiming) phases() []struct {
+	name string
+	d    time.Duration
+} {
+	return []struct {
+		name string
+		d    time.Duration
+	}{{"dns", t.DNS}, {"connect", t.Connect}, {"tls", t.TLS}, {"ttfb", t.TTFB}, {"transfer", t.Transfer}}
+}
+
+func (t httpTiming) LogValue() slog.Value {
+	var attrs []slog.Attr
+	for _, p := range t.phases() {
+		if p.d > 0 {
+			attrs = append(attrs, slog.Duration(p.name, p.d))
+		}
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// timing records the phases of the HTTP requests of a check.
+type timing struct {
+	mu   sync.Mutex
+	used bool // there was an HTTP request
+	t    httpTiming
+
+	// Starts of the phases in progress.
+	dnsStart, connectStart, tlsStart, wrote, firstByte time.Time
+}
+
+type timingKey struct{}
+
+// withTiming returns ctx with a timing that HTTP requests are recorded in.
+func withTiming(ctx context.Context) (context.Context, *timing) {
+	t := &timing{}
+	return context.WithValue(ctx, timingKey{}, t), t
+}
+
+// timingOf returns the timing of ctx, nil if it has none.
+func timingOf(ctx context.Context) *timing {
+	t, _ := ctx.Value(timingKey{}).(*timing)
+	return t
+}
+
+// traceTiming returns ctx with a trace that records the phases of HTTP
+// requests in the timing of ctx. Callers must call its done once they
+// read the response body.
+func traceTiming(ctx context.Context) context.Context {
+	t := timingOf(ctx)
+	if t == nil {
+		return ctx
+	}
+	// lock runs f with t locked.
+	lock := func(f func()) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.used = true
+		f()
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { lock(func() { t.dnsStart = time.Now() }) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { lock(func() { t.t.DNS += time.Since(t.dnsStart) }) },
+		ConnectStart: func(network, addr string) {
+			lock(func() {
+				// Dialing several addresses at once counts from the first.
+				if t.connectStart.IsZero() {
+					t.connectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone: func(network, addr string, err error) {
+			lock(func() {
+				if !t.connectStart.IsZero() {
+					t.t.Connect += time.Since(t.connectStart)
+					t.connectStart = time.Time{}
+				}
+			})
+		},
+		TLSHandshakeStart: func() { lock(func() { t.tlsStart = time.Now() }) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			lock(func() { t.t.TLS += time.Since(t.tlsStart) })
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) { lock(func() { t.wrote = time.Now() }) },
+		GotFirstResponseByte: func() {
+			lock(func() {
+				t.firstByte = time.Now()
+				t.t.TTFB += t.firstByte.Sub(t.wrote)
+			})
+		},
+	})
+}
+
+// done records the end of the transfer of a response body.
+func (t *timing) done() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.firstByte.IsZero() {
+		t.t.Transfer += time.Since(t.firstByte)
+		t.firstByte = time.Time{}
+	}
+}
+
+// result returns the recorded timing, nil if there were no HTTP requests.
+func (t *timing) result() *httpTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.used {
+		return nil
+	}
+	r := t.t
+	return &r
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// step is a request of a transaction check.
+type step struct {
+	Name              string `json:",omitempty"` // shown in errors, defaults to the step's number
+	Method            string `json:",omitempty"` // defaults to GET, or POST if there's a Body
+	URL               string
+	Header            map[string]string `json:",omitempty"`
+	Body              string            `json:",omitempty"`
+	HealthyStatusCode int               `json:",omitempty"` // zero means 200
+
+	// Capture names values of the response for the following steps,
+	// which use them as {{name}} in their URL, Header and Body. Values
+	// are "header:X-Token", "cookie:session" or "json:.data.token", a
+	// path as shown by assert-preview.
+	Capture map[string]string `json:",omitempty"`
+}
+
+// placeholderRE matches the {{name}} of captured values.
+var placeholderRE = regexp.MustCompile(`\{\{([A-Za-z_][A-Za-z0-9_]*)\}\}`)
+
+// transactionChecker runs the Steps of a check in order, e.g. logging in
+// and then getting an authenticated page. It's healthy if every step is.
+// Cookies are kept between the steps.
+type transactionChecker struct {
+	h HealthCheck
+}
+
+func init() {
+	registerChecker("transaction", newTransactionChecker)
+}
+
+func newTransactionChecker(h HealthCheck) (Checker, error) {
+	var errs []error
+	if h.Name == "" {
+		errs = append(errs, errors.New("transaction checks need a Name"))
+	}
+	if len(h.Steps) == 0 {
+		errs = append(errs, errors.New("missing Steps"))
+	}
+	if h.Auth != nil {
+		if err := h.Auth.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	captured := make(map[string]bool)
+	for i, s := range h.Steps {
+		name := s.name(i)
+		if _, err := parseURL(HealthCheck{URL: placeholderRE.ReplaceAllString(s.URL, "x")}, "http", "https"); err != nil {
+			errs = append(errs, fmt.Errorf("step %s: %v", name, err))
+		}
+		if s.HealthyStatusCode != 0 && (s.HealthyStatusCode < 100 || s.HealthyStatusCode > 599) {
+			errs = append(errs, fmt.Errorf("step %s: HealthyStatusCode %d is not a valid HTTP status code", name, s.HealthyStatusCode))
+		}
+		uses := []string{s.URL, s.Body}
+		for _, v := range s.Header {
+			uses = append(uses, v)
+		}
+		for _, u := range uses {
+			for _, m := range placeholderRE.FindAllStringSubmatch(u, -1) {
+				if !captured[m[1]] {
+					errs = append(errs, fmt.Errorf("step %s: {{%s}} isn't captured by an earlier step", name, m[1]))
+				}
+			}
+		}
+		for k, from := range s.Capture {
+			kind, _, _ := strings.Cut(from, ":")
+			if kind != "header" && kind != "cookie" && kind != "json" {
+				errs = append(errs, fmt.Errorf("step %s: Capture %s must be header:, cookie: or json:, not %q", name, k, from))
+			}
+			captured[k] = true
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return transactionChecker{h: h}, nil
+}
+
+func (s step) name(i int) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return strconv.Itoa(i + 1)
+}
+
+func (c transactionChecker) Check(ctx context.Context) Result {
+	jar, _ := cookiejar.New(nil)
+	client := http.Client{Transport: c.h.transport, Jar: jar}
+	vars := make(map[string]string)
+	var done []string
+	for i, s := range c.h.Steps {
+		start := time.Now()
+		sctx, sp := startSpan(ctx, "step "+s.name(i))
+		resp, body, err := s.do(sctx, &client, c.h.Auth, vars)
+		if err == nil {
+			err = s.capture(resp, body, vars)
+		}
+		sp.finish(err)
+		if err != nil {
+			return Result{Err: fmt.Errorf("step %s: %v", s.name(i), err), Output: strings.Join(done, ", ")}
+		}
+		done = append(done, fmt.Sprintf("%s %d in %v", s.name(i), resp.StatusCode, time.Since(start).Round(time.Millisecond)))
+	}
+	return Result{Healthy: true, Output: strings.Join(done, ", ")}
+}
+
+// do sends the request of s with the captured vars filled in and reads the
+// whole response. Unless the step sets its own Authorization header, the
+// request carries the bearer token of auth if it's not nil.
+func (s step) do(ctx context.Context, client *http.Client, auth *authConfig, vars map[string]string) (*http.Response, []byte, error) {
+	fill := func(v string) string {
+		return placeholderRE.ReplaceAllStringFunc(v, func(m string) string {
+			return vars[m[2:len(m)-2]]
+		})
+	}
+	method := s.Method
+	if method == "" {
+		method = http.MethodGet
+		if s.Body != "" {
+			method = http.MethodPost
+		}
+	}
+	var body io.Reader
+	if s.Body != "" {
+		body = strings.NewReader(fill(s.Body))
+	}
+	req, err := http.NewRequestWithContext(traceSpans(traceSource(traceTiming(ctx))), method, fill(s.URL), body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if auth != nil {
+		if err := auth.authorize(req, client.Transport); err != nil {
+			return nil, nil, err
+		}
+	}
+	for k, v := range s.Header {
+		req.Header.Set(k, fill(v))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if auth != nil && resp.StatusCode == http.StatusUnauthorized {
+		auth.forget()
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	timingOf(ctx).done()
+	if want := cmp.Or(s.HealthyStatusCode, http.StatusOK); resp.StatusCode != want {
+		return resp, b, fmt.Errorf("got status %d, want %d", resp.StatusCode, want)
+	}
+	return resp, b, nil
+}
+
+// capture stores the values named by s.Capture in vars.
+func (s step) capture(resp *http.Response, body []byte, vars map[string]string) error {
+	var doc any
+	for k, from := range s.Capture {
+		kind, what, _ := strings.Cut(from, ":")
+		var v string
+		var ok bool
+		switch kind {
+		case "header":
+			v = resp.Header.Get(what)
+			ok = v != ""
+		case "cookie":
+			for _, c := range resp.Cookies() {
+				if c.Name == what {
+					v, ok = c.Value, true
+				}
+			}
+		case "json":
+			if doc == nil {
+				if err := json.Unmarshal(body, &doc); err != nil {
+					return fmt.Errorf("capturing %s: the body isn't JSON: %v", k, err)
+				}
+			}
+			v, ok = jsonPath(doc, what)
+		}
+		if !ok {
+			return fmt.Errorf("capturing %s: no %s in the response", k, from)
+		}
+		vars[k] = v
+	}
+	return nil
+}
+
+// jsonPath returns the value at path, e.g. .data.items[0].id, in doc.
+// Strings are returned as is, other values as JSON.
+func jsonPath(doc any, path string) (string, bool) {
+	if !strings.HasPrefix(path, ".") && !strings.HasPrefix(path, "[") {
+		path = "." + path
+	}
+	v := doc
+	for path != "" {
+		switch {
+		case path[0] == '.':
+			path = path[1:]
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			m, ok := v.(map[string]any)
+			if !ok {
+				return "", false
+			}
+			if v, ok = m[path[:end]]; !ok {
+				return "", false
+			}
+			path = path[end:]
+		case path[0] == '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return "", false
+			}
+			i, err := strconv.Atoi(path[1:end])
+			a, ok := v.([]any)
+			if err != nil || !ok || i < 0 || i >= len(a) {
+				return "", false
+			}
+			v, path = a[i], path[end+1:]
+		default:
+			return "", false
+		}
+	}
+	if s, ok := v.(string); ok {
+		return s, true
+	}
+	b, err := json.Marshal(v)
+	return string(b), err == nil
+}
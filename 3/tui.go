@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// tuiLogLines is how many of the latest log lines -tui shows below the
+// checks.
+const tuiLogLines = 5
+
+// tuiLog keeps the latest log lines while -tui owns the terminal.
+type tuiLog struct {
+	mu    sync.Mutex
+	lines []string
+	buf   []byte    // of the line being written
+	out   io.Writer // logs are written here instead once the TUI is gone
+}
+
+func (l *tuiLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.out != nil {
+		return l.out.Write(p)
+	}
+	l.buf = append(l.buf, p...)
+	for {
+		i := slices.Index(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.lines = append(l.lines, string(l.buf[:i]))
+		l.buf = l.buf[i+1:]
+	}
+	if len(l.lines) > tuiLogLines {
+		l.lines = l.lines[len(l.lines)-tuiLogLines:]
+	}
+	return len(p), nil
+}
+
+// detach writes further logs to out.
+func (l *tuiLog) detach(out io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out = out
+}
+
+func (l *tuiLog) last() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.lines)
+}
+
+// ANSI escape sequences of the TUI.
+const (
+	ansiAltScreen  = "\x1b[?1049h\x1b[?25l" // and hide the cursor
+	ansiMainScreen = "\x1b[?25h\x1b[?1049l"
+	ansiHome       = "\x1b[H\x1b[2J"
+	ansiGreen      = "\x1b[32m"
+	ansiRed        = "\x1b[31m"
+	ansiYellow     = "\x1b[33m"
+	ansiDim        = "\x1b[02m"
+	ansiBold       = "\x1b[01m"
+	ansiReset      = "\x1b[0m"
+)
+
+// sparkline draws values as a line of bars scaled to the largest.
+func sparkline(values []float64) string {
+	const bars = "▁▂▃▄▅▆▇█"
+	levels := []rune(bars)
+	top := 0.0
+	for _, v := range values {
+		top = max(top, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if top > 0 {
+			i = min(int(v/top*float64(len(levels))), len(levels)-1)
+		}
+		b.WriteRune(levels[i])
+	}
+	return b.String()
+}
+
+// tuiWidth returns the width of the terminal as told by $COLUMNS.
+func tuiWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 40 {
+		return n
+	}
+	return 120
+}
+
+// runTUI redraws a table of the checks on w every second, for watching a
+// deploy, until ctx is done. Then the logs go to stderr again.
+func (d *daemon) runTUI(ctx context.Context, w io.Writer, log *tuiLog) {
+	fmt.Fprint(w, ansiAltScreen)
+	defer log.detach(os.Stderr)
+	defer fmt.Fprint(w, ansiMainScreen)
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		d.drawTUI(w, log)
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func (d *daemon) drawTUI(w io.Writer, log *tuiLog) {
+	status := d.status()
+	counts := make(map[string]int)
+	for _, cs := range status {
+		counts[cs.State]++
+	}
+	var b strings.Builder
+	b.WriteString(ansiHome)
+	fmt.Fprintf(&b, "%s%s%s  %d healthy, %d unhealthy, %d other  %s%s%s\n\n", ansiBold, tr("Health checks"), ansiReset,
+		counts["healthy"], counts["unhealthy"], len(status)-counts["healthy"]-counts["unhealthy"], ansiDim, time.Now().Format(time.TimeOnly), ansiReset)
+
+	// The escape sequences of a column are of the same length in every
+	// row, so they don't throw the tabwriter off.
+	tw := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	state, rest, _ := strings.Cut(tr("STATE\tCHECK\tLATENCY\tLAST\tUPTIME\tERROR"), "\t")
+	fmt.Fprintf(tw, "%s%s%s\t%s\n", ansiBold, state, ansiReset, rest)
+	width := tuiWidth()
+	for _, cs := range status {
+		color := ansiYellow
+		switch cs.State {
+		case "healthy":
+			color = ansiGreen
+		case "unhealthy":
+			color = ansiRed
+		}
+		last, uptime := "-", "-"
+		if n := len(cs.RecentLatencyMs); n > 0 {
+			last = fmt.Sprintf("%.0fms", cs.RecentLatencyMs[n-1])
+			uptime = fmt.Sprintf("%.2f%%", cs.Uptime)
+		}
+		id := cs.Name
+		if id == "" {
+			id = cs.URL
+		}
+		// What's left of the line for the error, roughly.
+		room := max(width-len(id)-len(cs.RecentLatencyMs)-40, 20)
+		errText := cs.Error
+		if r := []rune(errText); len(r) > room {
+			errText = string(r[:room-1]) + "…"
+		}
+		fmt.Fprintf(tw, "%s%s%s\t%s\t%s\t%s\t%s\t%s\n", color, tr(cs.State), ansiReset, id, sparkline(cs.RecentLatencyMs), last, uptime, errText)
+	}
+	tw.Flush()
+	if lines := log.last(); len(lines) > 0 {
+		b.WriteString("\n" + ansiDim)
+		for _, l := range lines {
+			if r := []rune(l); len(r) > width {
+				l = string(r[:width])
+			}
+			b.WriteString(l + "\n")
+		}
+		b.WriteString(ansiReset)
+	}
+	io.WriteString(w, b.String())
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+)
+
+func validate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configFile := addConfigFlags(fs)
+	fs.Parse(args)
+
+	healthChecks, lines, problems, err := readConfigLines(*configFile)
+	if err != nil {
+		return err
+	}
+	problems = append(problems, validateChecks(healthChecks)...)
+	problems = append(problems, dependencyProblems(healthChecks)...)
+	slices.SortStableFunc(problems, func(a, b problem) int { return a.Check - b.Check })
+	printProblems(*configFile, healthChecks, lines, problems)
+	if len(problems) > 0 {
+		return errors.New(tr("%s: %d problem(s) found", *configFile, len(problems)))
+	}
+	return nil
+}
+
+// validateChecks returns the problems that make health checks unusable.
+func validateChecks(hs []HealthCheck) []problem {
+	var problems []problem
+	add := func(i int, format string, args ...any) {
+		problems = append(problems, problem{Check: i, Msg: fmt.Sprintf(format, args...)})
+	}
+	names := make(map[string]int)
+	for i, h := range hs {
+		if h.Name != "" {
+			if j, ok := names[h.Name]; ok {
+				add(i, "Name %q already used by check %d", h.Name, j+1)
+			} else {
+				names[h.Name] = i
+			}
+		}
+		if _, err := h.checker(); err != nil {
+			if errs, ok := err.(interface{ Unwrap() []error }); ok {
+				for _, err := range errs.Unwrap() {
+					add(i, "%v", err)
+				}
+			} else {
+				add(i, "%v", err)
+			}
+		}
+		for _, w := range h.Maintenance {
+			if !w.End.After(w.Start) {
+				add(i, "Maintenance: End %v must be after Start %v", w.End, w.Start)
+			}
+		}
+		if h.ResponseTimeout < 0 && h.ResponseTimeout != noTimeout {
+			add(i, "ResponseTimeout must not be negative, use \"none\" or -1 to disable it")
+		}
+	}
+	return problems
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var varRE = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandVars replaces ${VAR} in the raw JSON config with the value of VAR
+// from the secrets file or the environment. Values are JSON escaped so
+// they can't break the config's syntax or shift its lines.
+func expandVars(filepath string, data []byte) ([]byte, error) {
+	if !varRE.Match(data) {
+		return data, nil
+	}
+	secrets, err := readSecrets(secretsFile)
+	if err != nil {
+		return nil, err
+	}
+	var undefined error
+	out := varRE.ReplaceAllFunc(data, func(m []byte) []byte {
+		name := string(varRE.FindSubmatch(m)[1])
+		v, ok := secrets[name]
+		if !ok {
+			v, ok = os.LookupEnv(name)
+		}
+		if !ok {
+			if undefined == nil {
+				line := 1 + bytes.Count(data[:bytes.Index(data, m)], []byte("\n"))
+				undefined = fmt.Errorf("%s:%d: undefined variable %s", filepath, line, name)
+			}
+			return m
+		}
+		quoted, _ := json.Marshal(v)
+		return quoted[1 : len(quoted)-1]
+	})
+	if undefined != nil {
+		return nil, undefined
+	}
+	return out, nil
+}
+
+// readSecrets parses a file of KEY=VALUE lines. Blank lines and lines
+// starting with # are ignored, values may be quoted.
+func readSecrets(filepath string) (map[string]string, error) {
+	secrets := make(map[string]string)
+	if filepath == "" {
+		return secrets, nil
+	}
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want KEY=VALUE", filepath, n)
+		}
+		v = strings.TrimSpace(v)
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		secrets[strings.TrimSpace(k)] = v
+	}
+	return secrets, s.Err()
+}
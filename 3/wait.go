@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// wait polls health checks until they're healthy, like wait-for-it.sh in
+// container entrypoints.
+func wait(args []string) error {
+	fs := flag.NewFlagSet("wait", flag.ExitOnError)
+	configFile := addConfigFlags(fs)
+	var targets []string
+	fs.Func("for", "wait for the check with this name, or for this `URL` to return 200 (repeatable)", func(s string) error {
+		targets = append(targets, s)
+		return nil
+	})
+	mode := fs.String("mode", "all", "with several -for, wait for `all` or any of them")
+	timeout := fs.Duration("timeout", 2*time.Minute, "give up after `duration`")
+	interval := fs.Duration("interval", 2*time.Second, "check every `duration`")
+	quiet := fs.Bool("quiet", false, "don't log progress")
+	setupLog := addLogFlags(fs)
+	fs.Parse(args)
+	if err := setupLog(); err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return errors.New("wait: missing -for")
+	}
+	if *mode != "all" && *mode != "any" {
+		return fmt.Errorf("wait: -mode must be all or any, not %q", *mode)
+	}
+
+	var checks []HealthCheck
+	for _, t := range targets {
+		h, err := findCheck(*configFile, t)
+		if err != nil {
+			return err
+		}
+		checks = append(checks, h)
+	}
+
+	start := time.Now()
+	deadline := start.Add(*timeout)
+	healthy := make([]bool, len(checks))
+	errs := make([]error, len(checks))
+	for {
+		var wg sync.WaitGroup
+		for i, h := range checks {
+			if healthy[i] {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				// Don't let a hanging attempt take us past the deadline.
+				remaining := time.Until(deadline)
+				if remaining <= 0 {
+					// A zero ResponseTimeout would mean none.
+					errs[i] = context.DeadlineExceeded
+					return
+				}
+				if t := h.timeout(); t == 0 || t > remaining {
+					h.ResponseTimeout = duration(remaining)
+				}
+				r := h.Do()
+				healthy[i], errs[i] = r.Healthy, r.Err
+				if healthy[i] && !*quiet {
+					slog.Info("healthy", append(checkAttrs(h), "after", time.Since(start).Round(time.Millisecond))...)
+				}
+			}()
+		}
+		wg.Wait()
+
+		var waiting []string
+		for i, h := range checks {
+			if !healthy[i] {
+				waiting = append(waiting, h.ID())
+			}
+		}
+		if len(waiting) == 0 || *mode == "any" && len(waiting) < len(checks) {
+			return nil
+		}
+		if time.Now().Add(*interval).After(deadline) {
+			var msgs []string
+			for i, h := range checks {
+				if !healthy[i] {
+					msgs = append(msgs, fmt.Sprintf("%s: %v", h.ID(), errs[i]))
+				}
+			}
+			return errors.New(tr("still unhealthy after %v: %s", *timeout, strings.Join(msgs, "; ")))
+		}
+		if !*quiet {
+			slog.Info("waiting", "for", waiting, "healthy", len(checks)-len(waiting), "total", len(checks))
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// findCheck returns the configured health check with the given ID or, if
+// target looks like a URL, a check expecting it to return 200.
+func findCheck(configFile, target string) (HealthCheck, error) {
+	if strings.Contains(target, "://") {
+		return HealthCheck{URL: target, HealthyStatusCode: http.StatusOK}, nil
+	}
+	healthChecks, err := readConfig(configFile)
+	if err != nil {
+		return HealthCheck{}, err
+	}
+	i := indexCheck(healthChecks, target)
+	if i < 0 {
+		return HealthCheck{}, errors.New(tr("no check %q in %s", target, configFile))
+	}
+	return healthChecks[i], nil
+}
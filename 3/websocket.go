@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// websocketChecker is healthy if the WebSocket opening handshake with a
+// ws:// or wss:// URL succeeds and, with WebSocketPing, the server answers
+// a ping with a pong. Proxies aren't used.
+type websocketChecker struct {
+	h    HealthCheck
+	url  *url.URL
+	addr string
+}
+
+func init() {
+	registerChecker("ws", newWebSocketChecker)
+	registerChecker("wss", newWebSocketChecker)
+	registerChecker("websocket", newWebSocketChecker)
+}
+
+func newWebSocketChecker(h HealthCheck) (Checker, error) {
+	u, err := parseURL(h, "ws", "wss")
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"ws": "80", "wss": "443"}[u.Scheme]
+	}
+	return websocketChecker{h: h, url: u, addr: net.JoinHostPort(u.Hostname(), port)}, nil
+}
+
+// websocketGUID is appended to the key to compute Sec-WebSocket-Accept
+// (RFC 6455, section 1.3).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func (c websocketChecker) Check(ctx context.Context) Result {
+	conn, err := c.h.dialContext(ctx, c.addr)
+	if err != nil {
+		return Result{Err: err}
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if c.url.Scheme == "wss" {
+		cfg := c.h.tlsConfig(c.url.Hostname())
+		cfg.NextProtos = []string{"http/1.1"}
+		tc := tls.Client(conn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return Result{Err: err}
+		}
+		conn = tc
+	}
+
+	key := make([]byte, 16)
+	rand.Read(key)
+	encodedKey := base64.StdEncoding.EncodeToString(key)
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: c.url.Path, RawPath: c.url.RawPath, RawQuery: c.url.RawQuery},
+		Host:       c.url.Host,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {encodedKey},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	if err := req.Write(conn); err != nil {
+		return Result{Err: err}
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return Result{Err: err}
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return Result{Err: fmt.Errorf("got status %d, want %d for the WebSocket upgrade", resp.StatusCode, http.StatusSwitchingProtocols)}
+	}
+	sum := sha1.Sum([]byte(encodedKey + websocketGUID))
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != base64.StdEncoding.EncodeToString(sum[:]) {
+		return Result{Err: fmt.Errorf("invalid Sec-WebSocket-Accept %q", got)}
+	}
+
+	if c.h.WebSocketPing {
+		payload := []byte("health check")
+		if err := writeFrame(conn, 0x9, payload); err != nil {
+			return Result{Err: err}
+		}
+		if err := awaitPong(br, payload); err != nil {
+			return Result{Err: err}
+		}
+	}
+	writeFrame(conn, 0x8, []byte{0x03, 0xe8}) // close, normal closure
+	return Result{Healthy: true}
+}
+
+// writeFrame writes a final, masked frame as clients must send them.
+func writeFrame(w io.Writer, opcode byte, payload []byte) error {
+	// Control frames are never longer than 125 bytes.
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+// awaitPong reads frames until the pong with payload, skipping messages
+// the server sends meanwhile.
+func awaitPong(r *bufio.Reader, payload []byte) error {
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return fmt.Errorf("waiting for pong: %v", err)
+		}
+		opcode, masked := hdr[0]&0x0f, hdr[1]&0x80 != 0
+		n := uint64(hdr[1] & 0x7f)
+		switch n {
+		case 126:
+			var b [2]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return err
+			}
+			n = uint64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return err
+			}
+			n = binary.BigEndian.Uint64(b[:])
+		}
+		var mask [4]byte
+		if masked {
+			if _, err := io.ReadFull(r, mask[:]); err != nil {
+				return err
+			}
+		}
+		if opcode != 0xa {
+			if opcode == 0x8 {
+				return errors.New("server closed the connection instead of answering the ping")
+			}
+			if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+				return err
+			}
+			continue
+		}
+		if n > 125 {
+			return fmt.Errorf("invalid pong of %d bytes", n)
+		}
+		got := make([]byte, n)
+		if _, err := io.ReadFull(r, got); err != nil {
+			return err
+		}
+		if masked {
+			for i := range got {
+				got[i] ^= mask[i%4]
+			}
+		}
+		if bytes.Equal(got, payload) {
+			return nil
+		}
+	}
+}
@@ -1,14 +1,122 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 )
 
-func HealthzHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("healthy"))
+// Checks serves named sub-checks like the Kubernetes API server: /livez
+// runs the liveness checks, /readyz the liveness and readiness checks and
+// /healthz all of them. ?verbose lists the result of every check and
+// ?exclude=name (repeatable) skips a check. A single check is served at e.g.
+// /readyz/name. It's kept in this file so it can be copied into a service.
+type Checks struct {
+	mu     sync.Mutex
+	checks []check
+}
+
+type check struct {
+	name  string
+	ready bool // readiness-only, not run by /livez
+	fn    func(*http.Request) error
+}
+
+// AddLivez registers a liveness check. A failing liveness check means the
+// process should be restarted.
+func (c *Checks) AddLivez(name string, fn func(*http.Request) error) {
+	c.add(check{name: name, fn: fn})
+}
+
+// AddReadyz registers a readiness check. A failing readiness check means
+// the process shouldn't get traffic for now.
+func (c *Checks) AddReadyz(name string, fn func(*http.Request) error) {
+	c.add(check{name: name, ready: true, fn: fn})
+}
+
+func (c *Checks) add(ch check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if slices.ContainsFunc(c.checks, func(o check) bool { return o.name == ch.name }) {
+		panic("healthz: duplicate check " + ch.name)
+	}
+	c.checks = append(c.checks, ch)
+}
+
+// Install registers the /livez, /readyz and /healthz endpoints on mux.
+func (c *Checks) Install(mux *http.ServeMux) {
+	for _, path := range []string{"livez", "readyz", "healthz"} {
+		mux.HandleFunc("GET /"+path, c.handler(path))
+		mux.HandleFunc("GET /"+path+"/{name}", c.handler(path))
+	}
+}
+
+// selected returns the checks of the endpoint.
+func (c *Checks) selected(path string) []check {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var cs []check
+	for _, ch := range c.checks {
+		if path == "livez" && ch.ready {
+			continue
+		}
+		cs = append(cs, ch)
+	}
+	return cs
+}
+
+func (c *Checks) handler(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checks := c.selected(path)
+		if name := r.PathValue("name"); name != "" {
+			i := slices.IndexFunc(checks, func(ch check) bool { return ch.name == name })
+			if i < 0 {
+				http.NotFound(w, r)
+				return
+			}
+			checks = checks[i : i+1]
+		}
+
+		excluded := r.URL.Query()["exclude"]
+		var out strings.Builder
+		failed := false
+		for _, ch := range checks {
+			if slices.Contains(excluded, ch.name) {
+				fmt.Fprintf(&out, "[+]%s excluded: ok\n", ch.name)
+				excluded = slices.DeleteFunc(excluded, func(s string) bool { return s == ch.name })
+				continue
+			}
+			if err := ch.fn(r); err != nil {
+				// Like Kubernetes, don't tell anonymous clients why.
+				log.Printf("%s check %s failed: %v", path, ch.name, err)
+				fmt.Fprintf(&out, "[-]%s failed: reason withheld\n", ch.name)
+				failed = true
+				continue
+			}
+			fmt.Fprintf(&out, "[+]%s ok\n", ch.name)
+		}
+		if len(excluded) > 0 {
+			fmt.Fprintf(&out, "warn: some health checks cannot be excluded: no matches for %q\n", excluded)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "%s%s check failed\n", out.String(), path)
+			return
+		}
+		if _, verbose := r.URL.Query()["verbose"]; verbose {
+			fmt.Fprintf(w, "%s%s check passed\n", out.String(), path)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}
 }
 
 func Healthz2Handler(w http.ResponseWriter, r *http.Request) {
@@ -23,11 +131,23 @@ func Healthz3Handler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	http.HandleFunc("/healthz", HealthzHandler)
-	http.HandleFunc("/healthz2", Healthz2Handler)
-	http.HandleFunc("/healthz3", Healthz3Handler)
+	var checks Checks
+	checks.AddLivez("ping", func(*http.Request) error { return nil })
+	checks.AddReadyz("disk", func(*http.Request) error {
+		f, err := os.CreateTemp("", "healthz")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	})
+
+	mux := http.NewServeMux()
+	checks.Install(mux)
+	mux.HandleFunc("/healthz2", Healthz2Handler)
+	mux.HandleFunc("/healthz3", Healthz3Handler)
 
 	port := "8080"
 	log.Printf("Starting server on port %s ...", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	log.Fatal(http.ListenAndServe(":"+port, mux))
 }